@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"musthave-metrics/cmd/agent/config"
+	"musthave-metrics/internal/compress"
+	"musthave-metrics/internal/service"
 )
 
 type Locallink struct {
@@ -19,6 +21,15 @@ type Locallink struct {
 	RateLimit       int
 	PublicKeyPath   string
 	SecretToken     string
+	Collectors      map[string]config.CollectorConfig
+	StatsDAddr      string
+	StatsDSocket    string
+	AgentID         string
+	Version         string
+	Group           string
+	ConfigPoll      int
+	ConfigVersion   string
+	Explicit        map[string]bool
 }
 
 func (locallink *Locallink) Run() error {
@@ -27,13 +38,26 @@ func (locallink *Locallink) Run() error {
 	locallink.RunAddr = cfg.FlagRunAddr
 	locallink.Method = "/update/"
 	locallink.ContentType = "text/plain"
-	locallink.ContentEncoding = "gzip"
+	locallink.ContentEncoding = compress.Normalize(cfg.FlagCompress)
+	if !compress.Supported(locallink.ContentEncoding) {
+		return fmt.Errorf("unknown compression codec %q, use gzip, zstd, br or none", cfg.FlagCompress)
+	}
 	locallink.ReportInterval = cfg.FlagReportInterval
 	locallink.PollInterval = cfg.FlagPollInterval
 	locallink.HashKey = cfg.FlagHashKey
 	locallink.RateLimit = cfg.FlagRateLimit
 	locallink.PublicKeyPath = cfg.FlagCryptoKey
 	locallink.SecretToken = "SecretToken"
-	fmt.Printf("%s (!) Running server on %s, Report interval: %v, Poll interval: %v\n", time.Now().Format(time.DateTime), cfg.FlagRunAddr, cfg.FlagReportInterval, cfg.FlagPollInterval)
+	locallink.Collectors = cfg.Collectors
+	locallink.StatsDAddr = cfg.FlagStatsDAddr
+	locallink.StatsDSocket = cfg.FlagStatsDSocket
+	locallink.Group = cfg.FlagAgentGroup
+	locallink.ConfigPoll = cfg.FlagConfigPoll
+	locallink.Explicit = cfg.Explicit
+	locallink.AgentID = cfg.FlagAgentID
+	if locallink.AgentID == "" {
+		locallink.AgentID = service.DefaultAgentID()
+	}
+	fmt.Printf("%s (!) Running server on %s, Report interval: %v, Poll interval: %v, Agent ID: %s\n", time.Now().Format(time.DateTime), cfg.FlagRunAddr, cfg.FlagReportInterval, cfg.FlagPollInterval, locallink.AgentID)
 	return err
 }
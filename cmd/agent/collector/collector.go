@@ -0,0 +1,120 @@
+// Package collector предназначен для сбора метрик агента.
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+// Collector описывает источник метрик агента.
+type Collector interface {
+	// Name возвращает имя коллектора, под которым он зарегистрирован.
+	Name() string
+	// Collect собирает текущие значения метрик в m.
+	Collect(m *Metrics) error
+}
+
+// Factory создаёт новый экземпляр коллектора с заданными настройками.
+type Factory func(cfg config.CollectorConfig) (Collector, error)
+
+// Metrics хранит значения, собранные коллектором за один опрос.
+// Значения Counters — приращения, которые агент прибавляет к накопленным,
+// значения Gauges заменяют предыдущие.
+// Removed содержит имена метрик, которые агент должен перестать отправлять.
+type Metrics struct {
+	Counters map[string]int64
+	Gauges   map[string]string
+	Removed  []string
+}
+
+// Task — включённый коллектор с интервалом опроса.
+type Task struct {
+	Collector Collector
+	Interval  int
+}
+
+// registration хранит сведения о зарегистрированном коллекторе.
+type registration struct {
+	factory          Factory
+	enabledByDefault bool
+}
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]registration)
+)
+
+// NewMetrics создаёт пустой набор метрик.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Counters: make(map[string]int64),
+		Gauges:   make(map[string]string),
+	}
+}
+
+// Register регистрирует коллектор под именем name.
+// Коллекторы с enabledByDefault = false включаются только явно в конфигурации.
+// Повторная регистрация с тем же именем вызывает панику.
+func Register(name string, enabledByDefault bool, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = registration{factory: f, enabledByDefault: enabledByDefault}
+}
+
+// Registered возвращает отсортированный список имён зарегистрированных коллекторов.
+func Registered() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build создаёт включённые коллекторы согласно настройкам.
+// Коллекторы, для которых enabled не задан, включаются по умолчанию при регистрации.
+// Интервал опроса по умолчанию — pollInterval.
+func Build(settings map[string]config.CollectorConfig, pollInterval int) ([]Task, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for name := range settings {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector: %s", name)
+		}
+	}
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	tasks := make([]Task, 0, len(names))
+	for _, name := range names {
+		s := settings[name]
+		reg := registry[name]
+		enabled := reg.enabledByDefault
+		if s.Enabled != nil {
+			enabled = *s.Enabled
+		}
+		if !enabled {
+			continue
+		}
+		interval := s.Interval
+		if interval <= 0 {
+			interval = pollInterval
+		}
+		c, err := reg.factory(s)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		tasks = append(tasks, Task{Collector: c, Interval: interval})
+	}
+	return tasks, nil
+}
@@ -0,0 +1,313 @@
+package collector
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+func TestRegistered(t *testing.T) {
+	assert.Subset(t, Registered(), []string{"disk", "goruntime", "host", "network", "process", "runtime", "system"})
+}
+
+func TestBuild(t *testing.T) {
+	disabled, enabled := false, true
+	tests := []struct {
+		name      string
+		settings  map[string]config.CollectorConfig
+		wantErr   bool
+		wantTasks map[string]int
+	}{
+		{
+			name:      "defaults",
+			settings:  nil,
+			wantTasks: map[string]int{"goruntime": 2, "runtime": 2, "system": 2},
+		},
+		{
+			name: "disabled and interval",
+			settings: map[string]config.CollectorConfig{
+				"runtime": {Interval: 5},
+				"system":  {Enabled: &disabled},
+			},
+			wantTasks: map[string]int{"goruntime": 2, "runtime": 5},
+		},
+		{
+			name: "optional",
+			settings: map[string]config.CollectorConfig{
+				"network": {Enabled: &enabled, Include: []string{"^eth"}},
+			},
+			wantTasks: map[string]int{"goruntime": 2, "runtime": 2, "system": 2, "network": 2},
+		},
+		{
+			name:     "unknown",
+			settings: map[string]config.CollectorConfig{"unknown": {}},
+			wantErr:  true,
+		},
+		{
+			name: "bad filter",
+			settings: map[string]config.CollectorConfig{
+				"disk": {Enabled: &enabled, Exclude: []string{"("}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := Build(tt.settings, 2)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			for name, interval := range tt.wantTasks {
+				found := false
+				for _, task := range tasks {
+					if task.Collector.Name() == name {
+						found = true
+						assert.Equal(t, interval, task.Interval)
+					}
+				}
+				assert.True(t, found, name)
+			}
+			for _, task := range tasks {
+				if _, ok := tt.wantTasks[task.Collector.Name()]; !ok {
+					t.Errorf("unexpected collector %s", task.Collector.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestRuntimeCollector(t *testing.T) {
+	m := NewMetrics()
+	assert.NoError(t, (&RuntimeCollector{}).Collect(m))
+	assert.Equal(t, int64(1), m.Counters["PollCount"])
+	assert.NotEmpty(t, m.Gauges["RandomValue"])
+	assert.NotEmpty(t, m.Gauges["HeapAlloc"])
+}
+
+func TestSystemCollector(t *testing.T) {
+	m := NewMetrics()
+	assert.NoError(t, (&SystemCollector{}).Collect(m))
+	assert.NotEmpty(t, m.Gauges["TotalMemory"])
+	assert.NotEmpty(t, m.Gauges["FreeMemory"])
+	assert.GreaterOrEqual(t, len(m.Gauges), 3)
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.CollectorConfig
+		names []string
+		want  bool
+	}{
+		{name: "empty", cfg: config.CollectorConfig{}, names: []string{"eth0"}, want: true},
+		{name: "include", cfg: config.CollectorConfig{Include: []string{"^eth"}}, names: []string{"eth0"}, want: true},
+		{name: "not included", cfg: config.CollectorConfig{Include: []string{"^eth"}}, names: []string{"lo"}, want: false},
+		{name: "exclude", cfg: config.CollectorConfig{Exclude: []string{"^lo$"}}, names: []string{"lo"}, want: false},
+		{name: "any name", cfg: config.CollectorConfig{Include: []string{"^/dev/sd"}}, names: []string{"/", "/dev/sda1"}, want: true},
+		{name: "exclude wins", cfg: config.CollectorConfig{Include: []string{"^/"}, Exclude: []string{"^/boot"}}, names: []string{"/boot"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFilter(tt.cfg)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, f.match(tt.names...))
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "/", want: "root"},
+		{name: "/var/lib", want: "var_lib"},
+		{name: "eth0", want: "eth0"},
+		{name: "C:\\", want: "C_"},
+		{name: "br-1a2b.100", want: "br_1a2b_100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, label(tt.name))
+		})
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	d := make(deltas)
+	m := NewMetrics()
+	d.add(m, "NetBytesSent_eth0", 100)
+	assert.NotContains(t, m.Counters, "NetBytesSent_eth0")
+	d.add(m, "NetBytesSent_eth0", 150)
+	assert.Equal(t, int64(50), m.Counters["NetBytesSent_eth0"])
+	// сброс счётчика ОС не даёт отрицательного приращения
+	d.add(m, "NetBytesSent_eth0", 10)
+	assert.Equal(t, int64(50), m.Counters["NetBytesSent_eth0"])
+}
+
+func TestHostCollectors(t *testing.T) {
+	enabled := true
+	tasks, err := Build(map[string]config.CollectorConfig{
+		"disk":    {Enabled: &enabled},
+		"network": {Enabled: &enabled},
+		"host":    {Enabled: &enabled},
+	}, 1)
+	assert.NoError(t, err)
+	for _, task := range tasks {
+		t.Run(task.Collector.Name(), func(t *testing.T) {
+			m := NewMetrics()
+			assert.NoError(t, task.Collector.Collect(m))
+			if task.Collector.Name() == "host" {
+				assert.NotEmpty(t, m.Gauges["Load1"])
+				assert.NotEmpty(t, m.Gauges["ProcessCount"])
+			}
+		})
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "agent.pid")
+	assert.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0666))
+	c, err := newProcessCollector([]config.ProcessConfig{
+		{Name: "self", PidFile: pidFile},
+		{Name: "test binary", Pattern: regexp.QuoteMeta(filepath.Base(os.Args[0]))},
+		{Name: "missing", Pattern: "^no-such-process-name$"},
+	})
+	assert.NoError(t, err)
+
+	m := NewMetrics()
+	assert.NoError(t, c.Collect(m))
+	assert.Equal(t, "1", m.Gauges["ProcCount_self"])
+	assert.NotEmpty(t, m.Gauges["ProcRSS_self"])
+	assert.NotEmpty(t, m.Gauges["ProcThreads_self"])
+	assert.NotEmpty(t, m.Gauges["ProcCount_test_binary"])
+	assert.Empty(t, m.Gauges["ProcCount_missing"])
+	assert.Empty(t, m.Removed)
+
+	// процесс из PID-файла завершился — его метрики удаляются
+	assert.NoError(t, os.WriteFile(pidFile, []byte("2147483646"), 0666))
+	m = NewMetrics()
+	assert.NoError(t, c.Collect(m))
+	assert.Empty(t, m.Gauges["ProcCount_self"])
+	assert.Contains(t, m.Removed, "ProcCount_self")
+	assert.Contains(t, m.Removed, "ProcReadBytes_self")
+
+	// повторно метрики не удаляются
+	m = NewMetrics()
+	assert.NoError(t, c.Collect(m))
+	assert.Empty(t, m.Removed)
+}
+
+func TestNewProcessCollector(t *testing.T) {
+	tests := []struct {
+		name string
+		cfgs []config.ProcessConfig
+	}{
+		{name: "empty name", cfgs: []config.ProcessConfig{{Pattern: "nginx"}}},
+		{name: "no selector", cfgs: []config.ProcessConfig{{Name: "nginx"}}},
+		{name: "bad pattern", cfgs: []config.ProcessConfig{{Name: "nginx", Pattern: "("}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newProcessCollector(tt.cfgs)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGoRuntimeCollector(t *testing.T) {
+	c := newGoRuntimeCollector()
+	m := NewMetrics()
+	runtime.GC()
+	assert.NoError(t, c.Collect(m))
+	// gauge
+	assert.NotEmpty(t, m.Gauges["go_gc_heap_goal_bytes"])
+	// накопительный gauge назван как итог
+	assert.NotEmpty(t, m.Gauges["go_cpu_classes_gc_total_cpu_seconds_total"])
+	assert.NotContains(t, m.Gauges, "go_cpu_classes_gc_total_cpu_seconds")
+	// counter: при первом опросе приращения ещё нет
+	assert.NotContains(t, m.Counters, "go_gc_cycles_total_gc_cycles")
+	// гистограммы: при первом опросе событий за интервал ещё нет
+	assert.NotContains(t, m.Counters, "go_gc_pauses_seconds_count")
+	assert.NotContains(t, m.Gauges, "go_gc_pauses_seconds_p50")
+
+	runtime.GC()
+	m = NewMetrics()
+	assert.NoError(t, c.Collect(m))
+	assert.Positive(t, m.Counters["go_gc_cycles_total_gc_cycles"])
+	// гистограммы задержек планировщика и пауз GC
+	assert.NotEmpty(t, m.Gauges["go_sched_latencies_seconds_p99"])
+	assert.NotEmpty(t, m.Gauges["go_gc_pauses_seconds_p50"])
+	assert.Positive(t, m.Counters["go_gc_pauses_seconds_count"])
+	for name, val := range m.Gauges {
+		_, err := strconv.ParseFloat(val, 64)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	buckets := []float64{0, 1, 2, 3, math.Inf(1)}
+	counts := []uint64{2, 5, 2, 1}
+	tests := []struct {
+		name string
+		q    float64
+		want float64
+	}{
+		{name: "min", q: 0, want: 1},
+		{name: "p50", q: 0.5, want: 2},
+		{name: "p90", q: 0.9, want: 3},
+		{name: "max", q: 1, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, quantile(buckets, counts, 10, tt.q))
+		})
+	}
+}
+
+func BenchmarkRuntimeCollector(b *testing.B) {
+	c := &RuntimeCollector{}
+	m := NewMetrics()
+	fmem, err := os.Create("../profiles/base1.pprof")
+	if err != nil {
+		panic(err)
+	}
+	defer fmem.Close()
+	runtime.GC() // получаем статистику по использованию памяти
+	if err := rpprof.WriteHeapProfile(fmem); err != nil {
+		panic(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Collect(m) //nolint
+	}
+}
+
+func BenchmarkGoRuntimeCollector(b *testing.B) {
+	c := newGoRuntimeCollector()
+	m := NewMetrics()
+	fmem, err := os.Create("../profiles/res1.pprof")
+	if err != nil {
+		panic(err)
+	}
+	defer fmem.Close()
+	runtime.GC() // получаем статистику по использованию памяти
+	if err := rpprof.WriteHeapProfile(fmem); err != nil {
+		panic(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		c.Collect(m) //nolint
+	}
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/disk"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+func init() {
+	Register("disk", false, func(cfg config.CollectorConfig) (Collector, error) {
+		f, err := newFilter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &DiskCollector{filter: f, io: make(deltas)}, nil
+	})
+}
+
+// DiskCollector собирает заполненность файловых систем по точкам монтирования
+// и счётчики ввода-вывода по устройствам.
+// Фильтры include/exclude применяются к точке монтирования, устройству и имени устройства ввода-вывода.
+type DiskCollector struct {
+	filter *filter
+	io     deltas
+}
+
+// Name возвращает имя коллектора.
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+// Collect собирает метрики дисков.
+func (c *DiskCollector) Collect(m *Metrics) error {
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		if !c.filter.match(p.Mountpoint, p.Device) {
+			continue
+		}
+		usage, err := disk.Usage(p.Mountpoint)
+		if err != nil {
+			continue
+		}
+		suffix := "_" + label(p.Mountpoint)
+		m.Gauges["DiskTotal"+suffix] = strconv.FormatUint(usage.Total, 10)
+		m.Gauges["DiskFree"+suffix] = strconv.FormatUint(usage.Free, 10)
+		m.Gauges["DiskUsed"+suffix] = strconv.FormatUint(usage.Used, 10)
+		m.Gauges["DiskUsedPercent"+suffix] = strconv.FormatFloat(usage.UsedPercent, 'g', -1, 64)
+		m.Gauges["DiskInodesFree"+suffix] = strconv.FormatUint(usage.InodesFree, 10)
+	}
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return err
+	}
+	for name, io := range counters {
+		if !c.filter.match(name) {
+			continue
+		}
+		suffix := "_" + label(name)
+		c.io.add(m, "DiskReadBytes"+suffix, io.ReadBytes)
+		c.io.add(m, "DiskWriteBytes"+suffix, io.WriteBytes)
+		c.io.add(m, "DiskReadCount"+suffix, io.ReadCount)
+		c.io.add(m, "DiskWriteCount"+suffix, io.WriteCount)
+		m.Gauges["DiskIopsInProgress"+suffix] = strconv.FormatUint(io.IopsInProgress, 10)
+	}
+	return nil
+}
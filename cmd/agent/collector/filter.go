@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+// filter отбирает устройства и интерфейсы по спискам include/exclude.
+type filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newFilter(cfg config.CollectorConfig) (*filter, error) {
+	f := &filter{}
+	for _, expr := range cfg.Include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, expr := range cfg.Exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// match возвращает true, если хотя бы одно из имён подходит под include
+// (или include пуст) и ни одно не подходит под exclude.
+func (f *filter) match(names ...string) bool {
+	for _, re := range f.exclude {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return false
+			}
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// label переводит имя устройства, точки монтирования или интерфейса в суффикс имени метрики.
+// Метрики агента не поддерживают метки, поэтому метка добавляется к имени через "_".
+func label(name string) string {
+	name = strings.Trim(name, "/\\")
+	if name == "" {
+		return "root"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// deltas переводит накопительные значения счётчиков ОС в приращения между опросами.
+type deltas map[string]uint64
+
+// add добавляет в m приращение счётчика name относительно предыдущего опроса.
+// При первом опросе и при сбросе счётчика ОС приращение не добавляется.
+func (d deltas) add(m *Metrics, name string, value uint64) {
+	prev, ok := d[name]
+	d[name] = value
+	if ok && value >= prev {
+		m.Counters[name] += int64(value - prev)
+	}
+}
@@ -0,0 +1,152 @@
+package collector
+
+import (
+	"math"
+	"runtime/metrics"
+	"strconv"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+// goRuntimePrefix добавляется к именам метрик пакета runtime/metrics.
+const goRuntimePrefix = "go_"
+
+// percentiles — перцентили, которые отправляются для гистограмм.
+var percentiles = []struct {
+	suffix string
+	q      float64
+}{
+	{suffix: "_p50", q: 0.5},
+	{suffix: "_p90", q: 0.9},
+	{suffix: "_p99", q: 0.99},
+	{suffix: "_max", q: 1},
+}
+
+func init() {
+	Register("goruntime", true, func(config.CollectorConfig) (Collector, error) {
+		return newGoRuntimeCollector(), nil
+	})
+}
+
+// GoRuntimeCollector собирает все метрики, поддерживаемые пакетом runtime/metrics.
+//
+// Накопительные целочисленные метрики отправляются как counter (приращение между опросами),
+// накопительные дробные — как gauge с суффиксом _total, остальные скалярные — как gauge.
+// Для гистограмм (например, задержек планировщика /sched/latencies:seconds
+// и пауз GC /gc/pauses:seconds) отправляются перцентили
+// p50, p90, p99 и max по событиям за интервал опроса в виде gauge
+// и число событий в виде counter с суффиксом _count.
+type GoRuntimeCollector struct {
+	samples []metrics.Sample
+	descs   map[string]goRuntimeDesc
+	io      deltas
+	buckets map[string][]uint64
+}
+
+// goRuntimeDesc хранит имя метрики агента и признак накопительной метрики.
+type goRuntimeDesc struct {
+	name       string
+	cumulative bool
+}
+
+func newGoRuntimeCollector() *GoRuntimeCollector {
+	descs := metrics.All()
+	c := &GoRuntimeCollector{
+		samples: make([]metrics.Sample, 0, len(descs)),
+		descs:   make(map[string]goRuntimeDesc, len(descs)),
+		io:      make(deltas),
+		buckets: make(map[string][]uint64),
+	}
+	for _, d := range descs {
+		if d.Kind == metrics.KindBad {
+			continue
+		}
+		name := goRuntimePrefix + label(d.Name)
+		if d.Cumulative && d.Kind == metrics.KindFloat64 {
+			// накопленное значение, а не текущее: скорость считается по разности значений
+			name += "_total"
+		}
+		c.samples = append(c.samples, metrics.Sample{Name: d.Name})
+		c.descs[d.Name] = goRuntimeDesc{name: name, cumulative: d.Cumulative}
+	}
+	return c
+}
+
+// Name возвращает имя коллектора.
+func (c *GoRuntimeCollector) Name() string {
+	return "goruntime"
+}
+
+// Collect собирает метрики runtime/metrics.
+func (c *GoRuntimeCollector) Collect(m *Metrics) error {
+	metrics.Read(c.samples)
+	for _, s := range c.samples {
+		desc := c.descs[s.Name]
+		name := desc.name
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			if desc.cumulative {
+				c.io.add(m, name, s.Value.Uint64())
+			} else {
+				m.Gauges[name] = strconv.FormatUint(s.Value.Uint64(), 10)
+			}
+		case metrics.KindFloat64:
+			// накопительные значения в секундах не представимы целым приращением,
+			// поэтому отправляются как gauge с накопленным значением и суффиксом _total
+			m.Gauges[name] = strconv.FormatFloat(s.Value.Float64(), 'g', -1, 64)
+		case metrics.KindFloat64Histogram:
+			c.histogram(m, name, s.Value.Float64Histogram())
+		}
+	}
+	return nil
+}
+
+// histogram добавляет перцентили гистограммы по событиям, произошедшим с предыдущего опроса.
+// При первом опросе запоминаются только счётчики корзин: события до него не учитываются.
+// Если новых событий не было, перцентили не обновляются.
+func (c *GoRuntimeCollector) histogram(m *Metrics, name string, h *metrics.Float64Histogram) {
+	prev := c.buckets[name]
+	if len(prev) != len(h.Counts) {
+		c.buckets[name] = append([]uint64(nil), h.Counts...)
+		return
+	}
+	counts := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, n := range h.Counts {
+		if n >= prev[i] {
+			n -= prev[i]
+		}
+		counts[i] = n
+		total += n
+	}
+	c.buckets[name] = append(prev[:0], h.Counts...)
+	m.Counters[name+"_count"] += int64(total)
+	if total == 0 {
+		return
+	}
+	for _, p := range percentiles {
+		m.Gauges[name+p.suffix] = strconv.FormatFloat(quantile(h.Buckets, counts, total, p.q), 'g', -1, 64)
+	}
+}
+
+// quantile оценивает квантиль q по гистограмме как границу корзины,
+// в которую он попадает. Для открытых корзин используется конечная граница.
+func quantile(buckets []float64, counts []uint64, total uint64, q float64) float64 {
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range counts {
+		seen += n
+		if seen < rank {
+			continue
+		}
+		upper := buckets[i+1]
+		if math.IsInf(upper, 1) {
+			return buckets[i]
+		}
+		return upper
+	}
+	return buckets[len(buckets)-1]
+}
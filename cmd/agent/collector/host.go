@@ -0,0 +1,73 @@
+package collector
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/process"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+// fileNrPath — файл ядра Linux со сведениями об открытых файловых дескрипторах.
+const fileNrPath = "/proc/sys/fs/file-nr"
+
+func init() {
+	Register("host", false, func(config.CollectorConfig) (Collector, error) {
+		return &HostCollector{}, nil
+	})
+}
+
+// HostCollector собирает среднюю загрузку, время работы хоста,
+// число процессов и открытых файловых дескрипторов.
+type HostCollector struct{}
+
+// Name возвращает имя коллектора.
+func (c *HostCollector) Name() string {
+	return "host"
+}
+
+// Collect собирает метрики хоста.
+func (c *HostCollector) Collect(m *Metrics) error {
+	avg, err := load.Avg()
+	if err != nil {
+		return err
+	}
+	m.Gauges["Load1"] = strconv.FormatFloat(avg.Load1, 'g', -1, 64)
+	m.Gauges["Load5"] = strconv.FormatFloat(avg.Load5, 'g', -1, 64)
+	m.Gauges["Load15"] = strconv.FormatFloat(avg.Load15, 'g', -1, 64)
+	uptime, err := host.Uptime()
+	if err != nil {
+		return err
+	}
+	m.Gauges["Uptime"] = strconv.FormatUint(uptime, 10)
+	pids, err := process.Pids()
+	if err != nil {
+		return err
+	}
+	m.Gauges["ProcessCount"] = strconv.Itoa(len(pids))
+	// число открытых дескрипторов доступно только в Linux
+	if fds, ok := openFileDescriptors(); ok {
+		m.Gauges["OpenFileDescriptors"] = strconv.FormatUint(fds, 10)
+	}
+	return nil
+}
+
+func openFileDescriptors() (uint64, bool) {
+	data, err := os.ReadFile(fileNrPath)
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	fds, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return fds, true
+}
@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"github.com/shirou/gopsutil/v3/net"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+func init() {
+	Register("network", false, func(cfg config.CollectorConfig) (Collector, error) {
+		f, err := newFilter(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &NetworkCollector{filter: f, io: make(deltas)}, nil
+	})
+}
+
+// NetworkCollector собирает счётчики байтов, пакетов и ошибок по сетевым интерфейсам.
+// Фильтры include/exclude применяются к имени интерфейса.
+type NetworkCollector struct {
+	filter *filter
+	io     deltas
+}
+
+// Name возвращает имя коллектора.
+func (c *NetworkCollector) Name() string {
+	return "network"
+}
+
+// Collect собирает метрики сетевых интерфейсов.
+func (c *NetworkCollector) Collect(m *Metrics) error {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return err
+	}
+	for _, io := range counters {
+		if !c.filter.match(io.Name) {
+			continue
+		}
+		suffix := "_" + label(io.Name)
+		c.io.add(m, "NetBytesSent"+suffix, io.BytesSent)
+		c.io.add(m, "NetBytesRecv"+suffix, io.BytesRecv)
+		c.io.add(m, "NetPacketsSent"+suffix, io.PacketsSent)
+		c.io.add(m, "NetPacketsRecv"+suffix, io.PacketsRecv)
+		c.io.add(m, "NetErrIn"+suffix, io.Errin)
+		c.io.add(m, "NetErrOut"+suffix, io.Errout)
+		c.io.add(m, "NetDropIn"+suffix, io.Dropin)
+		c.io.add(m, "NetDropOut"+suffix, io.Dropout)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package collector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+// cgroupRoot — точка монтирования cgroup в Linux.
+const cgroupRoot = "/sys/fs/cgroup"
+
+func init() {
+	Register("process", false, func(cfg config.CollectorConfig) (Collector, error) {
+		return newProcessCollector(cfg.Processes)
+	})
+}
+
+// ProcessCollector собирает метрики наблюдаемых процессов.
+// Процессы ищутся заново при каждом опросе, поэтому перезапущенные процессы
+// находятся автоматически. Метрики группы, для которой не найдено ни одного процесса,
+// перестают отправляться.
+type ProcessCollector struct {
+	watches []*processWatch
+	cache   map[int32]*process.Process
+}
+
+// processWatch хранит состояние одной группы наблюдаемых процессов.
+type processWatch struct {
+	cfg     config.ProcessConfig
+	pattern *regexp.Regexp
+	suffix  string
+	io      deltas
+	active  bool
+}
+
+// processStats хранит суммарные значения по группе процессов.
+type processStats struct {
+	count      int
+	cpuPercent float64
+	rss        uint64
+	threads    int64
+	fds        int64
+	io         process.IOCountersStat
+}
+
+func newProcessCollector(cfgs []config.ProcessConfig) (*ProcessCollector, error) {
+	c := &ProcessCollector{cache: make(map[int32]*process.Process)}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, errors.New("process name is empty")
+		}
+		if cfg.PidFile == "" && cfg.Pattern == "" && cfg.Cgroup == "" {
+			return nil, errors.New("process " + cfg.Name + ": pid_file, pattern or cgroup required")
+		}
+		w := &processWatch{cfg: cfg, suffix: "_" + label(cfg.Name), io: make(deltas)}
+		if cfg.Pattern != "" {
+			re, err := regexp.Compile(cfg.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			w.pattern = re
+		}
+		c.watches = append(c.watches, w)
+	}
+	return c, nil
+}
+
+// Name возвращает имя коллектора.
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+// Collect собирает метрики наблюдаемых процессов.
+func (c *ProcessCollector) Collect(m *Metrics) error {
+	var all []*process.Process
+	seen := make(map[int32]bool)
+	for _, w := range c.watches {
+		pids, err := w.find(&all)
+		if err != nil {
+			return err
+		}
+		var stats processStats
+		for _, pid := range pids {
+			p, ok := c.process(pid)
+			if !ok {
+				continue
+			}
+			seen[pid] = true
+			stats.add(p)
+		}
+		if stats.count == 0 {
+			if w.active {
+				m.Removed = append(m.Removed, w.names()...)
+				w.io = make(deltas)
+				w.active = false
+			}
+			continue
+		}
+		w.active = true
+		w.report(m, stats)
+	}
+	// забываем процессы, которые больше не наблюдаются
+	for pid := range c.cache {
+		if !seen[pid] {
+			delete(c.cache, pid)
+		}
+	}
+	return nil
+}
+
+// process возвращает кешированный процесс pid, чтобы загрузка CPU
+// считалась между опросами. Процесс с переиспользованным PID заменяется.
+func (c *ProcessCollector) process(pid int32) (*process.Process, bool) {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, false
+	}
+	if cached, ok := c.cache[pid]; ok {
+		created, _ := p.CreateTime()
+		cachedCreated, _ := cached.CreateTime()
+		if created == cachedCreated {
+			return cached, true
+		}
+	}
+	c.cache[pid] = p
+	return p, true
+}
+
+// find возвращает PID процессов группы. Список всех процессов
+// читается не более одного раза за опрос и переиспользуется через all.
+func (w *processWatch) find(all *[]*process.Process) ([]int32, error) {
+	switch {
+	case w.cfg.PidFile != "":
+		pid, err := readPidFile(w.cfg.PidFile)
+		if err != nil {
+			return nil, nil
+		}
+		return []int32{pid}, nil
+	case w.cfg.Cgroup != "":
+		return readCgroupProcs(w.cfg.Cgroup), nil
+	}
+	if *all == nil {
+		procs, err := process.Processes()
+		if err != nil {
+			return nil, err
+		}
+		*all = procs
+	}
+	var pids []int32
+	for _, p := range *all {
+		name, _ := p.Name()
+		cmdline, _ := p.Cmdline()
+		if w.pattern.MatchString(name) || (cmdline != "" && w.pattern.MatchString(cmdline)) {
+			pids = append(pids, p.Pid)
+		}
+	}
+	return pids, nil
+}
+
+func (w *processWatch) report(m *Metrics, s processStats) {
+	m.Gauges["ProcCount"+w.suffix] = strconv.Itoa(s.count)
+	m.Gauges["ProcCPUPercent"+w.suffix] = strconv.FormatFloat(s.cpuPercent, 'g', -1, 64)
+	m.Gauges["ProcRSS"+w.suffix] = strconv.FormatUint(s.rss, 10)
+	m.Gauges["ProcThreads"+w.suffix] = strconv.FormatInt(s.threads, 10)
+	m.Gauges["ProcFDs"+w.suffix] = strconv.FormatInt(s.fds, 10)
+	w.io.add(m, "ProcReadBytes"+w.suffix, s.io.ReadBytes)
+	w.io.add(m, "ProcWriteBytes"+w.suffix, s.io.WriteBytes)
+	w.io.add(m, "ProcReadCount"+w.suffix, s.io.ReadCount)
+	w.io.add(m, "ProcWriteCount"+w.suffix, s.io.WriteCount)
+}
+
+// names возвращает имена всех метрик группы.
+func (w *processWatch) names() []string {
+	names := []string{"ProcCount", "ProcCPUPercent", "ProcRSS", "ProcThreads", "ProcFDs",
+		"ProcReadBytes", "ProcWriteBytes", "ProcReadCount", "ProcWriteCount"}
+	for i := range names {
+		names[i] += w.suffix
+	}
+	return names
+}
+
+// add прибавляет к статистике группы значения процесса p.
+// Недоступные значения (например, из-за прав доступа) пропускаются.
+func (s *processStats) add(p *process.Process) {
+	s.count++
+	if cpu, err := p.Percent(0); err == nil {
+		s.cpuPercent += cpu
+	}
+	if mem, err := p.MemoryInfo(); err == nil {
+		s.rss += mem.RSS
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		s.threads += int64(threads)
+	}
+	if fds, err := p.NumFDs(); err == nil {
+		s.fds += int64(fds)
+	}
+	if io, err := p.IOCounters(); err == nil {
+		s.io.ReadBytes += io.ReadBytes
+		s.io.WriteBytes += io.WriteBytes
+		s.io.ReadCount += io.ReadCount
+		s.io.WriteCount += io.WriteCount
+	}
+}
+
+func readPidFile(path string) (int32, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(pid), nil
+}
+
+// readCgroupProcs читает PID процессов cgroup из файла cgroup.procs.
+func readCgroupProcs(cgroup string) []int32 {
+	path := cgroup
+	if !strings.HasPrefix(path, cgroupRoot) {
+		path = filepath.Join(cgroupRoot, cgroup)
+	}
+	data, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int32
+	for _, field := range strings.Fields(string(data)) {
+		pid, err := strconv.ParseInt(field, 10, 32)
+		if err == nil {
+			pids = append(pids, int32(pid))
+		}
+	}
+	return pids
+}
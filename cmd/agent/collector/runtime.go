@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+func init() {
+	Register("runtime", true, func(config.CollectorConfig) (Collector, error) {
+		return &RuntimeCollector{}, nil
+	})
+}
+
+// RuntimeCollector собирает числовые поля runtime.MemStats, а также PollCount и RandomValue.
+// Подробные метрики среды выполнения собирает коллектор goruntime.
+type RuntimeCollector struct{}
+
+// Name возвращает имя коллектора.
+func (c *RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+// Collect собирает метрики runtime.
+func (c *RuntimeCollector) Collect(m *Metrics) error {
+	// counter
+	m.Counters["PollCount"] += 1
+	// gauge
+	m.Gauges["RandomValue"] = strconv.FormatFloat(rand.Float64(), 'g', -1, 64)
+	// memStats (gauge)
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	setGaugeMemStatsMetrics(&memStats, m)
+	return nil
+}
+
+func setGaugeMemStatsMetrics(s *runtime.MemStats, m *Metrics) {
+	for name, val := range map[string]uint64{
+		"Alloc":        s.Alloc,
+		"TotalAlloc":   s.TotalAlloc,
+		"Sys":          s.Sys,
+		"Lookups":      s.Lookups,
+		"Mallocs":      s.Mallocs,
+		"Frees":        s.Frees,
+		"HeapAlloc":    s.HeapAlloc,
+		"HeapSys":      s.HeapSys,
+		"HeapIdle":     s.HeapIdle,
+		"HeapInuse":    s.HeapInuse,
+		"HeapReleased": s.HeapReleased,
+		"HeapObjects":  s.HeapObjects,
+		"StackInuse":   s.StackInuse,
+		"StackSys":     s.StackSys,
+		"MSpanInuse":   s.MSpanInuse,
+		"MSpanSys":     s.MSpanSys,
+		"MCacheInuse":  s.MCacheInuse,
+		"MCacheSys":    s.MCacheSys,
+		"BuckHashSys":  s.BuckHashSys,
+		"GCSys":        s.GCSys,
+		"OtherSys":     s.OtherSys,
+		"NextGC":       s.NextGC,
+		"LastGC":       s.LastGC,
+		"PauseTotalNs": s.PauseTotalNs,
+		"NumGC":        uint64(s.NumGC),
+		"NumForcedGC":  uint64(s.NumForcedGC),
+	} {
+		m.Gauges[name] = strconv.FormatUint(val, 10)
+	}
+	m.Gauges["GCCPUFraction"] = strconv.FormatFloat(s.GCCPUFraction, 'g', -1, 64)
+}
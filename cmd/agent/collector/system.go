@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"musthave-metrics/cmd/agent/config"
+)
+
+func init() {
+	Register("system", true, func(config.CollectorConfig) (Collector, error) {
+		return &SystemCollector{}, nil
+	})
+}
+
+// SystemCollector собирает метрики памяти и загрузки процессоров хоста.
+type SystemCollector struct{}
+
+// Name возвращает имя коллектора.
+func (c *SystemCollector) Name() string {
+	return "system"
+}
+
+// Collect собирает метрики памяти и процессоров.
+func (c *SystemCollector) Collect(m *Metrics) error {
+	memstats, err := mem.VirtualMemory()
+	if err != nil {
+		return err
+	}
+	cpustat, err := cpu.Percent(0, false)
+	if err != nil {
+		return err
+	}
+	m.Gauges["TotalMemory"] = strconv.FormatUint(memstats.Total, 10)
+	m.Gauges["FreeMemory"] = strconv.FormatUint(memstats.Free, 10)
+	for i := 0; i < len(cpustat); i++ {
+		m.Gauges["CPUutilization"+strconv.Itoa(i)] = strconv.FormatFloat(cpustat[i], 'g', -1, 64)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/caarlos0/env/v6"
 )
@@ -18,15 +19,47 @@ type ClientFlags struct {
 	FlagHashKey        string
 	FlagRateLimit      int
 	FlagMemProfile     string
-	FlagCryptoKey      string `json:"crypto_key"`
-	envRunAddr         string `env:"ADDRESS"`
-	envReportInterval  int    `env:"REPORT_INTERVAL"`
-	envPollInterval    int    `env:"POLL_INTERVAL"`
-	envHashKey         string `env:"KEY"`
-	envRateLimit       int    `env:"RATE_LIMIT"`
-	MemProfile         string `env:"MEM_PROFILE"`
-	envCryptoKey       string `env:"CRYPTO_KEY"`
-	Config             string `env:"CONFIG"`
+	FlagCryptoKey      string                     `json:"crypto_key"`
+	FlagStatsDAddr     string                     `json:"statsd_address"`
+	FlagStatsDSocket   string                     `json:"statsd_socket"`
+	FlagAgentID        string                     `json:"agent_id"`
+	FlagAgentGroup     string                     `json:"agent_group"`
+	FlagConfigPoll     int                        `json:"config_poll_interval"`
+	FlagCompress       string                     `json:"compress"`
+	Collectors         map[string]CollectorConfig `json:"collectors"`
+	envRunAddr         string                     `env:"ADDRESS"`
+	envHashKey         string                     `env:"KEY"`
+	MemProfile         string                     `env:"MEM_PROFILE"`
+	envCryptoKey       string                     `env:"CRYPTO_KEY"`
+	envStatsDAddr      string                     `env:"STATSD_ADDRESS"`
+	envStatsDSocket    string                     `env:"STATSD_SOCKET"`
+	envAgentID         string                     `env:"AGENT_ID"`
+	envAgentGroup      string                     `env:"AGENT_GROUP"`
+	envConfigPoll      int                        `env:"CONFIG_POLL_INTERVAL"`
+	envCompress        string                     `env:"COMPRESS"`
+	// Explicit содержит параметры (poll_interval, report_interval, rate_limit),
+	// заданные флагами или переменными окружения. Конфигурация с сервера их не переопределяет.
+	Explicit map[string]bool `json:"-"`
+	Config   string          `env:"CONFIG"`
+}
+
+// CollectorConfig задаёт параметры коллектора метрик агента.
+type CollectorConfig struct {
+	Enabled  *bool    `json:"enabled"`  // коллектор включён (по умолчанию задаётся при регистрации)
+	Interval int      `json:"interval"` // интервал опроса в секундах (по умолчанию poll_interval)
+	Include  []string `json:"include"`  // регулярные выражения для отбора устройств и интерфейсов
+	Exclude  []string `json:"exclude"`  // регулярные выражения для исключения устройств и интерфейсов
+	// Processes задаёт наблюдаемые процессы (только для коллектора process)
+	Processes []ProcessConfig `json:"processes"`
+}
+
+// ProcessConfig описывает наблюдаемый процесс или группу процессов.
+// Процессы отбираются по одному из признаков: PID-файлу, регулярному выражению или cgroup.
+type ProcessConfig struct {
+	Name    string `json:"name"`     // имя, добавляемое к именам метрик
+	PidFile string `json:"pid_file"` // путь к PID-файлу
+	Pattern string `json:"pattern"`  // регулярное выражение для имени или командной строки процесса
+	Cgroup  string `json:"cgroup"`   // путь cgroup относительно /sys/fs/cgroup
 }
 
 // ParseFlags обрабатывает аргументы командной строки
@@ -60,26 +93,61 @@ func ParseFlags() ClientFlags {
 	// регистрируем переменную FlagCryptoKey
 	// как аргумент -crypto-key со значением локального каталога по умолчанию
 	flag.StringVar(&cfg.FlagCryptoKey, "crypto-key", "", "path to public key")
+	// регистрируем переменную FlagStatsDAddr
+	// как аргумент -statsd-addr, пустое значение отключает приём метрик StatsD по UDP
+	flag.StringVar(&cfg.FlagStatsDAddr, "statsd-addr", cfg.FlagStatsDAddr, "StatsD UDP listen address")
+	// регистрируем переменную FlagStatsDSocket
+	// как аргумент -statsd-socket, пустое значение отключает приём метрик StatsD через Unix-сокет
+	flag.StringVar(&cfg.FlagStatsDSocket, "statsd-socket", cfg.FlagStatsDSocket, "StatsD unix socket path")
+	// регистрируем переменную FlagAgentID
+	// как аргумент -id, пустое значение формируется из имени хоста и machine-id
+	flag.StringVar(&cfg.FlagAgentID, "id", cfg.FlagAgentID, "agent ID")
+	// регистрируем переменную FlagAgentGroup
+	// как аргумент -group, группа агента для конфигурации с сервера
+	flag.StringVar(&cfg.FlagAgentGroup, "group", cfg.FlagAgentGroup, "agent group")
+	// регистрируем переменную FlagConfigPoll
+	// как аргумент -config-poll, интервал запроса конфигурации с сервера в секундах (0 отключает запрос)
+	configPoll := cfg.FlagConfigPoll
+	if configPoll == 0 {
+		configPoll = 30
+	}
+	flag.IntVar(&cfg.FlagConfigPoll, "config-poll", configPoll, "remote config poll interval")
+	// регистрируем переменную FlagCompress
+	// как аргумент -compress, кодек сжатия пакетов метрик: gzip, zstd, br или none (по умолчанию gzip)
+	compressCodec := cfg.FlagCompress
+	if compressCodec == "" {
+		compressCodec = "gzip"
+	}
+	flag.StringVar(&cfg.FlagCompress, "compress", compressCodec, "batch compression codec: gzip, zstd, br or none")
 	// парсим переданные серверу аргументы в зарегистрированные переменные
 	flag.Parse()
+	cfg.Explicit = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := remoteSettings[f.Name]; ok {
+			cfg.Explicit[name] = true
+		}
+	})
 	if cfg.envRunAddr != "" {
 		cfg.FlagRunAddr = cfg.envRunAddr
 	} else if envRunAddr := os.Getenv("ADDRESS"); envRunAddr != "" {
 		cfg.FlagRunAddr = envRunAddr
 	}
-	if cfg.envReportInterval != 0 {
-		cfg.FlagReportInterval = cfg.envReportInterval
+	if envReportInterval, err := strconv.Atoi(os.Getenv("REPORT_INTERVAL")); err == nil && envReportInterval > 0 {
+		cfg.FlagReportInterval = envReportInterval
+		cfg.Explicit["report_interval"] = true
 	}
-	if cfg.envPollInterval != 0 {
-		cfg.FlagPollInterval = cfg.envPollInterval
+	if envPollInterval, err := strconv.Atoi(os.Getenv("POLL_INTERVAL")); err == nil && envPollInterval > 0 {
+		cfg.FlagPollInterval = envPollInterval
+		cfg.Explicit["poll_interval"] = true
 	}
 	if cfg.envHashKey != "" {
 		cfg.FlagHashKey = cfg.envHashKey
 	} else if envHashKey := os.Getenv("KEY"); envHashKey != "" {
 		cfg.FlagHashKey = envHashKey
 	}
-	if cfg.envRateLimit != 0 {
-		cfg.FlagRateLimit = cfg.envRateLimit
+	if envRateLimit, err := strconv.Atoi(os.Getenv("RATE_LIMIT")); err == nil && envRateLimit > 0 {
+		cfg.FlagRateLimit = envRateLimit
+		cfg.Explicit["rate_limit"] = true
 	}
 	if MemProfile := os.Getenv("MEM_PROFILE"); MemProfile != "" {
 		cfg.FlagMemProfile = MemProfile
@@ -89,9 +157,46 @@ func ParseFlags() ClientFlags {
 	} else if envCryptoKey := os.Getenv("CRYPTO_KEY"); envCryptoKey != "" {
 		cfg.FlagCryptoKey = envCryptoKey
 	}
+	if cfg.envStatsDAddr != "" {
+		cfg.FlagStatsDAddr = cfg.envStatsDAddr
+	} else if envStatsDAddr := os.Getenv("STATSD_ADDRESS"); envStatsDAddr != "" {
+		cfg.FlagStatsDAddr = envStatsDAddr
+	}
+	if cfg.envStatsDSocket != "" {
+		cfg.FlagStatsDSocket = cfg.envStatsDSocket
+	} else if envStatsDSocket := os.Getenv("STATSD_SOCKET"); envStatsDSocket != "" {
+		cfg.FlagStatsDSocket = envStatsDSocket
+	}
+	if cfg.envAgentID != "" {
+		cfg.FlagAgentID = cfg.envAgentID
+	} else if envAgentID := os.Getenv("AGENT_ID"); envAgentID != "" {
+		cfg.FlagAgentID = envAgentID
+	}
+	if cfg.envAgentGroup != "" {
+		cfg.FlagAgentGroup = cfg.envAgentGroup
+	} else if envAgentGroup := os.Getenv("AGENT_GROUP"); envAgentGroup != "" {
+		cfg.FlagAgentGroup = envAgentGroup
+	}
+	if cfg.envConfigPoll != 0 {
+		cfg.FlagConfigPoll = cfg.envConfigPoll
+	} else if envConfigPoll, err := strconv.Atoi(os.Getenv("CONFIG_POLL_INTERVAL")); err == nil && envConfigPoll > 0 {
+		cfg.FlagConfigPoll = envConfigPoll
+	}
+	if cfg.envCompress != "" {
+		cfg.FlagCompress = cfg.envCompress
+	} else if envCompress := os.Getenv("COMPRESS"); envCompress != "" {
+		cfg.FlagCompress = envCompress
+	}
 	return cfg
 }
 
+// remoteSettings сопоставляет флаги с параметрами, которые может задать сервер.
+var remoteSettings = map[string]string{
+	"p": "poll_interval",
+	"r": "report_interval",
+	"l": "rate_limit",
+}
+
 func readConfig() ClientFlags {
 
 	cfg := ClientFlags{}
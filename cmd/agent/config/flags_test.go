@@ -2,8 +2,12 @@
 package config
 
 import (
+	"flag"
+	"os"
 	"reflect"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_readConfig(t *testing.T) {
@@ -24,3 +28,22 @@ func Test_readConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFlagsEnv(t *testing.T) {
+	args, commandLine := os.Args, flag.CommandLine
+	defer func() {
+		os.Args, flag.CommandLine = args, commandLine
+	}()
+	os.Args = []string{"agent"}
+	flag.CommandLine = flag.NewFlagSet("agent", flag.ContinueOnError)
+	t.Setenv("REPORT_INTERVAL", "30")
+	t.Setenv("POLL_INTERVAL", "5")
+	t.Setenv("RATE_LIMIT", "4")
+
+	cfg := ParseFlags()
+	assert.Equal(t, 30, cfg.FlagReportInterval)
+	assert.Equal(t, 5, cfg.FlagPollInterval)
+	assert.Equal(t, 4, cfg.FlagRateLimit)
+	// параметры из переменных окружения не переопределяются конфигурацией с сервера
+	assert.Equal(t, map[string]bool{"report_interval": true, "poll_interval": true, "rate_limit": true}, cfg.Explicit)
+}
@@ -2,26 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"os"
 	"os/signal"
-	"reflect"
-	"runtime"
 	"strconv"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/avast/retry-go/v4"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"musthave-metrics/cmd/agent/client"
+	"musthave-metrics/cmd/agent/collector"
+	"musthave-metrics/cmd/agent/config"
+	"musthave-metrics/cmd/agent/statsd"
 	"musthave-metrics/handlers"
+	"musthave-metrics/internal/agents"
 	"musthave-metrics/internal/crypt"
 	"musthave-metrics/internal/logger"
 	"musthave-metrics/internal/postgres"
@@ -33,47 +37,242 @@ var (
 	buildVersion, buildDate, buildCommit string = "N/A", "N/A", "N/A"
 )
 
+// коды завершения агента
+const (
+	// exitCodeOK — агент остановлен по сигналу, финальная отправка метрик выполнена
+	exitCodeOK = 0
+	// exitCodeFlushFailed — агент остановлен по сигналу, но финальная отправка метрик завершилась ошибкой
+	exitCodeFlushFailed = 1
+)
+
+// flushTimeout ограничивает время финальной отправки метрик при остановке агента.
+const flushTimeout = 10 * time.Second
+
 type agent struct {
 	CounterMetrics map[string]int64
 	GaugeMetrics   map[string]string
 	client         client.Locallink
 	notifyCtx      context.Context
 	shutdown       context.CancelFunc
+	collectors     []collector.Task
+	statsd         *statsd.Server
+	mu             sync.Mutex
+	// gaugeTimes хранит время сбора значений gauge, unix-время в миллисекундах
+	gaugeTimes map[string]int64
+	// base хранит локальные параметры агента, поверх которых применяется конфигурация с сервера
+	base client.Locallink
+	// reconfigure передаёт новую конфигурацию с сервера в основной цикл агента
+	reconfigure chan reconfigRequest
 }
 
-func (agent *agent) run() {
+// reconfigRequest передаёт конфигурацию с сервера основному циклу агента.
+// В result основной цикл возвращает ошибку применения конфигурации или nil.
+type reconfigRequest struct {
+	cfg    agents.Config
+	result chan error
+}
+
+// run запускает сбор и отправку метрик и работает до получения сигнала остановки.
+// Перед выходом выполняет финальный сбор и отправку метрик и возвращает код завершения.
+func (agent *agent) run() int {
 	agent.printAgentLog("Start")
 	agent.initMetrics()
-	go agent.pollMetrics()
-	go agent.pollUtilMetrics()
-	go agent.reportMetrics()
+	if agent.statsd != nil {
+		if err := agent.statsd.Start(); err != nil {
+			logger.Warnf("StatsD listener error: " + err.Error())
+			agent.statsd = nil
+		}
+	}
+
+	agent.reconfigure = make(chan reconfigRequest)
+	var configWG sync.WaitGroup
+	if agent.client.ConfigPoll > 0 {
+		configWG.Add(1)
+		go func(link client.Locallink) {
+			defer configWG.Done()
+			agent.pollConfig(link)
+		}(agent.client)
+	}
+
+	agent.serve()
+	configWG.Wait()
+	agent.printAgentLog("Stop (on signal)")
+	if agent.statsd != nil {
+		if err := agent.statsd.Close(); err != nil {
+			logger.Warnf("StatsD close error: " + err.Error())
+		}
+	}
 
-	for i := 0; i < 30; i++ {
+	// финальный сбор и отправка метрик
+	agent.collectAll()
+	agent.collectStatsD()
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	if err := agent.flush(ctx); err != nil {
+		logger.Warnf("Final flush error: " + err.Error())
+		agent.printAgentLog("Stop (flush failed)")
+		return exitCodeFlushFailed
+	}
+
+	agent.printAgentLog("Stop")
+	return exitCodeOK
+}
+
+// serve запускает опрос коллекторов и отправку метрик и перезапускает их
+// при получении новой конфигурации с сервера. Возвращает управление после сигнала остановки.
+func (agent *agent) serve() {
+	for {
+		ctx, cancel := context.WithCancel(agent.notifyCtx)
+		wg := agent.startWorkers(ctx)
+		var req *reconfigRequest
 		select {
 		case <-agent.notifyCtx.Done():
-			time.Sleep(time.Duration(agent.client.PollInterval) * time.Second * 3)
-			agent.printAgentLog("Stop (on signal)")
-			agent.shutdown()
+		case r := <-agent.reconfigure:
+			req = &r
+		}
+		cancel()
+		wg.Wait()
+		if req == nil {
 			return
-		default:
-			time.Sleep(1 * time.Second)
 		}
+		req.result <- agent.applyConfig(req.cfg)
 	}
+}
 
-	agent.printAgentLog("Stop")
+// startWorkers запускает опрос коллекторов и отправку метрик до отмены ctx.
+func (agent *agent) startWorkers(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for _, task := range agent.collectors {
+		wg.Add(1)
+		go func(task collector.Task) {
+			defer wg.Done()
+			agent.pollCollector(ctx, task)
+		}(task)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		agent.reportMetrics(ctx)
+	}()
+	return &wg
+}
+
+// pollConfig периодически запрашивает конфигурацию с сервера
+// и передаёт её основному циклу при изменении версии.
+// Версия считается применённой, только если основной цикл применил конфигурацию,
+// иначе она передаётся снова при следующем опросе.
+func (agent *agent) pollConfig(link client.Locallink) {
+	ticker := time.NewTicker(tickerInterval(link.ConfigPoll))
+	defer ticker.Stop()
+	version := link.ConfigVersion
+	for {
+		select {
+		case <-agent.notifyCtx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(agent.notifyCtx, tickerInterval(link.ConfigPoll))
+			cfg, err := handlers.GetAgentConfig(ctx, link)
+			cancel()
+			if err != nil {
+				agent.printErrorLog(err)
+				continue
+			}
+			if cfg.Version == version {
+				continue
+			}
+			req := reconfigRequest{cfg: cfg, result: make(chan error, 1)}
+			select {
+			case agent.reconfigure <- req:
+			case <-agent.notifyCtx.Done():
+				return
+			}
+			select {
+			case err := <-req.result:
+				if err != nil {
+					continue
+				}
+				version = cfg.Version
+				link.ConfigVersion = version
+			case <-agent.notifyCtx.Done():
+				return
+			}
+		}
+	}
+}
+
+// applyConfig применяет конфигурацию с сервера поверх локальных параметров.
+// Параметры, заданные флагами или переменными окружения, не переопределяются.
+// При ошибке агент продолжает работать с прежней конфигурацией.
+func (agent *agent) applyConfig(cfg agents.Config) error {
+	link := agent.base
+	if cfg.PollInterval > 0 && !link.Explicit["poll_interval"] {
+		link.PollInterval = cfg.PollInterval
+	}
+	if cfg.ReportInterval > 0 && !link.Explicit["report_interval"] {
+		link.ReportInterval = cfg.ReportInterval
+	}
+	if cfg.RateLimit > 0 && !link.Explicit["rate_limit"] {
+		link.RateLimit = cfg.RateLimit
+	}
+	link.Collectors = collectorSettings(link.Collectors, cfg.Collectors)
+	collectors, err := collector.Build(link.Collectors, link.PollInterval)
+	if err != nil {
+		agent.printErrorLog(err)
+		return err
+	}
+	link.ConfigVersion = cfg.Version
+	agent.client = link
+	agent.collectors = collectors
+	agent.printAgentLog("Config " + cfg.Version + " applied")
+	return nil
+}
+
+// collectorSettings дополняет локальные параметры коллекторов включением и отключением с сервера.
+// Коллекторы, включённые или отключённые локально, и незарегистрированные коллекторы не меняются.
+func collectorSettings(local map[string]config.CollectorConfig, remote map[string]bool) map[string]config.CollectorConfig {
+	settings := make(map[string]config.CollectorConfig, len(local)+len(remote))
+	for name, c := range local {
+		settings[name] = c
+	}
+	registered := make(map[string]bool)
+	for _, name := range collector.Registered() {
+		registered[name] = true
+	}
+	for name, enabled := range remote {
+		if !registered[name] {
+			logger.Warnf("Remote config: unknown collector " + name)
+			continue
+		}
+		c := settings[name]
+		if c.Enabled != nil {
+			continue
+		}
+		enabled := enabled
+		c.Enabled = &enabled
+		settings[name] = c
+	}
+	return settings
 }
 
 func newAgent() (*agent, error) {
 	agent := &agent{
-		client: client.Locallink{},
+		client: client.Locallink{Version: buildVersion},
+	}
+	if err := agent.client.Run(); err != nil {
+		return agent, err
 	}
-	return agent, agent.client.Run()
+	agent.base = agent.client
+	collectors, err := collector.Build(agent.client.Collectors, agent.client.PollInterval)
+	agent.collectors = collectors
+	if agent.client.StatsDAddr != "" || agent.client.StatsDSocket != "" {
+		agent.statsd = statsd.New(agent.client.StatsDAddr, agent.client.StatsDSocket)
+	}
+	return agent, err
 }
 
 func main() {
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
 
 	logger.BuildInfo(buildVersion, buildDate, buildCommit)
 	agent, err := newAgent()
@@ -83,7 +282,8 @@ func main() {
 
 	agent.notifyCtx = ctx
 	agent.shutdown = cancel
-	agent.run()
+	code := agent.run()
+	cancel()
 
 	/*
 		fmem, err := os.Create("profiles/res.pprof")
@@ -96,83 +296,132 @@ func main() {
 			panic(err)
 		}
 	*/
+
+	logger.Infof("Agent exit code: " + strconv.Itoa(code))
+	os.Exit(code)
 }
 
 func (agent *agent) initMetrics() {
 	agent.CounterMetrics = make(map[string]int64, 1)
 	agent.GaugeMetrics = make(map[string]string)
+	agent.gaugeTimes = make(map[string]int64)
 }
 
-func (agent *agent) pollMetrics() {
-	f := func() {
-		agent.setMetrics()
-		agent.pollMetrics()
-		agent.printMetricsLog("<= Read")
-	}
-	select {
-	case <-agent.notifyCtx.Done():
-		logger.Infof("Получен сигнал отмены, завершаем операции pollMetrics")
-	default:
-		time.AfterFunc(time.Duration(agent.client.PollInterval)*time.Second, f)
+// tickerInterval переводит интервал в секундах в time.Duration.
+// Неположительные значения заменяются минимальным интервалом в 1 секунду.
+func tickerInterval(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = 1
 	}
+	return time.Duration(seconds) * time.Second
 }
 
-func (agent *agent) pollUtilMetrics() {
-	f := func() {
-		agent.setUtilMetrics()
-		agent.pollUtilMetrics()
-		agent.printMetricsLog("<= Util")
-	}
-	select {
-	case <-agent.notifyCtx.Done():
-		logger.Infof("Получен сигнал отмены, завершаем операции pollUtilMetrics")
-	default:
-		time.AfterFunc(time.Duration(agent.client.PollInterval)*time.Second, f)
+// pollCollector опрашивает коллектор с заданным интервалом до получения сигнала остановки.
+func (agent *agent) pollCollector(ctx context.Context, task collector.Task) {
+	ticker := time.NewTicker(tickerInterval(task.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof("Получен сигнал отмены, завершаем опрос коллектора " + task.Collector.Name())
+			return
+		case <-ticker.C:
+			agent.collect(task.Collector)
+			agent.printMetricsLog("<= " + task.Collector.Name())
+		}
 	}
 }
 
-func (agent *agent) reportMetrics() {
-	f := func() {
-		agent.pushMetrics()
-		agent.pushBatchMetricsWithWorkers()
-		agent.pushProtoMetrics()
-		agent.reportMetrics()
-		agent.printMetricsLog("=> Push")
+func (agent *agent) reportMetrics(ctx context.Context) {
+	ticker := time.NewTicker(tickerInterval(agent.client.ReportInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof("Получен сигнал отмены, завершаем операции reportMetrics")
+			return
+		case <-ticker.C:
+			agent.collectStatsD()
+			agent.pushMetrics() //nolint
+			agent.pushBatchMetricsWithWorkers()
+			agent.pushProtoMetrics(ctx) //nolint
+			agent.printMetricsLog("=> Push")
+		}
 	}
-	select {
-	case <-agent.notifyCtx.Done():
-		logger.Infof("Получен сигнал отмены, завершаем операции reportMetrics")
-	default:
-		time.AfterFunc(time.Duration(agent.client.ReportInterval)*time.Second, f)
+}
+
+// flush отправляет текущие значения метрик всеми способами
+// и возвращает объединённую ошибку отправки.
+func (agent *agent) flush(ctx context.Context) error {
+	errs := []error{
+		agent.pushMetrics(),
+		agent.pushBatchMetrics(),
+		agent.pushProtoMetrics(ctx),
 	}
+	agent.printMetricsLog("=> Flush")
+	return errors.Join(errs...)
 }
 
-func (agent *agent) pushMetrics() {
-	var err error
+// snapshot возвращает копии текущих значений метрик и времени сбора значений gauge.
+func (agent *agent) snapshot() (map[string]int64, map[string]string, map[string]int64) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	counters := make(map[string]int64, len(agent.CounterMetrics))
 	for name, val := range agent.CounterMetrics {
-		err = handlers.UpdateMetrics(agent.client, "counter", name, strconv.FormatInt(val, 10))
+		counters[name] = val
 	}
+	gauges := make(map[string]string, len(agent.GaugeMetrics))
+	times := make(map[string]int64, len(agent.gaugeTimes))
 	for name, val := range agent.GaugeMetrics {
-		err = handlers.UpdateMetrics(agent.client, "gauge", name, val)
+		gauges[name] = val
+		if ts, ok := agent.gaugeTimes[name]; ok {
+			times[name] = ts
+		}
+	}
+	return counters, gauges, times
+}
+
+// timestamp возвращает время сбора значения gauge или nil, если оно неизвестно.
+func timestamp(times map[string]int64, name string) *int64 {
+	ts, ok := times[name]
+	if !ok {
+		return nil
 	}
+	return &ts
+}
+
+func (agent *agent) pushMetrics() error {
+	var errs []error
+	counters, gauges, _ := agent.snapshot()
+	for name, val := range counters {
+		errs = append(errs, handlers.UpdateMetrics(agent.client, "counter", name, strconv.FormatInt(val, 10)))
+	}
+	for name, val := range gauges {
+		errs = append(errs, handlers.UpdateMetrics(agent.client, "gauge", name, val))
+	}
+	err := errors.Join(errs...)
 	if err != nil {
 		agent.printErrorLog(err)
 	}
+	return err
 }
 
-func (agent *agent) pushProtoMetrics() {
+func (agent *agent) pushProtoMetrics(ctx context.Context) error {
 
 	conn, err := grpc.Dial(":3200", grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		log.Fatal(err)
+		agent.printErrorLog(err)
+		return err
 	}
 	defer conn.Close()
 
 	c := proto.NewMetricServerClient(conn)
 	req := proto.PushProtoMetricsRequest{
-		Metrics: make([]*proto.Metric, 0),
+		Metrics:        make([]*proto.Metric, 0),
+		IdempotencyKey: service.NewIdempotencyKey(),
 	}
-	for name, val := range agent.CounterMetrics {
+	counters, gauges, times := agent.snapshot()
+	for name, val := range counters {
 		req.Metrics = append(req.Metrics,
 			&proto.Metric{
 				ID:    name,
@@ -181,7 +430,7 @@ func (agent *agent) pushProtoMetrics() {
 			},
 		)
 	}
-	for name, val := range agent.GaugeMetrics {
+	for name, val := range gauges {
 		gaugeValue, errprs := strconv.ParseFloat(val, 64)
 		if errprs != nil {
 			agent.printErrorLog(errprs)
@@ -189,9 +438,10 @@ func (agent *agent) pushProtoMetrics() {
 		}
 		req.Metrics = append(req.Metrics,
 			&proto.Metric{
-				ID:    name,
-				MType: "gauge",
-				Value: &gaugeValue,
+				ID:        name,
+				MType:     "gauge",
+				Value:     &gaugeValue,
+				Timestamp: timestamp(times, name),
 			},
 		)
 	}
@@ -206,27 +456,57 @@ func (agent *agent) pushProtoMetrics() {
 		}
 	}
 
+	// зашифрованный токен в формате PEM содержит переводы строк,
+	// поэтому передаётся в бинарных метаданных
 	md := metadata.New(
 		map[string]string{
-			"X-Real-IP":      locallinkIP.String(),
-			"X-Crypto-Key":   agent.client.PublicKeyPath,
-			"X-Secret-Token": encrypteddata,
+			"X-Real-IP":                      locallinkIP.String(),
+			"X-Crypto-Key":                   agent.client.PublicKeyPath,
+			"X-Secret-Token-Bin":             encrypteddata,
+			service.AgentIDHeader:            agent.client.AgentID,
+			service.AgentVersionHeader:       agent.client.Version,
+			service.AgentIntervalHeader:      strconv.Itoa(agent.client.ReportInterval),
+			service.AgentGroupHeader:         agent.client.Group,
+			service.AgentConfigVersionHeader: agent.client.ConfigVersion,
 		})
 
-	ctx := metadata.NewOutgoingContext(agent.notifyCtx, md)
+	ctx = metadata.NewOutgoingContext(ctx, md)
 
 	compressor := grpc.UseCompressor(gzip.Name)
 
-	response, err := c.PushProtoMetrics(ctx, &req, compressor)
-	if response == nil || err != nil {
+	// ключ пакета создан вместе с запросом, поэтому повторные попытки отправляют его с тем же ключом
+	var response *proto.PushProtoMetricsResponse
+	err = retry.Do(func() error {
+		response, err = c.PushProtoMetrics(ctx, &req, compressor)
+		return err
+	},
+		retry.RetryIf(func(err error) bool {
+			// отказы в доступе не повторяются, повторяются недоступность сервера
+			// и пакет с тем же ключом, ещё обрабатываемый сервером
+			return status.Code(err) == codes.Unavailable
+		}),
+		retry.Attempts(3),
+		retry.Delay(1000*time.Millisecond),
+		retry.Context(ctx),
+		retry.LastErrorOnly(true),
+	)
+	if err != nil {
 		agent.printErrorLog(err)
+		return err
 	}
+	if response == nil {
+		err = errors.New("empty gRPC response")
+		agent.printErrorLog(err)
+		return err
+	}
+	return nil
 }
 
-func (agent *agent) pushBatchMetrics() {
+func (agent *agent) pushBatchMetrics() error {
 	var metrics []postgres.Metrics
 	var err error
-	for name, val := range agent.CounterMetrics {
+	counters, gauges, times := agent.snapshot()
+	for name, val := range counters {
 		metrics = append(metrics,
 			postgres.Metrics{
 				ID:    name,
@@ -235,7 +515,7 @@ func (agent *agent) pushBatchMetrics() {
 			},
 		)
 	}
-	for name, val := range agent.GaugeMetrics {
+	for name, val := range gauges {
 		gaugeValue, errprs := strconv.ParseFloat(val, 64)
 		if errprs != nil {
 			agent.printErrorLog(errprs)
@@ -243,9 +523,10 @@ func (agent *agent) pushBatchMetrics() {
 		}
 		metrics = append(metrics,
 			postgres.Metrics{
-				ID:    name,
-				MType: "gauge",
-				Value: &gaugeValue,
+				ID:        name,
+				MType:     "gauge",
+				Value:     &gaugeValue,
+				Timestamp: timestamp(times, name),
 			},
 		)
 	}
@@ -253,6 +534,7 @@ func (agent *agent) pushBatchMetrics() {
 	if err != nil {
 		agent.printErrorLog(err)
 	}
+	return err
 }
 
 func (agent *agent) pushBatchMetricsWithWorkers() {
@@ -283,86 +565,49 @@ func (agent *agent) pushBatchMetricsWithWorkers() {
 func pushWorkerBatchMetrics(agent *agent, id int, jobs <-chan int, results chan<- int) {
 	for j := range jobs {
 		fmt.Println("рабочий процесс", id, "запущена задача", j)
-		agent.pushBatchMetrics()
+		agent.pushBatchMetrics() //nolint
 		fmt.Println("рабочий процесс", id, "закончена задача", j)
 		results <- j + 1
 	}
 }
 
-func (agent *agent) setMetrics() {
-	var mu sync.Mutex
-	mu.Lock()
-	// counter
-	agent.CounterMetrics["PollCount"] += 1
-	// gauge
-	agent.GaugeMetrics["RandomValue"] = strconv.FormatFloat(rand.Float64(), 'g', -1, 64)
-	// memStats (gauge)
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-	setGaugeMemStatsMetrics(memStats, agent)
-	//setGaugeMemStatsMetricsNew(memStats, agent)
-	mu.Unlock()
-}
-
-func (agent *agent) setUtilMetrics() {
-	var mu sync.Mutex
-	// Util gauge
-	memstats, err := mem.VirtualMemory()
-	if err != nil {
+// collect опрашивает коллектор и объединяет собранные значения с текущими:
+// приращения счётчиков прибавляются, значения gauge заменяются,
+// удалённые коллектором метрики перестают отправляться.
+func (agent *agent) collect(c collector.Collector) {
+	m := collector.NewMetrics()
+	if err := c.Collect(m); err != nil {
 		agent.printErrorLog(err)
 		return
 	}
-	cpustat, err := cpu.Percent(0, false)
-	if err != nil {
-		agent.printErrorLog(err)
-		return
+	now := time.Now().UnixMilli()
+	agent.mu.Lock()
+	for name, val := range m.Counters {
+		agent.CounterMetrics[name] += val
+	}
+	for name, val := range m.Gauges {
+		agent.GaugeMetrics[name] = val
+		agent.gaugeTimes[name] = now
 	}
-	mu.Lock()
-	agent.GaugeMetrics["TotalMemory"] = strconv.FormatUint(memstats.Total, 10)
-	agent.GaugeMetrics["FreeMemory"] = strconv.FormatUint(memstats.Free, 10)
-	for i := 0; i < len(cpustat); i++ {
-		agent.GaugeMetrics["CPUutilization"+strconv.Itoa(i)] = strconv.FormatFloat(cpustat[i], 'g', -1, 64)
+	for _, name := range m.Removed {
+		delete(agent.CounterMetrics, name)
+		delete(agent.GaugeMetrics, name)
+		delete(agent.gaugeTimes, name)
 	}
-	mu.Unlock()
+	agent.mu.Unlock()
 }
 
-func setGaugeMemStatsMetrics(s interface{}, agent *agent) {
-	valOf := reflect.ValueOf(s)
-	typOf := reflect.TypeOf(s)
-	for i := 0; i < valOf.NumField(); i++ {
-		var value string
-		valField := valOf.Field(i)
-		typField := typOf.Field(i)
-		switch valField.Interface().(type) {
-		case float64:
-			value = strconv.FormatFloat(valField.Interface().(float64), 'g', -1, 64)
-		case uint32:
-			value = fmt.Sprint(valField.Interface().(uint32))
-		case uint64:
-			value = strconv.FormatUint(valField.Interface().(uint64), 10)
-		default:
-			value = "0"
-		}
-		agent.GaugeMetrics[typField.Name] = value
+// collectStatsD добавляет метрики, полученные по StatsD за интервал отправки.
+func (agent *agent) collectStatsD() {
+	if agent.statsd != nil {
+		agent.collect(agent.statsd)
 	}
 }
 
-func setGaugeMemStatsMetricsNew(s interface{}, agent *agent) {
-	valOf := reflect.ValueOf(s)
-	for i := 0; i < valOf.NumField(); i++ {
-		var value string
-		valField := valOf.Field(i)
-		switch valField.Interface().(type) {
-		case float64:
-			value = strconv.FormatFloat(valField.Interface().(float64), 'g', -1, 64)
-		case uint32:
-			value = fmt.Sprint(valField.Interface().(uint32))
-		case uint64:
-			value = strconv.FormatUint(valField.Interface().(uint64), 10)
-		default:
-			value = "0"
-		}
-		agent.GaugeMetrics[valOf.Type().Field(i).Name] = value
+// collectAll однократно опрашивает все включённые коллекторы.
+func (agent *agent) collectAll() {
+	for _, task := range agent.collectors {
+		agent.collect(task.Collector)
 	}
 }
 
@@ -374,8 +619,8 @@ func (agent *agent) printAgentLog(operation string) {
 	)
 }
 
-func (agent *agent) printErrorLog(error) {
-	if err := recover(); err != nil {
+func (agent *agent) printErrorLog(err error) {
+	if err != nil {
 		fmt.Printf(
 			"%s xxx Error: %s \n",
 			time.Now().Format(time.DateTime),
@@ -389,6 +634,12 @@ func (agent *agent) printMetricsLog(operation string) {
 		"%s %s metrics (Poll count: %d)\n",
 		time.Now().Format(time.DateTime),
 		operation,
-		agent.CounterMetrics["PollCount"],
+		agent.pollCount(),
 	)
 }
+
+func (agent *agent) pollCount() int64 {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.CounterMetrics["PollCount"]
+}
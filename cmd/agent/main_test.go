@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"musthave-metrics/cmd/agent/client"
-	"os"
-	"runtime"
-	rpprof "runtime/pprof"
+	"musthave-metrics/cmd/agent/collector"
+	"musthave-metrics/cmd/agent/config"
+	"musthave-metrics/internal/agents"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewAgent(t *testing.T) {
@@ -31,13 +37,13 @@ func TestNewAgent(t *testing.T) {
 func TestInitMetrics(t *testing.T) {
 	tests := []struct {
 		name               string
-		agent              agent
+		agent              *agent
 		wantCounterMetrics map[string]int64
 		wantGaugeMetrics   map[string]string
 	}{
 		{
 			name:               "1",
-			agent:              agent{},
+			agent:              &agent{},
 			wantCounterMetrics: make(map[string]int64, 1),
 			wantGaugeMetrics:   make(map[string]string),
 		},
@@ -51,68 +57,78 @@ func TestInitMetrics(t *testing.T) {
 	}
 }
 
-func TestSetMetrics(t *testing.T) {
+func TestCollect(t *testing.T) {
 	tests := []struct {
 		name               string
-		agent              agent
+		agent              *agent
+		collector          collector.Collector
 		wantCounterMetrics map[string]int64
-		wantGaugeMetrics   map[string]string
+		wantGauges         []string
 	}{
 		{
-			name:               "1",
-			agent:              agent{},
-			wantCounterMetrics: make(map[string]int64, 1),
-			wantGaugeMetrics:   make(map[string]string),
+			name:               "runtime",
+			agent:              &agent{},
+			collector:          &collector.RuntimeCollector{},
+			wantCounterMetrics: map[string]int64{"PollCount": 2},
+			wantGauges:         []string{"RandomValue", "HeapAlloc"},
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			tt.agent.initMetrics()
-			tt.agent.setMetrics()
-			tt.wantCounterMetrics["PollCount"] += 1
-			assert.Equal(t, tt.agent.CounterMetrics, tt.wantCounterMetrics)
-			assert.NotEmpty(t, tt.agent.GaugeMetrics["RandomValue"])
-		})
-	}
-}
-
-func TestSetUtilMetrics(t *testing.T) {
-	tests := []struct {
-		name               string
-		agent              agent
-		wantCounterMetrics map[string]int64
-		wantGaugeMetrics   map[string]string
-	}{
 		{
-			name:  "1",
-			agent: agent{},
+			name:               "system",
+			agent:              &agent{},
+			collector:          &collector.SystemCollector{},
+			wantCounterMetrics: map[string]int64{},
+			wantGauges:         []string{"TotalMemory", "FreeMemory", "CPUutilization0"},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.agent.initMetrics()
-			tt.agent.setUtilMetrics()
-			assert.NotEmpty(t, tt.agent.GaugeMetrics["TotalMemory"])
-			assert.NotEmpty(t, tt.agent.GaugeMetrics["FreeMemory"])
-			assert.GreaterOrEqual(t, len(tt.agent.GaugeMetrics), 3)
+			// приращения счётчиков накапливаются между опросами
+			tt.agent.collect(tt.collector)
+			tt.agent.collect(tt.collector)
+			assert.Equal(t, tt.wantCounterMetrics, tt.agent.CounterMetrics)
+			for _, name := range tt.wantGauges {
+				assert.NotEmpty(t, tt.agent.GaugeMetrics[name])
+			}
 		})
 	}
 }
 
+// removingCollector удаляет ранее собранную метрику.
+type removingCollector struct{}
+
+func (c removingCollector) Name() string { return "removing" }
+
+func (c removingCollector) Collect(m *collector.Metrics) error {
+	m.Removed = append(m.Removed, "ProcCount_nginx", "ProcReadBytes_nginx")
+	return nil
+}
+
+func TestCollectRemoved(t *testing.T) {
+	agent := &agent{}
+	agent.initMetrics()
+	agent.GaugeMetrics["ProcCount_nginx"] = "1"
+	agent.CounterMetrics["ProcReadBytes_nginx"] = 10
+	agent.GaugeMetrics["TotalMemory"] = "1"
+	agent.collect(removingCollector{})
+	assert.Equal(t, map[string]string{"TotalMemory": "1"}, agent.GaugeMetrics)
+	assert.Empty(t, agent.CounterMetrics)
+}
+
 func TestPrintAgentLog(t *testing.T) {
 	tests := []struct {
 		name    string
-		agent   agent
+		agent   *agent
 		message string
 	}{
 		{
 			name:    "1",
-			agent:   agent{},
+			agent:   &agent{},
 			message: "Start",
 		},
 		{
 			name:    "2",
-			agent:   agent{},
+			agent:   &agent{},
 			message: "Stop",
 		},
 	}
@@ -123,50 +139,6 @@ func TestPrintAgentLog(t *testing.T) {
 	}
 }
 
-func BenchmarkSetGaugeMemStatsMetrics(b *testing.B) {
-	agent := &agent{
-		client: client.Locallink{},
-	}
-	agent.initMetrics()
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-	fmem, err := os.Create("profiles/base1.pprof")
-	if err != nil {
-		panic(err)
-	}
-	defer fmem.Close()
-	runtime.GC() // получаем статистику по использованию памяти
-	if err := rpprof.WriteHeapProfile(fmem); err != nil {
-		panic(err)
-	}
-	b.ReportAllocs()
-	for i := 0; i < b.N; i++ {
-		setGaugeMemStatsMetrics(memStats, agent)
-	}
-}
-
-func BenchmarkSetGaugeMemStatsMetricsNew(b *testing.B) {
-	agent := &agent{
-		client: client.Locallink{},
-	}
-	agent.initMetrics()
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
-	fmem, err := os.Create("profiles/res1.pprof")
-	if err != nil {
-		panic(err)
-	}
-	defer fmem.Close()
-	runtime.GC() // получаем статистику по использованию памяти
-	if err := rpprof.WriteHeapProfile(fmem); err != nil {
-		panic(err)
-	}
-	b.ReportAllocs()
-	for i := 0; i < b.N; i++ {
-		setGaugeMemStatsMetricsNew(memStats, agent)
-	}
-}
-
 func Test_agent_pushBatchMetrics(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -187,3 +159,152 @@ func Test_agent_pushBatchMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestTickerInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int
+		want    time.Duration
+	}{
+		{name: "1", seconds: 2, want: 2 * time.Second},
+		{name: "2", seconds: 0, want: time.Second},
+		{name: "3", seconds: -5, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tickerInterval(tt.seconds))
+		})
+	}
+}
+
+func TestRunStopsOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	agent := &agent{
+		client: client.Locallink{
+			RunAddr:        "127.0.0.1:1",
+			PollInterval:   1,
+			ReportInterval: 1,
+		},
+		notifyCtx: ctx,
+		shutdown:  cancel,
+	}
+	// сигнал остановки приходит до первого тика
+	cancel()
+	agent.collectors, _ = collector.Build(nil, 1)
+	code := agent.run()
+	assert.Contains(t, []int{exitCodeOK, exitCodeFlushFailed}, code)
+	assert.Equal(t, int64(1), agent.CounterMetrics["PollCount"])
+	assert.NotEmpty(t, agent.GaugeMetrics["TotalMemory"])
+}
+
+func TestApplyConfig(t *testing.T) {
+	disabled := false
+	a := &agent{}
+	a.base = client.Locallink{
+		PollInterval:   2,
+		ReportInterval: 10,
+		RateLimit:      1,
+		Explicit:       map[string]bool{"rate_limit": true},
+		Collectors:     map[string]config.CollectorConfig{"system": {Enabled: &disabled}},
+	}
+	a.client = a.base
+	err := a.applyConfig(agents.Config{
+		Version:        "v1",
+		PollInterval:   5,
+		ReportInterval: 20,
+		RateLimit:      4,
+		Collectors:     map[string]bool{"system": true, "host": true, "unknown": true},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, a.client.PollInterval)
+	assert.Equal(t, 20, a.client.ReportInterval)
+	assert.Equal(t, 1, a.client.RateLimit)
+	assert.Equal(t, "v1", a.client.ConfigVersion)
+	var names []string
+	for _, task := range a.collectors {
+		names = append(names, task.Collector.Name())
+		assert.Equal(t, 5, task.Interval)
+	}
+	assert.Contains(t, names, "host")
+	assert.NotContains(t, names, "system")
+	// локальные параметры не меняются
+	assert.Equal(t, 2, a.base.PollInterval)
+	assert.Len(t, a.base.Collectors, 1)
+
+	// возврат к локальным параметрам при пустой конфигурации
+	require.NoError(t, a.applyConfig(agents.Config{Version: "v2"}))
+	assert.Equal(t, 2, a.client.PollInterval)
+	assert.Equal(t, 10, a.client.ReportInterval)
+}
+
+func TestServeReconfigure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &agent{
+		client:      client.Locallink{RunAddr: "127.0.0.1:1", PollInterval: 60, ReportInterval: 60},
+		notifyCtx:   ctx,
+		reconfigure: make(chan reconfigRequest),
+	}
+	// коллектор process с некорректным выражением не создаётся, если его включит сервер
+	a.client.Collectors = map[string]config.CollectorConfig{"process": {Processes: []config.ProcessConfig{{Name: "nginx", Pattern: "("}}}}
+	a.base = a.client
+	a.initMetrics()
+	done := make(chan struct{})
+	go func() {
+		a.serve()
+		close(done)
+	}()
+	tests := []struct {
+		name        string
+		cfg         agents.Config
+		wantErr     bool
+		wantVersion string
+	}{
+		{name: "1", cfg: agents.Config{Version: "v1", ReportInterval: 30}, wantVersion: "v1"},
+		{name: "2", cfg: agents.Config{Version: "v2", ReportInterval: 40, Collectors: map[string]bool{"process": true}}, wantErr: true, wantVersion: "v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := reconfigRequest{cfg: tt.cfg, result: make(chan error, 1)}
+			a.reconfigure <- req
+			err := <-req.result
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantVersion, a.client.ConfigVersion)
+			assert.Equal(t, 30, a.client.ReportInterval)
+		})
+	}
+	cancel()
+	<-done
+}
+
+func TestPollConfigRetry(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":"v1","report_interval":30}`)) //nolint
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := &agent{notifyCtx: ctx, reconfigure: make(chan reconfigRequest)}
+	go a.pollConfig(client.Locallink{RunAddr: ts.Listener.Addr().String(), ConfigPoll: 1})
+
+	// версия, которую не удалось применить, передаётся снова при следующем опросе
+	req := <-a.reconfigure
+	assert.Equal(t, "v1", req.cfg.Version)
+	req.result <- errors.New("apply failed")
+	req = <-a.reconfigure
+	assert.Equal(t, "v1", req.cfg.Version)
+	req.result <- nil
+
+	// применённая версия больше не передаётся
+	select {
+	case req = <-a.reconfigure:
+		t.Errorf("config %s sent again after it was applied", req.cfg.Version)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
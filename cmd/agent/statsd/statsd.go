@@ -0,0 +1,214 @@
+// Package statsd предназначен для приёма пользовательских метрик агентом по протоколу StatsD.
+package statsd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"musthave-metrics/cmd/agent/collector"
+	"musthave-metrics/internal/logger"
+)
+
+// maxPacketSize — максимальный размер принимаемой датаграммы.
+const maxPacketSize = 65535
+
+// Server принимает метрики StatsD по UDP и Unix-сокету и агрегирует их
+// до очередного сбора агентом.
+//
+// Поддерживаемые типы:
+//   - name:value|c[|@rate] — счётчик, значения суммируются (с учётом частоты выборки);
+//   - name:value|g, name:+value|g, name:-value|g — gauge, абсолютное значение или изменение;
+//   - name:value|ms — таймер, отправляется как counter name_count
+//     и gauge name_min, name_max, name_mean, name_p90.
+type Server struct {
+	Addr   string // адрес UDP, например 127.0.0.1:8125
+	Socket string // путь к Unix-сокету (датаграммы)
+
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+	timers   map[string][]float64
+	conns    []net.PacketConn
+	wg       sync.WaitGroup
+}
+
+// New создаёт сервер StatsD. Пустой addr или socket отключает соответствующий транспорт.
+func New(addr string, socket string) *Server {
+	return &Server{
+		Addr:     addr,
+		Socket:   socket,
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+		timers:   make(map[string][]float64),
+	}
+}
+
+// Name возвращает имя коллектора.
+func (s *Server) Name() string {
+	return "statsd"
+}
+
+// Start открывает сокеты и начинает приём метрик.
+func (s *Server) Start() error {
+	if s.Addr != "" {
+		conn, err := net.ListenPacket("udp", s.Addr)
+		if err != nil {
+			return err
+		}
+		s.serve(conn)
+	}
+	if s.Socket != "" {
+		// удаляем сокет, оставшийся от предыдущего запуска
+		if err := os.Remove(s.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			s.Close() //nolint
+			return err
+		}
+		conn, err := net.ListenPacket("unixgram", s.Socket)
+		if err != nil {
+			s.Close() //nolint
+			return err
+		}
+		s.serve(conn)
+	}
+	return nil
+}
+
+// Close закрывает сокеты и дожидается завершения приёма.
+func (s *Server) Close() error {
+	var errs []error
+	for _, conn := range s.conns {
+		errs = append(errs, conn.Close())
+	}
+	s.wg.Wait()
+	s.conns = nil
+	if s.Socket != "" {
+		if err := os.Remove(s.Socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) serve(conn net.PacketConn) {
+	s.conns = append(s.conns, conn)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					logger.Warnf("StatsD read error: " + err.Error())
+				}
+				return
+			}
+			s.handlePacket(buf[:n])
+		}
+	}()
+}
+
+// handlePacket разбирает датаграмму, которая может содержать несколько строк.
+func (s *Server) handlePacket(packet []byte) {
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := s.handleLine(string(line)); err != nil {
+			logger.Warnf("StatsD parse error: " + err.Error())
+		}
+	}
+}
+
+func (s *Server) handleLine(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("invalid line %q", line)
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid line %q", line)
+	}
+	raw, mtype := parts[0], parts[1]
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid value in line %q", line)
+	}
+	rate := 1.0
+	for _, p := range parts[2:] {
+		if strings.HasPrefix(p, "@") {
+			rate, err = strconv.ParseFloat(p[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return fmt.Errorf("invalid sample rate in line %q", line)
+			}
+		}
+		// прочие расширения (например, теги DogStatsD) игнорируются
+	}
+	name = sanitize(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch mtype {
+	case "c":
+		s.counters[name] += int64(math.Round(value / rate))
+	case "g":
+		if strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-") {
+			s.gauges[name] += value
+		} else {
+			s.gauges[name] = value
+		}
+	case "ms":
+		s.timers[name] = append(s.timers[name], value)
+	default:
+		return fmt.Errorf("unknown metric type %q", mtype)
+	}
+	return nil
+}
+
+// Collect передаёт агенту метрики, накопленные с предыдущего сбора.
+// Счётчики и таймеры обнуляются, значения gauge сохраняются для относительных изменений.
+func (s *Server) Collect(m *collector.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, val := range s.counters {
+		m.Counters[name] += val
+	}
+	for name, val := range s.gauges {
+		m.Gauges[name] = strconv.FormatFloat(val, 'g', -1, 64)
+	}
+	for name, values := range s.timers {
+		sort.Float64s(values)
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		p90 := values[int(math.Ceil(0.9*float64(len(values))))-1]
+		m.Counters[name+"_count"] += int64(len(values))
+		m.Gauges[name+"_min"] = strconv.FormatFloat(values[0], 'g', -1, 64)
+		m.Gauges[name+"_max"] = strconv.FormatFloat(values[len(values)-1], 'g', -1, 64)
+		m.Gauges[name+"_mean"] = strconv.FormatFloat(sum/float64(len(values)), 'g', -1, 64)
+		m.Gauges[name+"_p90"] = strconv.FormatFloat(p90, 'g', -1, 64)
+	}
+	s.counters = make(map[string]int64)
+	s.timers = make(map[string][]float64)
+	return nil
+}
+
+// sanitize заменяет символы, недопустимые в имени метрики, на "_".
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
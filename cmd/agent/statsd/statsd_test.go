@@ -0,0 +1,133 @@
+package statsd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"musthave-metrics/cmd/agent/collector"
+)
+
+func TestHandleLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr bool
+		want    *collector.Metrics
+	}{
+		{
+			name:  "counter",
+			lines: []string{"requests:1|c", "requests:2|c"},
+			want: &collector.Metrics{
+				Counters: map[string]int64{"requests": 3},
+				Gauges:   map[string]string{},
+			},
+		},
+		{
+			name:  "counter with sample rate",
+			lines: []string{"requests:1|c|@0.1"},
+			want: &collector.Metrics{
+				Counters: map[string]int64{"requests": 10},
+				Gauges:   map[string]string{},
+			},
+		},
+		{
+			name:  "gauge",
+			lines: []string{"queue:10|g", "queue:+5|g", "queue:-3|g"},
+			want: &collector.Metrics{
+				Counters: map[string]int64{},
+				Gauges:   map[string]string{"queue": "12"},
+			},
+		},
+		{
+			name:  "timer",
+			lines: []string{"db.query:10|ms", "db.query:30|ms", "db.query:20|ms|#tag:value"},
+			want: &collector.Metrics{
+				Counters: map[string]int64{"db.query_count": 3},
+				Gauges: map[string]string{
+					"db.query_min":  "10",
+					"db.query_max":  "30",
+					"db.query_mean": "20",
+					"db.query_p90":  "30",
+				},
+			},
+		},
+		{
+			name:  "sanitize name",
+			lines: []string{"app/requests total:1|c"},
+			want: &collector.Metrics{
+				Counters: map[string]int64{"app_requests_total": 1},
+				Gauges:   map[string]string{},
+			},
+		},
+		{name: "no value", lines: []string{"requests|c"}, wantErr: true},
+		{name: "no type", lines: []string{"requests:1"}, wantErr: true},
+		{name: "bad value", lines: []string{"requests:abc|c"}, wantErr: true},
+		{name: "bad type", lines: []string{"requests:1|s"}, wantErr: true},
+		{name: "bad rate", lines: []string{"requests:1|c|@0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("", "")
+			var err error
+			for _, line := range tt.lines {
+				if e := s.handleLine(line); e != nil {
+					err = e
+				}
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			m := collector.NewMetrics()
+			assert.NoError(t, s.Collect(m))
+			assert.Equal(t, tt.want, m)
+		})
+	}
+}
+
+func TestCollectResetsCounters(t *testing.T) {
+	s := New("", "")
+	s.handlePacket([]byte("requests:1|c\nqueue:5|g\nlatency:1|ms\n"))
+	m := collector.NewMetrics()
+	assert.NoError(t, s.Collect(m))
+	assert.Equal(t, int64(1), m.Counters["requests"])
+
+	// счётчики и таймеры агрегируются за интервал, gauge сохраняется
+	m = collector.NewMetrics()
+	assert.NoError(t, s.Collect(m))
+	assert.Empty(t, m.Counters)
+	assert.Equal(t, map[string]string{"queue": "5"}, m.Gauges)
+}
+
+func TestServer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "statsd.sock")
+	s := New("127.0.0.1:0", socket)
+	assert.NoError(t, s.Start())
+	defer s.Close()
+
+	udp, err := net.Dial("udp", s.conns[0].LocalAddr().String())
+	assert.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("udp.requests:2|c"))
+	assert.NoError(t, err)
+
+	unix, err := net.Dial("unixgram", socket)
+	assert.NoError(t, err)
+	defer unix.Close()
+	_, err = unix.Write([]byte("unix.requests:3|c"))
+	assert.NoError(t, err)
+
+	m := collector.NewMetrics()
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, s.Collect(m))
+		return m.Counters["udp.requests"] == 2 && m.Counters["unix.requests"] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, s.Close())
+	assert.NoFileExists(t, socket)
+}
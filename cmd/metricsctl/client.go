@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"musthave-metrics/handlers"
+	"musthave-metrics/internal/agents"
+	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/proto"
+)
+
+// secretToken — токен, который сервер ожидает в метаданных gRPC при шифровании.
+const secretToken = "SecretToken"
+
+// listQuery — параметры списка метрик, как у /api/v1/metrics.
+type listQuery struct {
+	MType  string
+	Prefix string
+	Match  string
+	Source string
+	Sort   string
+	Limit  int
+	Cursor string
+}
+
+// client выполняет запросы к работающему серверу.
+type client interface {
+	// Get возвращает значение метрики.
+	Get(ctx context.Context, mtype string, name string, source string) (handlers.MetricsJSON, error)
+	// List возвращает страницу значений метрик и курсор следующей страницы.
+	List(ctx context.Context, q listQuery) ([]handlers.MetricsJSON, string, error)
+	// Delete удаляет метрики по имени или шаблону имени и возвращает число удалённых значений.
+	Delete(ctx context.Context, mtype string, pattern string, source string) (int64, error)
+	// Agents возвращает сведения об агентах.
+	Agents(ctx context.Context) ([]agents.Agent, error)
+	// Ping проверяет соединение, подпись и шифрование и возвращает описание проверок.
+	Ping(ctx context.Context) (string, error)
+	Close() error
+}
+
+// settings — параметры соединения с сервером.
+type settings struct {
+	addr      string // адрес HTTP-сервера
+	grpcAddr  string // адрес gRPC-сервера
+	transport string // http или grpc
+	hashKey   string // ключ подписи HashSHA256
+	cryptoKey string // публичный ключ сервера для шифрования
+	token     string // токен администратора
+	timeout   time.Duration
+}
+
+func newClient(s settings) (client, error) {
+	switch s.transport {
+	case "http":
+		return &httpClient{settings: s, hc: &http.Client{Timeout: s.timeout}}, nil
+	case "grpc":
+		conn, err := grpc.Dial(s.grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		return &grpcClient{settings: s, conn: conn, grpc: proto.NewMetricServerClient(conn)}, nil
+	}
+	return nil, fmt.Errorf("unknown transport %q, use http or grpc", s.transport)
+}
+
+// httpClient выполняет запросы к /api/v1.
+type httpClient struct {
+	settings
+	hc *http.Client
+}
+
+func (c *httpClient) Get(ctx context.Context, mtype string, name string, source string) (handlers.MetricsJSON, error) {
+	var m handlers.MetricsJSON
+	path := "/api/v1/metrics/" + url.PathEscape(mtype) + "/" + url.PathEscape(name)
+	if source != "" {
+		path += "?" + url.Values{"source": {source}}.Encode()
+	}
+	_, err := c.do(ctx, http.MethodGet, path, nil, &m)
+	return m, err
+}
+
+func (c *httpClient) List(ctx context.Context, q listQuery) ([]handlers.MetricsJSON, string, error) {
+	values := url.Values{}
+	for key, value := range map[string]string{"type": q.MType, "prefix": q.Prefix, "match": q.Match, "source": q.Source, "sort": q.Sort, "cursor": q.Cursor} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	var metrics []handlers.MetricsJSON
+	header, err := c.do(ctx, http.MethodGet, "/api/v1/metrics?"+values.Encode(), nil, &metrics)
+	if err != nil {
+		return nil, "", err
+	}
+	return metrics, header.Get(handlers.NextCursorHeader), nil
+}
+
+func (c *httpClient) Delete(ctx context.Context, mtype string, pattern string, source string) (int64, error) {
+	values := url.Values{"pattern": {pattern}}
+	if mtype != "" {
+		values.Set("type", mtype)
+	}
+	if source != "" {
+		values.Set("source", source)
+	}
+	var result handlers.AdminResult
+	_, err := c.do(ctx, http.MethodDelete, "/api/v1/metrics?"+values.Encode(), nil, &result)
+	return result.Affected, err
+}
+
+func (c *httpClient) Agents(ctx context.Context) ([]agents.Agent, error) {
+	var list []agents.Agent
+	_, err := c.do(ctx, http.MethodGet, "/agents", nil, &list)
+	return list, err
+}
+
+// Ping запрашивает одну метрику. С ключом подписи сервер проверяет подпись запроса,
+// с ключом шифрования запрос отправляется с зашифрованным телом.
+func (c *httpClient) Ping(ctx context.Context) (string, error) {
+	var body []byte
+	if c.cryptoKey != "" {
+		body = []byte("ping")
+	}
+	header, err := c.do(ctx, http.MethodGet, "/api/v1/metrics?limit=1", body, nil)
+	if err != nil {
+		return "", err
+	}
+	checks := "http " + c.addr + ": ok"
+	if c.hashKey != "" {
+		checks += ", request signature accepted"
+		if header.Get("HashSHA256") != "" {
+			checks += ", response signature verified"
+		}
+	}
+	if c.cryptoKey != "" {
+		checks += ", encrypted request accepted"
+	}
+	return checks, nil
+}
+
+func (c *httpClient) Close() error {
+	return nil
+}
+
+// do выполняет запрос и разбирает ответ в result. Тело запроса шифруется ключом cryptoKey
+// и подписывается ключом hashKey; подпись ответа проверяется.
+func (c *httpClient) do(ctx context.Context, method string, path string, body []byte, result any) (http.Header, error) {
+	if len(body) > 0 && c.cryptoKey != "" {
+		encrypted, err := crypt.Encrypt(c.cryptoKey, string(body))
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(encrypted)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, "http://"+c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// ответ без сжатия, чтобы подпись сверялась с полученным телом
+	request.Header.Set("Accept-Encoding", "identity")
+	if ip := service.GetIP(c.addr); ip != nil {
+		request.Header.Set("X-Real-IP", ip.String())
+	}
+	if c.hashKey != "" {
+		request.Header.Set("HashSHA256", service.GetHashString(body, c.hashKey))
+	}
+	if c.token != "" {
+		request.Header.Set(service.AuthorizationHeader, "Bearer "+c.token)
+	}
+	response, err := c.hc.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		var apiErr struct {
+			Error handlers.APIError `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("%s: %s", response.Status, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", response.Status, bytes.TrimSpace(data))
+	}
+	if hash := response.Header.Get("HashSHA256"); hash != "" && c.hashKey != "" && hash != service.GetHashString(data, c.hashKey) {
+		return nil, errors.New("response signature mismatch: check the hash key")
+	}
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, err
+		}
+	}
+	return response.Header, nil
+}
+
+// grpcClient выполняет вызовы gRPC-сервера.
+type grpcClient struct {
+	settings
+	conn *grpc.ClientConn
+	grpc proto.MetricServerClient
+}
+
+func (c *grpcClient) Get(ctx context.Context, mtype string, name string, source string) (handlers.MetricsJSON, error) {
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return handlers.MetricsJSON{}, err
+	}
+	resp, err := c.grpc.GetMetric(ctx, &proto.GetMetricRequest{MType: mtype, ID: name, Source: source})
+	if err != nil {
+		return handlers.MetricsJSON{}, err
+	}
+	return handlers.MetricFromProto(resp.Metric), nil
+}
+
+func (c *grpcClient) List(ctx context.Context, q listQuery) ([]handlers.MetricsJSON, string, error) {
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.grpc.ListMetrics(ctx, &proto.ListMetricsRequest{
+		MType:  q.MType,
+		Prefix: q.Prefix,
+		Match:  q.Match,
+		Source: q.Source,
+		Sort:   q.Sort,
+		Limit:  int64(q.Limit),
+		Cursor: q.Cursor,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	metrics := make([]handlers.MetricsJSON, 0, len(resp.Metrics))
+	for _, m := range resp.Metrics {
+		metrics = append(metrics, handlers.MetricFromProto(m))
+	}
+	return metrics, resp.NextCursor, nil
+}
+
+func (c *grpcClient) Delete(ctx context.Context, mtype string, pattern string, source string) (int64, error) {
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.grpc.DeleteMetrics(ctx, &proto.DeleteMetricsRequest{MType: mtype, Pattern: pattern, Source: source})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Affected, nil
+}
+
+func (c *grpcClient) Agents(ctx context.Context) ([]agents.Agent, error) {
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.grpc.ListAgents(ctx, &proto.ListAgentsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]agents.Agent, 0, len(resp.Agents))
+	for _, a := range resp.Agents {
+		list = append(list, agents.Agent{
+			ID:             a.ID,
+			Address:        a.Address,
+			Version:        a.Version,
+			Transport:      a.Transport,
+			ReportInterval: a.ReportInterval,
+			FirstSeen:      time.UnixMilli(a.FirstSeen),
+			LastReport:     time.UnixMilli(a.LastReport),
+			Reports:        a.Reports,
+			Errors:         a.Errors,
+			Stale:          a.Stale,
+			Group:          a.Group,
+			ConfigVersion:  a.ConfigVersion,
+		})
+	}
+	return list, nil
+}
+
+// Ping запрашивает список агентов. С ключом шифрования передаётся зашифрованный секретный токен.
+// Подпись HashSHA256 в gRPC не используется.
+func (c *grpcClient) Ping(ctx context.Context) (string, error) {
+	if _, err := c.Agents(ctx); err != nil {
+		return "", err
+	}
+	checks := "grpc " + c.grpcAddr + ": ok"
+	if c.cryptoKey != "" {
+		checks += ", encrypted secret token accepted"
+	}
+	return checks, nil
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// outgoing добавляет в контекст метаданные вызова: адрес клиента, секретный токен и токен администратора.
+func (c *grpcClient) outgoing(ctx context.Context) (context.Context, error) {
+	md := metadata.MD{}
+	if ip := service.GetIP(c.addr); ip != nil {
+		md.Set("X-Real-IP", ip.String())
+	}
+	if c.cryptoKey != "" {
+		token, err := crypt.Encrypt(c.cryptoKey, secretToken)
+		if err != nil {
+			return ctx, err
+		}
+		// зашифрованный токен в формате PEM содержит переводы строк,
+		// поэтому передаётся в бинарных метаданных
+		md.Set("X-Crypto-Key", c.cryptoKey)
+		md.Set("X-Secret-Token-Bin", token)
+	}
+	if c.token != "" {
+		md.Set(service.AuthorizationHeader, "Bearer "+c.token)
+	}
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
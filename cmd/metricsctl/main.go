@@ -0,0 +1,377 @@
+// metricsctl — утилита администрирования сервера метрик.
+//
+// Команды для работающего сервера выполняются по HTTP или gRPC:
+//
+//	metricsctl [флаги] get <gauge|counter> <имя>
+//	metricsctl [флаги] list
+//	metricsctl [флаги] watch
+//	metricsctl [флаги] delete <шаблон имени>
+//	metricsctl [флаги] export
+//	metricsctl [флаги] agents
+//	metricsctl [флаги] ping
+//
+// Команды snapshot работают с файлом сохранения метрик без сервера:
+//
+//	metricsctl snapshot inspect|validate|compact <файл>
+//	metricsctl snapshot convert -to-db <DSN> <файл>
+//	metricsctl snapshot convert -from-db <DSN> <файл>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"musthave-metrics/handlers"
+	"musthave-metrics/internal/storage"
+)
+
+// коды завершения
+const (
+	exitCodeOK = 0
+	// exitCodeError — команда завершилась ошибкой
+	exitCodeError = 1
+	// exitCodeUsage — неизвестная команда или неверные флаги
+	exitCodeUsage = 2
+)
+
+const usage = `Usage: metricsctl [flags] <command> [arguments]
+
+Server commands (over HTTP or gRPC):
+  get <gauge|counter> <name>  print a metric value
+  list                        list metrics
+  watch                       print metric changes until interrupted
+  delete <pattern>            delete metrics by name or pattern with * and ? (admin token required)
+  export                      export all metrics
+  agents                      list agents
+  ping                        check connectivity, signature and encryption
+
+Snapshot commands (offline):
+  snapshot inspect <file>     summarize a snapshot file
+  snapshot validate <file>    check every metric of a snapshot file
+  snapshot compact <file>     merge repeated metrics of a snapshot file
+  snapshot convert            copy metrics between a snapshot file and PostgreSQL
+
+Run "metricsctl <command> -h" for command flags.
+
+Flags:
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run выполняет команду args и возвращает код завершения.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("metricsctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	var s settings
+	// адрес HTTP-сервера, как у агента
+	fs.StringVar(&s.addr, "a", envOr("ADDRESS", "localhost:8080"), "HTTP server address")
+	// адрес gRPC-сервера
+	fs.StringVar(&s.grpcAddr, "grpc-addr", envOr("GRPC_ADDRESS", "localhost:3200"), "gRPC server address")
+	// способ обращения к серверу
+	fs.StringVar(&s.transport, "transport", envOr("TRANSPORT", "http"), "transport: http or grpc")
+	// ключ подписи запросов и ответов HashSHA256
+	fs.StringVar(&s.hashKey, "k", os.Getenv("KEY"), "hash key for the HashSHA256 signature")
+	// публичный ключ сервера для шифрования
+	fs.StringVar(&s.cryptoKey, "crypto-key", os.Getenv("CRYPTO_KEY"), "path to the server public key")
+	// токен администратора для удаления метрик
+	fs.StringVar(&s.token, "token", os.Getenv("ADMIN_TOKEN"), "admin token")
+	fs.DurationVar(&s.timeout, "timeout", 10*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitCodeOK
+		}
+		return exitCodeUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitCodeUsage
+	}
+	command, args := fs.Arg(0), fs.Args()[1:]
+	if command == "snapshot" {
+		return runSnapshot(ctx, args, stdout, stderr)
+	}
+	commands := map[string]func(context.Context, client, []string, io.Writer) error{
+		"get":    get,
+		"list":   list,
+		"watch":  watch,
+		"delete": deleteMetrics,
+		"export": export,
+		"agents": listAgents,
+		"ping":   ping,
+	}
+	cmd, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(stderr, "metricsctl: unknown command %q\n", command)
+		fs.Usage()
+		return exitCodeUsage
+	}
+	c, err := newClient(s)
+	if err != nil {
+		fmt.Fprintln(stderr, "metricsctl:", err)
+		return exitCodeUsage
+	}
+	defer c.Close()
+	if err := cmd(ctx, c, args, stdout); err != nil {
+		return report(stderr, command, err)
+	}
+	return exitCodeOK
+}
+
+// report выводит ошибку команды и возвращает код завершения.
+func report(stderr io.Writer, command string, err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitCodeOK
+	}
+	fmt.Fprintln(stderr, "metricsctl "+command+":", err)
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		return exitCodeUsage
+	}
+	return exitCodeError
+}
+
+// usageError — ошибка в аргументах команды.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func envOr(key string, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return value
+}
+
+// newFlags создаёт флаги команды. Ошибки разбора выводятся в stdout вместе со справкой.
+func newFlags(name string, stdout io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	return fs
+}
+
+// parse разбирает флаги команды и проверяет число позиционных аргументов.
+func parse(fs *flag.FlagSet, args []string, nargs int, names string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError(err.Error())
+	}
+	if fs.NArg() != nargs {
+		return usageError("usage: metricsctl " + fs.Name() + " [flags] " + names)
+	}
+	return nil
+}
+
+// listFlags добавляет флаги списка метрик.
+func listFlags(fs *flag.FlagSet) *listQuery {
+	var q listQuery
+	fs.StringVar(&q.MType, "type", "", "gauge or counter")
+	fs.StringVar(&q.Prefix, "prefix", "", "metric name prefix")
+	fs.StringVar(&q.Match, "match", "", "name pattern with * and ?, or a regular expression in /.../")
+	fs.StringVar(&q.Source, "source", "", "agent ID")
+	return &q
+}
+
+// listAll возвращает все страницы списка метрик.
+func listAll(ctx context.Context, c client, q listQuery) ([]handlers.MetricsJSON, error) {
+	q.Limit = handlers.MaxPageSize
+	var all []handlers.MetricsJSON
+	for {
+		page, next, err := c.List(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if next == "" {
+			return all, nil
+		}
+		q.Cursor = next
+	}
+}
+
+func get(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("get", stdout)
+	source := fs.String("source", "", "agent ID, empty sums counters over all agents")
+	if err := parse(fs, args, 2, "<gauge|counter> <name>"); err != nil {
+		return err
+	}
+	m, err := c.Get(ctx, fs.Arg(0), fs.Arg(1), *source)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, metricValue(m))
+	return nil
+}
+
+func list(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("list", stdout)
+	q := listFlags(fs)
+	fs.StringVar(&q.Sort, "sort", "name", "name, value or updated, with - for descending order")
+	if err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	metrics, err := listAll(ctx, c, *q)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tSOURCE\tVALUE")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.MType, m.ID, m.Source, metricValue(m))
+	}
+	return w.Flush()
+}
+
+// watch периодически запрашивает список метрик и выводит изменившиеся значения.
+func watch(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("watch", stdout)
+	q := listFlags(fs)
+	interval := fs.Duration("interval", handlers.DashboardRefresh, "polling interval")
+	if err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	seen := make(map[string]string)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		metrics, err := listAll(ctx, c, *q)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Format(time.TimeOnly)
+		for _, m := range metrics {
+			key := m.MType + "/" + m.ID + "/" + m.Source
+			value := metricValue(m)
+			if old, ok := seen[key]; !ok || old != value {
+				fmt.Fprintf(stdout, "%s %s %s %s %s\n", now, m.MType, m.ID, sourceName(m.Source), value)
+				seen[key] = value
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func deleteMetrics(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("delete", stdout)
+	mtype := fs.String("type", "", "gauge or counter, empty deletes both")
+	source := fs.String("source", "", "agent ID, empty deletes metrics of all agents")
+	if err := parse(fs, args, 1, "<pattern>"); err != nil {
+		return err
+	}
+	affected, err := c.Delete(ctx, *mtype, fs.Arg(0), *source)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "deleted %d\n", affected)
+	return nil
+}
+
+func export(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("export", stdout)
+	q := listFlags(fs)
+	format := fs.String("format", handlers.FormatJSON, "json, ndjson, csv or prom")
+	output := fs.String("o", "", "output file, standard output by default")
+	if err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	metrics, err := listAll(ctx, c, *q)
+	if err != nil {
+		return err
+	}
+	records := make([]storage.Record, 0, len(metrics))
+	for _, m := range metrics {
+		records = append(records, record(m))
+	}
+	if *output == "" {
+		return handlers.WriteExport(stdout, *format, records)
+	}
+	return writeFile(*output, func(w io.Writer) error {
+		return handlers.WriteExport(w, *format, records)
+	})
+}
+
+func listAgents(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("agents", stdout)
+	if err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	list, err := c.Agents(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tTRANSPORT\tVERSION\tLAST REPORT\tREPORTS\tERRORS\tSTALE")
+	for _, a := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%t\n",
+			a.ID, a.Address, a.Transport, a.Version, a.LastReport.Format(time.DateTime), a.Reports, a.Errors, a.Stale)
+	}
+	return w.Flush()
+}
+
+func ping(ctx context.Context, c client, args []string, stdout io.Writer) error {
+	fs := newFlags("ping", stdout)
+	if err := parse(fs, args, 0, ""); err != nil {
+		return err
+	}
+	checks, err := c.Ping(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, checks)
+	return nil
+}
+
+// metricValue возвращает значение метрики в виде строки.
+func metricValue(m handlers.MetricsJSON) string {
+	switch {
+	case m.Delta != nil:
+		return strconv.FormatInt(*m.Delta, 10)
+	case m.Value != nil:
+		return strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	}
+	return ""
+}
+
+func sourceName(source string) string {
+	if source == "" {
+		return "-"
+	}
+	return source
+}
+
+// record переводит метрику из JSON в значение хранилища.
+func record(m handlers.MetricsJSON) storage.Record {
+	r := storage.Record{MType: m.MType, Name: m.ID, Source: m.Source, Timestamp: storage.UnixMilli(m.Timestamp)}
+	if m.Delta != nil {
+		r.Delta = *m.Delta
+	}
+	if m.Value != nil {
+		r.Value = *m.Value
+	}
+	return r
+}
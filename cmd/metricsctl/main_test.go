@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/handlers"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/internal/storage"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	require.NoError(t, os.WriteFile(valid, []byte(`[
+   {"id": "Alloc", "type": "gauge", "value": 1.5, "source": "host-a", "timestamp": 1000},
+   {"id": "PollCount", "type": "counter", "delta": 2, "source": "host-a"},
+   {"id": "Alloc", "type": "gauge", "value": 2.5, "source": "host-a", "timestamp": 2000},
+   {"id": "PollCount", "type": "counter", "delta": 3, "source": "host-a"},
+   {"id": "Alloc", "type": "gauge", "value": 0.5, "source": "host-a", "timestamp": 500},
+   {"id": "PollCount", "type": "counter", "delta": 7}
+]`), 0666))
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`[{"id": "Alloc", "type": "gauge"}]`), 0666))
+	compacted := filepath.Join(dir, "compacted.json")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		output []string
+	}{
+		{
+			name:   "1",
+			args:   []string{"snapshot", "inspect", valid},
+			code:   exitCodeOK,
+			output: []string{"metrics:  6 (gauge 3, counter 3)", "repeated: 3", "invalid:  0", "  -: 1", "  host-a: 5"},
+		},
+		{
+			name:   "2",
+			args:   []string{"snapshot", "validate", valid},
+			code:   exitCodeOK,
+			output: []string{"2 Alloc: repeats metric 0", "6 metrics are valid"},
+		},
+		{
+			name:   "3",
+			args:   []string{"snapshot", "validate", invalid},
+			code:   exitCodeError,
+			output: []string{"0 Alloc: "},
+		},
+		{
+			name:   "4",
+			args:   []string{"snapshot", "compact", "-o", compacted, valid},
+			code:   exitCodeOK,
+			output: []string{"6 metrics compacted to 3"},
+		},
+		{
+			name: "5",
+			args: []string{"snapshot", "convert", valid},
+			code: exitCodeUsage,
+		},
+		{
+			name: "6",
+			args: []string{"snapshot", "shrink", valid},
+			code: exitCodeUsage,
+		},
+		{
+			name: "7",
+			args: []string{"snapshot", "inspect", filepath.Join(dir, "missing.json")},
+			code: exitCodeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, &stdout, &stderr)
+			assert.Equal(t, tt.code, code, stderr.String())
+			for _, s := range tt.output {
+				assert.Contains(t, stdout.String(), s)
+			}
+		})
+	}
+
+	metrics, err := readSnapshot(compacted)
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "Alloc", metrics[0].ID)
+	assert.Equal(t, 2.5, *metrics[0].Value)
+	assert.Equal(t, "PollCount", metrics[1].ID)
+	assert.Equal(t, int64(5), *metrics[1].Delta)
+	assert.Equal(t, int64(7), *metrics[2].Delta)
+}
+
+func TestHTTPCommands(t *testing.T) {
+	const key = "metricsctl-key"
+	assert.NoError(t, storage.GaugeMetric{Name: "TestCtlGauge", Value: "4.25", Source: "host-ctl"}.Add())
+	assert.NoError(t, storage.CounterMetric{Name: "TestCtlCount", Value: "3", Source: "host-ctl"}.Add())
+	admin := &handlers.Admin{Token: "secret"}
+	r := chi.NewRouter()
+	r.Use(service.NewHashData(key).WithHashVerification)
+	r.Mount("/api/v1", handlers.APIRouter(handlers.MemoryStore{StoreInterval: 300}, "memory", admin))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	output := filepath.Join(t.TempDir(), "metrics.csv")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		output string
+	}{
+		{
+			name:   "1",
+			args:   []string{"-a", addr, "-k", key, "get", "-source", "host-ctl", "gauge", "TestCtlGauge"},
+			code:   exitCodeOK,
+			output: "4.25\n",
+		},
+		{
+			name:   "2",
+			args:   []string{"-a", addr, "-k", key, "list", "-source", "host-ctl", "-prefix", "TestCtl"},
+			code:   exitCodeOK,
+			output: "TYPE     NAME          SOURCE    VALUE\ngauge    TestCtlGauge  host-ctl  4.25\ncounter  TestCtlCount  host-ctl  3\n",
+		},
+		{
+			name:   "3",
+			args:   []string{"-a", addr, "-k", key, "ping"},
+			code:   exitCodeOK,
+			output: "http " + addr + ": ok, request signature accepted\n",
+		},
+		{
+			name: "4",
+			args: []string{"-a", addr, "-k", "wrong", "ping"},
+			code: exitCodeError,
+		},
+		{
+			name: "5",
+			args: []string{"-a", addr, "-k", key, "delete", "-source", "host-ctl", "TestCtl*"},
+			code: exitCodeError,
+		},
+		{
+			name: "6",
+			args: []string{"-a", addr, "-k", key, "export", "-format", "csv", "-o", output, "-source", "host-ctl", "-prefix", "TestCtl"},
+			code: exitCodeOK,
+		},
+		{
+			name:   "7",
+			args:   []string{"-a", addr, "-k", key, "-token", "secret", "delete", "-source", "host-ctl", "TestCtl*"},
+			code:   exitCodeOK,
+			output: "deleted 2\n",
+		},
+		{
+			name: "8",
+			args: []string{"-a", addr, "-k", key, "get", "-source", "host-ctl", "gauge", "TestCtlGauge"},
+			code: exitCodeError,
+		},
+		{
+			name: "9",
+			args: []string{"-a", addr, "get", "gauge"},
+			code: exitCodeUsage,
+		},
+		{
+			name: "10",
+			args: []string{"-transport", "smtp", "ping"},
+			code: exitCodeUsage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tt.args, &stdout, &stderr)
+			assert.Equal(t, tt.code, code, stderr.String())
+			if tt.output != "" {
+				assert.Equal(t, tt.output, stdout.String())
+			}
+		})
+	}
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "id,type,delta,value,source,timestamp\nTestCtlGauge,gauge,,4.25,host-ctl,\nTestCtlCount,counter,3,,host-ctl,\n", string(data))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"musthave-metrics/handlers"
+	"musthave-metrics/internal/postgres"
+	"musthave-metrics/internal/storage"
+)
+
+// runSnapshot выполняет команды с файлом сохранения метрик.
+// Сервер перезаписывает файл целиком, журнала изменений у него нет,
+// поэтому compact сворачивает повторы метрик, например после ручного объединения файлов.
+func runSnapshot(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	commands := map[string]func(context.Context, []string, io.Writer) error{
+		"inspect":  inspectSnapshot,
+		"validate": validateSnapshot,
+		"compact":  compactSnapshot,
+		"convert":  convertSnapshot,
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: metricsctl snapshot <inspect|validate|compact|convert> [flags] <file>")
+		return exitCodeUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "metricsctl snapshot: unknown command %q\n", args[0])
+		return exitCodeUsage
+	}
+	if err := cmd(ctx, args[1:], stdout); err != nil {
+		return report(stderr, "snapshot "+args[0], err)
+	}
+	return exitCodeOK
+}
+
+// readSnapshot читает метрики из файла сохранения.
+func readSnapshot(path string) ([]handlers.MetricsJSON, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return handlers.ReadImport(f, handlers.FormatJSON)
+}
+
+// writeFile записывает файл через временный файл, чтобы при ошибке не повредить прежний.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+type metricKey struct {
+	mtype, name, source string
+}
+
+func keyOf(m handlers.MetricsJSON) metricKey {
+	return metricKey{m.MType, m.ID, m.Source}
+}
+
+func inspectSnapshot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlags("snapshot inspect", stdout)
+	if err := parse(fs, args, 1, "<file>"); err != nil {
+		return err
+	}
+	info, err := os.Stat(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	metrics, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	types := make(map[string]int)
+	sources := make(map[string]int)
+	seen := make(map[metricKey]bool)
+	var repeated, invalid int
+	var oldest, newest time.Time
+	for _, m := range metrics {
+		types[m.MType]++
+		sources[sourceName(m.Source)]++
+		if seen[keyOf(m)] {
+			repeated++
+		}
+		seen[keyOf(m)] = true
+		if handlers.ValidateMetric(m) != nil {
+			invalid++
+		}
+		if ts := storage.UnixMilli(m.Timestamp); !ts.IsZero() {
+			if oldest.IsZero() || ts.Before(oldest) {
+				oldest = ts
+			}
+			if ts.After(newest) {
+				newest = ts
+			}
+		}
+	}
+	fmt.Fprintf(stdout, "file:     %s (%d bytes, modified %s)\n", fs.Arg(0), info.Size(), info.ModTime().Format(time.DateTime))
+	fmt.Fprintf(stdout, "metrics:  %d (gauge %d, counter %d)\n", len(metrics), types["gauge"], types["counter"])
+	fmt.Fprintf(stdout, "repeated: %d\n", repeated)
+	fmt.Fprintf(stdout, "invalid:  %d\n", invalid)
+	if !oldest.IsZero() {
+		fmt.Fprintf(stdout, "gauge timestamps: %s .. %s\n", oldest.Format(time.DateTime), newest.Format(time.DateTime))
+	}
+	names := make([]string, 0, len(sources))
+	for source := range sources {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(stdout, "sources:")
+	for _, source := range names {
+		fmt.Fprintf(stdout, "  %s: %d\n", source, sources[source])
+	}
+	return nil
+}
+
+func validateSnapshot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlags("snapshot validate", stdout)
+	if err := parse(fs, args, 1, "<file>"); err != nil {
+		return err
+	}
+	metrics, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	first := make(map[metricKey]int)
+	invalid := 0
+	for i, m := range metrics {
+		if err := handlers.ValidateMetric(m); err != nil {
+			fmt.Fprintf(stdout, "%d %s: %v\n", i, m.ID, err)
+			invalid++
+			continue
+		}
+		// повторы допустимы: при восстановлении counter суммируются, а gauge заменяются
+		if j, ok := first[keyOf(m)]; ok {
+			fmt.Fprintf(stdout, "%d %s: repeats metric %d\n", i, m.ID, j)
+			continue
+		}
+		first[keyOf(m)] = i
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d metrics are invalid", invalid, len(metrics))
+	}
+	fmt.Fprintf(stdout, "%d metrics are valid\n", len(metrics))
+	return nil
+}
+
+func compactSnapshot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlags("snapshot compact", stdout)
+	output := fs.String("o", "", "output file, the snapshot file itself by default")
+	if err := parse(fs, args, 1, "<file>"); err != nil {
+		return err
+	}
+	metrics, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	records, err := compact(metrics)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = fs.Arg(0)
+	}
+	err = writeFile(*output, func(w io.Writer) error {
+		return handlers.WriteExport(w, handlers.FormatJSON, records)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d metrics compacted to %d\n", len(metrics), len(records))
+	return nil
+}
+
+// compact сворачивает повторы метрик так же, как их применяет восстановление из файла:
+// значения counter суммируются, gauge заменяется более поздним значением, если оно не устарело по timestamp.
+// Порядок первых появлений метрик сохраняется.
+func compact(metrics []handlers.MetricsJSON) ([]storage.Record, error) {
+	index := make(map[metricKey]int)
+	records := make([]storage.Record, 0, len(metrics))
+	for i, m := range metrics {
+		if err := handlers.ValidateMetric(m); err != nil {
+			return nil, fmt.Errorf("metric %d %s: %w", i, m.ID, err)
+		}
+		j, ok := index[keyOf(m)]
+		if !ok {
+			index[keyOf(m)] = len(records)
+			records = append(records, record(m))
+			continue
+		}
+		r := &records[j]
+		if m.MType == "counter" {
+			r.Delta += *m.Delta
+			continue
+		}
+		if ts := storage.UnixMilli(m.Timestamp); ts.IsZero() || !ts.Before(r.Timestamp) {
+			*r = record(m)
+		}
+	}
+	// время обновления задаёт порядок выгрузки
+	now := time.Now()
+	for i := range records {
+		records[i].Updated = now.Add(time.Duration(i))
+	}
+	return records, nil
+}
+
+func convertSnapshot(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := newFlags("snapshot convert", stdout)
+	toDB := fs.String("to-db", "", "PostgreSQL DSN to load the snapshot file into")
+	fromDB := fs.String("from-db", "", "PostgreSQL DSN to save into the snapshot file")
+	if err := parse(fs, args, 1, "-to-db <DSN> | -from-db <DSN> <file>"); err != nil {
+		return err
+	}
+	if (*toDB == "") == (*fromDB == "") {
+		return usageError("exactly one of -to-db and -from-db is required")
+	}
+	if *toDB != "" {
+		n, err := snapshotToDB(ctx, fs.Arg(0), *toDB)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%d metrics loaded into the database\n", n)
+		return nil
+	}
+	n, err := snapshotFromDB(ctx, *fromDB, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d metrics saved to %s\n", n, fs.Arg(0))
+	return nil
+}
+
+// snapshotToDB загружает метрики из файла в СУБД одной транзакцией.
+// Значения counter прибавляются к имеющимся, как при восстановлении из файла.
+func snapshotToDB(ctx context.Context, path string, dsn string) (int, error) {
+	metrics, err := readSnapshot(path)
+	if err != nil {
+		return 0, err
+	}
+	batch := make([]postgres.Metrics, 0, len(metrics))
+	for i, m := range metrics {
+		if err := handlers.ValidateMetric(m); err != nil {
+			return 0, fmt.Errorf("metric %d %s: %w", i, m.ID, err)
+		}
+		batch = append(batch, postgres.Metrics(m))
+	}
+	postgres.SetDB(ctx, dsn)
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	settings := postgres.NewPSQLStr(dsn)
+	if _, err := settings.Updates(ctx, db, batch); err != nil {
+		var itemErr postgres.ItemError
+		if errors.As(err, &itemErr) {
+			return 0, fmt.Errorf("metric %d %s: %w", itemErr.Index, metrics[itemErr.Index].ID, itemErr.Err)
+		}
+		return 0, err
+	}
+	return len(batch), nil
+}
+
+// snapshotFromDB сохраняет метрики из СУБД в файл в формате файла сохранения сервера.
+func snapshotFromDB(ctx context.Context, dsn string, path string) (int, error) {
+	db, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	settings := postgres.NewPSQLStr(dsn)
+	records, err := settings.Records(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	err = writeFile(path, func(w io.Writer) error {
+		return handlers.WriteExport(w, handlers.FormatJSON, records)
+	})
+	return len(records), err
+}
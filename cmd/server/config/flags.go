@@ -7,6 +7,11 @@ import (
 	"flag"
 	"log"
 	"os"
+	"strconv"
+
+	"musthave-metrics/internal/agents"
+	"musthave-metrics/internal/alerts"
+	"musthave-metrics/internal/rollup"
 
 	"github.com/caarlos0/env"
 )
@@ -19,17 +24,50 @@ type ServerFlags struct {
 	FlagDatabaseDSN     string `json:"database_dsn"`
 	FlagHashKey         string
 	FlagMemProfile      string
-	FlagCryptoKey       string `json:"crypto_key"`
-	FlagTrustedSubnet   string `json:"trusted_subnet"`
-	EnvStoreInterval    int    `env:"STORE_INTERVAL"`
-	FileStoragePath     string `env:"FILE_STORAGE_PATH"`
-	EnvRestore          bool   `env:"RESTORE"`
-	DatabaseDSN         string `env:"DATABASE_DSN"`
-	EnvHashKey          string `env:"KEY"`
-	MemProfile          string `env:"MEM_PROFILE"`
-	envCryptoKey        string `env:"CRYPTO_KEY"`
-	Config              string `env:"CONFIGSRV"`
-	envTrustedSubnet    string `env:"TRUSTED_SUBNET"`
+	FlagCryptoKey       string           `json:"crypto_key"`
+	FlagTrustedSubnet   string           `json:"trusted_subnet"`
+	FlagAgentStale      int              `json:"agent_stale_intervals"`
+	AgentConfig         agents.ConfigSet `json:"agent_config"`
+	FlagRollupInterval  int              `json:"rollup_interval"`
+	RollupRules         []rollup.Rule    `json:"rollup_rules"`
+	FlagAlertInterval   int              `json:"alert_interval"`
+	FlagAlertWebhook    string           `json:"alert_webhook"`
+	AlertRules          []alerts.Rule    `json:"alert_rules"`
+	FlagIdempotencyTTL  int              `json:"idempotency_ttl"`
+	FlagBatchMode       string           `json:"batch_mode"`
+	FlagAdminToken      string           `json:"admin_token"`
+	FlagAuditFile       string           `json:"audit_file"`
+	FlagCompress        string           `json:"compress"`
+	FlagCompressMinSize int              `json:"compress_min_size"`
+	FlagCompressTypes   string           `json:"compress_types"`
+	FlagMaxDecodedBody  int64            `json:"max_decoded_body"`
+	FlagMaxBodySize     int64            `json:"max_body_size"`
+	FlagMaxBatch        int              `json:"max_batch_metrics"`
+	FlagSelfMetrics     int              `json:"self_metrics_interval"`
+	EnvStoreInterval    int              `env:"STORE_INTERVAL"`
+	FileStoragePath     string           `env:"FILE_STORAGE_PATH"`
+	EnvRestore          bool             `env:"RESTORE"`
+	DatabaseDSN         string           `env:"DATABASE_DSN"`
+	EnvHashKey          string           `env:"KEY"`
+	MemProfile          string           `env:"MEM_PROFILE"`
+	envCryptoKey        string           `env:"CRYPTO_KEY"`
+	Config              string           `env:"CONFIGSRV"`
+	envTrustedSubnet    string           `env:"TRUSTED_SUBNET"`
+	envAgentStale       int              `env:"AGENT_STALE_INTERVALS"`
+	envRollupInterval   int              `env:"ROLLUP_INTERVAL"`
+	envAlertInterval    int              `env:"ALERT_INTERVAL"`
+	envAlertWebhook     string           `env:"ALERT_WEBHOOK"`
+	envIdempotencyTTL   int              `env:"IDEMPOTENCY_TTL"`
+	envBatchMode        string           `env:"BATCH_MODE"`
+	envAdminToken       string           `env:"ADMIN_TOKEN"`
+	envAuditFile        string           `env:"AUDIT_FILE"`
+	envCompress         string           `env:"COMPRESS"`
+	envCompressMinSize  int              `env:"COMPRESS_MIN_SIZE"`
+	envCompressTypes    string           `env:"COMPRESS_TYPES"`
+	envMaxDecodedBody   int64            `env:"MAX_DECODED_BODY"`
+	envMaxBodySize      int64            `env:"MAX_BODY_SIZE"`
+	envMaxBatch         int              `env:"MAX_BATCH_METRICS"`
+	envSelfMetrics      int              `env:"SELF_METRICS_INTERVAL"`
 }
 
 // ParseFlags обрабатывает аргументы командной строки
@@ -68,6 +106,87 @@ func ParseFlags() ServerFlags {
 	// регистрируем переменную FlagTrustedSubnet
 	// как аргумент -t со значением строкового представления бесклассовой адресации (CIDR).
 	flag.StringVar(&cfg.FlagTrustedSubnet, "t", "127.0.0.1/24", "trusted subnet")
+	// регистрируем переменную FlagAgentStale
+	// число пропущенных интервалов отправки, после которого агент считается неактивным (по умолчанию 3)
+	agentStale := cfg.FlagAgentStale
+	if agentStale == 0 {
+		agentStale = 3
+	}
+	flag.IntVar(&cfg.FlagAgentStale, "agent-stale", agentStale, "missed report intervals before agent is stale")
+	// регистрируем переменную FlagRollupInterval
+	// интервал вычисления производных метрик в секундах (по умолчанию 0 — после каждого получения метрик)
+	flag.IntVar(&cfg.FlagRollupInterval, "rollup-interval", cfg.FlagRollupInterval, "rollup rules evaluation interval")
+	// регистрируем переменную FlagAlertInterval
+	// интервал проверки правил оповещения в секундах (по умолчанию 10)
+	alertInterval := cfg.FlagAlertInterval
+	if alertInterval == 0 {
+		alertInterval = 10
+	}
+	flag.IntVar(&cfg.FlagAlertInterval, "alert-interval", alertInterval, "alert rules evaluation interval")
+	// регистрируем переменную FlagAlertWebhook
+	// адрес, на который отправляются уведомления о срабатывании оповещений (пустое значение отключает уведомления)
+	flag.StringVar(&cfg.FlagAlertWebhook, "alert-webhook", cfg.FlagAlertWebhook, "alert notifications webhook URL")
+	// регистрируем переменную FlagIdempotencyTTL
+	// время хранения ключей применённых пакетов метрик в секундах (по умолчанию 600)
+	idempotencyTTL := cfg.FlagIdempotencyTTL
+	if idempotencyTTL == 0 {
+		idempotencyTTL = 600
+	}
+	flag.IntVar(&cfg.FlagIdempotencyTTL, "idempotency-ttl", idempotencyTTL, "idempotency keys TTL")
+	// регистрируем переменную FlagBatchMode
+	// режим применения пакета метрик: atomic — только целиком, partial — корректные метрики (по умолчанию atomic)
+	batchMode := cfg.FlagBatchMode
+	if batchMode == "" {
+		batchMode = "atomic"
+	}
+	flag.StringVar(&cfg.FlagBatchMode, "batch-mode", batchMode, "batch updates mode: atomic or partial")
+	// регистрируем переменную FlagAdminToken
+	// токен для удаления и сброса метрик (пустое значение запрещает эти операции)
+	flag.StringVar(&cfg.FlagAdminToken, "admin-token", cfg.FlagAdminToken, "admin token for metric deletion, reset and agent configuration")
+	// регистрируем переменную FlagAuditFile
+	// файл журнала аудита удаления и сброса метрик (пустое значение — журнал только в памяти)
+	flag.StringVar(&cfg.FlagAuditFile, "audit-file", cfg.FlagAuditFile, "audit log file path")
+	// регистрируем переменную FlagCompress
+	// кодеки сжатия ответов через запятую в порядке предпочтения: zstd, br, gzip (пустое значение отключает сжатие)
+	compressCodecs := cfg.FlagCompress
+	if compressCodecs == "" {
+		compressCodecs = "zstd,br,gzip"
+	}
+	flag.StringVar(&cfg.FlagCompress, "compress", compressCodecs, "response compression codecs in order of preference")
+	// регистрируем переменную FlagCompressMinSize
+	// наименьший размер сжимаемого ответа в байтах (по умолчанию 512)
+	compressMinSize := cfg.FlagCompressMinSize
+	if compressMinSize == 0 {
+		compressMinSize = 512
+	}
+	flag.IntVar(&cfg.FlagCompressMinSize, "compress-min-size", compressMinSize, "minimum response size to compress")
+	// регистрируем переменную FlagCompressTypes
+	// типы сжимаемых ответов через запятую, text/* разрешает все подтипы (пустое значение — типы по умолчанию)
+	flag.StringVar(&cfg.FlagCompressTypes, "compress-types", cfg.FlagCompressTypes, "content types to compress")
+	// регистрируем переменную FlagMaxDecodedBody
+	// наибольший размер тела запроса после распаковки в байтах (по умолчанию 32 МиБ)
+	maxDecodedBody := cfg.FlagMaxDecodedBody
+	if maxDecodedBody == 0 {
+		maxDecodedBody = 32 << 20
+	}
+	flag.Int64Var(&cfg.FlagMaxDecodedBody, "max-decoded-body", maxDecodedBody, "maximum decompressed request body size")
+	// регистрируем переменную FlagMaxBodySize
+	// наибольший размер тела запроса до распаковки в байтах (по умолчанию 8 МиБ, 0 — без ограничения)
+	maxBodySize := cfg.FlagMaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = 8 << 20
+	}
+	flag.Int64Var(&cfg.FlagMaxBodySize, "max-body-size", maxBodySize, "maximum request body size")
+	// регистрируем переменную FlagMaxBatch
+	// наибольшее число метрик в пакете (по умолчанию 10000, 0 — без ограничения)
+	maxBatch := cfg.FlagMaxBatch
+	if maxBatch == 0 {
+		maxBatch = 10000
+	}
+	flag.IntVar(&cfg.FlagMaxBatch, "max-batch-metrics", maxBatch, "maximum number of metrics in a batch")
+	// регистрируем переменную FlagSelfMetrics
+	// интервал записи метрик самого сервера в хранилище метрик в секундах (0 — метрики только в /metrics)
+	flag.IntVar(&cfg.FlagSelfMetrics, "self-metrics-interval", cfg.FlagSelfMetrics, "interval of writing server metrics into the metric store")
 	// парсим переданные серверу аргументы в зарегистрированные переменные
 	flag.Parse()
 
@@ -107,6 +226,81 @@ func ParseFlags() ServerFlags {
 	} else if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
 		cfg.FlagTrustedSubnet = envTrustedSubnet
 	}
+	if cfg.envAgentStale != 0 {
+		cfg.FlagAgentStale = cfg.envAgentStale
+	} else if envAgentStale, err := strconv.Atoi(os.Getenv("AGENT_STALE_INTERVALS")); err == nil && envAgentStale > 0 {
+		cfg.FlagAgentStale = envAgentStale
+	}
+	if cfg.envRollupInterval != 0 {
+		cfg.FlagRollupInterval = cfg.envRollupInterval
+	} else if envRollupInterval, err := strconv.Atoi(os.Getenv("ROLLUP_INTERVAL")); err == nil && envRollupInterval >= 0 {
+		cfg.FlagRollupInterval = envRollupInterval
+	}
+	if cfg.envAlertInterval != 0 {
+		cfg.FlagAlertInterval = cfg.envAlertInterval
+	} else if envAlertInterval, err := strconv.Atoi(os.Getenv("ALERT_INTERVAL")); err == nil && envAlertInterval > 0 {
+		cfg.FlagAlertInterval = envAlertInterval
+	}
+	if cfg.envAlertWebhook != "" {
+		cfg.FlagAlertWebhook = cfg.envAlertWebhook
+	} else if envAlertWebhook := os.Getenv("ALERT_WEBHOOK"); envAlertWebhook != "" {
+		cfg.FlagAlertWebhook = envAlertWebhook
+	}
+	if cfg.envIdempotencyTTL != 0 {
+		cfg.FlagIdempotencyTTL = cfg.envIdempotencyTTL
+	} else if envIdempotencyTTL, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_TTL")); err == nil && envIdempotencyTTL > 0 {
+		cfg.FlagIdempotencyTTL = envIdempotencyTTL
+	}
+	if cfg.envBatchMode != "" {
+		cfg.FlagBatchMode = cfg.envBatchMode
+	} else if envBatchMode := os.Getenv("BATCH_MODE"); envBatchMode != "" {
+		cfg.FlagBatchMode = envBatchMode
+	}
+	if cfg.envAdminToken != "" {
+		cfg.FlagAdminToken = cfg.envAdminToken
+	} else if envAdminToken := os.Getenv("ADMIN_TOKEN"); envAdminToken != "" {
+		cfg.FlagAdminToken = envAdminToken
+	}
+	if cfg.envAuditFile != "" {
+		cfg.FlagAuditFile = cfg.envAuditFile
+	} else if envAuditFile := os.Getenv("AUDIT_FILE"); envAuditFile != "" {
+		cfg.FlagAuditFile = envAuditFile
+	}
+	if cfg.envCompress != "" {
+		cfg.FlagCompress = cfg.envCompress
+	} else if envCompress, ok := os.LookupEnv("COMPRESS"); ok {
+		cfg.FlagCompress = envCompress
+	}
+	if cfg.envCompressMinSize != 0 {
+		cfg.FlagCompressMinSize = cfg.envCompressMinSize
+	} else if envCompressMinSize, err := strconv.Atoi(os.Getenv("COMPRESS_MIN_SIZE")); err == nil && envCompressMinSize >= 0 {
+		cfg.FlagCompressMinSize = envCompressMinSize
+	}
+	if cfg.envCompressTypes != "" {
+		cfg.FlagCompressTypes = cfg.envCompressTypes
+	} else if envCompressTypes := os.Getenv("COMPRESS_TYPES"); envCompressTypes != "" {
+		cfg.FlagCompressTypes = envCompressTypes
+	}
+	if cfg.envMaxDecodedBody != 0 {
+		cfg.FlagMaxDecodedBody = cfg.envMaxDecodedBody
+	} else if envMaxDecodedBody, err := strconv.ParseInt(os.Getenv("MAX_DECODED_BODY"), 10, 64); err == nil && envMaxDecodedBody >= 0 {
+		cfg.FlagMaxDecodedBody = envMaxDecodedBody
+	}
+	if cfg.envMaxBodySize != 0 {
+		cfg.FlagMaxBodySize = cfg.envMaxBodySize
+	} else if envMaxBodySize, err := strconv.ParseInt(os.Getenv("MAX_BODY_SIZE"), 10, 64); err == nil && envMaxBodySize >= 0 {
+		cfg.FlagMaxBodySize = envMaxBodySize
+	}
+	if cfg.envMaxBatch != 0 {
+		cfg.FlagMaxBatch = cfg.envMaxBatch
+	} else if envMaxBatch, err := strconv.Atoi(os.Getenv("MAX_BATCH_METRICS")); err == nil && envMaxBatch >= 0 {
+		cfg.FlagMaxBatch = envMaxBatch
+	}
+	if cfg.envSelfMetrics != 0 {
+		cfg.FlagSelfMetrics = cfg.envSelfMetrics
+	} else if envSelfMetrics, err := strconv.Atoi(os.Getenv("SELF_METRICS_INTERVAL")); err == nil && envSelfMetrics >= 0 {
+		cfg.FlagSelfMetrics = envSelfMetrics
+	}
 	return cfg
 }
 
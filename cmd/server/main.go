@@ -2,34 +2,46 @@ package main
 
 import (
 	"context"
+	"errors"
 	"expvar"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
 	rpprof "runtime/pprof"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"musthave-metrics/cmd/server/config"
 	"musthave-metrics/handlers"
+	"musthave-metrics/internal/agents"
+	"musthave-metrics/internal/alerts"
+	"musthave-metrics/internal/audit"
 	"musthave-metrics/internal/compress"
 	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/idempotency"
+	"musthave-metrics/internal/limits"
 	"musthave-metrics/internal/logger"
 	"musthave-metrics/internal/postgres"
+	"musthave-metrics/internal/rollup"
 	"musthave-metrics/internal/service"
 	"musthave-metrics/internal/storage"
+	"musthave-metrics/internal/telemetry"
 	"musthave-metrics/proto"
 
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
@@ -47,6 +59,18 @@ type srv struct {
 	// RSA Interceptor
 	kd *service.KeyData
 	st string
+	// реестр агентов
+	agents *agents.Registry
+	// вычислитель производных метрик
+	rollup *rollup.Engine
+	// ключи применённых пакетов метрик
+	keys idempotency.Store
+	// хранилище метрик для чтения, удаления и сброса
+	metrics handlers.Store
+	// удаление и сброс метрик администратором
+	admin *handlers.Admin
+	// наибольший размер принимаемого сообщения, 0 — по умолчанию gRPC
+	maxMsgSize int64
 }
 
 func main() {
@@ -68,7 +92,24 @@ func main() {
 		storeMetrics(cfg)
 	}
 
-	HTTPServer := run(cfg)
+	expvar.Publish("stale_updates", expvar.Func(func() any { return storage.Stale() }))
+	expvar.Publish("rejected_requests", expvar.Func(func() any { return limits.Rejected() }))
+	registerTelemetry()
+	limits.SetMaxBatchMetrics(cfg.FlagMaxBatch)
+	registry := agents.NewRegistry(cfg.FlagAgentStale)
+	configs := agents.NewConfigStore(cfg.AgentConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	db := newPool(ctx, cfg)
+	store := newStore(db)
+	engine := newRollup(ctx, cfg, store)
+	alerting := newAlerts(ctx, cfg, store)
+	keys := newKeys(ctx, cfg, db)
+	metrics := newMetrics(cfg, db)
+	if cfg.FlagSelfMetrics > 0 {
+		go telemetry.Default.Flush(ctx, time.Duration(cfg.FlagSelfMetrics)*time.Second, writeTelemetry(metrics))
+	}
+	admin := newAdmin(cfg)
+	HTTPServer := run(cfg, registry, configs, engine, alerting, keys, metrics, admin)
 
 	// запускаем горутину обработки пойманных прерываний
 	go func() {
@@ -86,7 +127,11 @@ func main() {
 		close(idleConnsClosed)
 	}()
 
-	srv, _ := newServer(cfg)
+	srv, _ := newServer(cfg, registry)
+	srv.rollup = engine
+	srv.keys = keys
+	srv.metrics = metrics
+	srv.admin = admin
 	srv.runGRPCServer()
 
 	// запускаем горутину обработки пойманных прерываний
@@ -110,6 +155,13 @@ func main() {
 
 	// ждём завершения процедуры graceful shutdown
 	<-idleConnsClosed
+	cancel()
+	if db != nil {
+		db.Close()
+	}
+	if err := admin.Trail.Close(); err != nil {
+		logger.Warnf("Audit close error: " + err.Error())
+	}
 	// получили оповещение о завершении
 	// здесь можно освобождать ресурсы перед выходом,
 	// например закрыть соединение с базой данных,
@@ -127,9 +179,13 @@ func main() {
 	}
 }
 
-func run(cfg config.ServerFlags) *http.Server {
+func run(cfg config.ServerFlags, registry *agents.Registry, configs *agents.ConfigStore, engine *rollup.Engine, alerting *alerts.Engine, keys idempotency.Store, metrics handlers.Store, admin *handlers.Admin) *http.Server {
 	logger.ServerRunningInfo(cfg.FlagRunAddr)
 	mux := chi.NewMux()
+	// запросы учитываются в метриках сервера, в том числе отклонённые при проверках ниже
+	mux.Use(logger.WithLogging)
+	// размер тела ограничивается до проверки подписи и расшифровки, которые читают его целиком
+	mux.Use(limits.WithBodyLimit(cfg.FlagMaxBodySize))
 	if cfg.FlagHashKey != "" {
 		hd := service.NewHashData(cfg.FlagHashKey)
 		mux.Use(hd.WithHashVerification)
@@ -142,24 +198,214 @@ func run(cfg config.ServerFlags) *http.Server {
 		ts := service.NewTrustedSubnet(cfg.FlagTrustedSubnet)
 		mux.Use(ts.WithLookupIP)
 	}
-	mux.Use(logger.WithLogging, compress.WithGzipEncoding)
-	mux.Handle("/update/{metricType}/{metricName}/{metricValue}", handlers.UpdateHandler())
-	mux.Handle("/update/", updateHandler(cfg))
-	mux.Handle("/updates/", handlers.UpdateBatchDBHandler(cfg.FlagDatabaseDSN))
-	mux.Handle("/value/{metricType}/{metricName}", handlers.GetValueHandler())
-	mux.Handle("/value/", valueHandler(cfg))
+	mux.Use(newCompression(cfg).WithCompression)
+	// middleware запросов, изменяющих метрики
+	writes := []func(http.Handler) http.Handler{registry.WithTracking}
+	if engine != nil {
+		writes = append(writes, engine.WithEvaluation)
+	}
+	writes = append(writes, idempotency.WithIdempotency(keys))
+	mux.Mount("/api/v1", handlers.APIRouter(metrics, cfg.FlagBatchMode, admin, writes...))
+	// прежние маршруты сохранены для совместимости и передают запросы обработчикам /api/v1
+	handlers.LegacyRoutes(mux, metrics, cfg.FlagBatchMode, writes...)
+	mux.Handle("/list/", listHandler(cfg))
+	mux.Handle("/history/{metricType}/{metricName}", handlers.HistoryHandler())
+	mux.Handle("/agents", handlers.AgentsHandler(registry))
+	mux.Handle("/alerts", handlers.AlertsHandler(alerting))
+	mux.Method(http.MethodGet, "/agents/config", handlers.AgentConfigListHandler(configs))
+	mux.Method(http.MethodPut, "/agents/config", handlers.AgentConfigSetHandler(configs, admin))
+	mux.Method(http.MethodGet, "/agents/{agentID}/config", handlers.AgentConfigHandler(configs))
 	mux.Handle("/ping", handlers.PingDBHandler(cfg.FlagDatabaseDSN))
-	mux.Handle("/", handlers.AllMetricsHandler())
+	// поток изменений панели метрик завершается при остановке сервера
+	events, stopEvents := context.WithCancel(context.Background())
+	mux.Handle("/events", handlers.EventsHandler(events, metrics, handlers.DashboardRefresh))
+	mux.Handle("/static/*", handlers.StaticHandler())
+	mux.Handle("/", handlers.AllMetricsHandler(metrics))
 	mux.Mount("/debug", middleware.Profiler())
+	mux.Method(http.MethodGet, "/metrics", telemetry.Default.Handler())
 
 	HTTPServer := &http.Server{
 		Addr:    cfg.FlagRunAddr,
 		Handler: mux,
 	}
+	HTTPServer.RegisterOnShutdown(stopEvents)
 
 	return HTTPServer
 }
 
+// newPool применяет миграции и открывает пул соединений с СУБД, общий для хранилищ сервера.
+// Возвращает nil, если СУБД не задана или пул не создан.
+func newPool(ctx context.Context, cfg config.ServerFlags) *pgxpool.Pool {
+	if cfg.FlagDatabaseDSN == "" {
+		return nil
+	}
+	postgres.SetDB(ctx, cfg.FlagDatabaseDSN)
+	db, err := pgxpool.New(ctx, cfg.FlagDatabaseDSN)
+	if err != nil {
+		logger.Warnf("Database pool error: " + err.Error())
+		return nil
+	}
+	registerPoolStats(db)
+	return db
+}
+
+// newStore возвращает хранилище, из которого правила читают значения метрик:
+// в СУБД, если пул db задан, иначе в памяти.
+func newStore(db *pgxpool.Pool) rollup.Store {
+	if db == nil {
+		return rollup.MemoryStore{}
+	}
+	return rollup.NewDBStore(db)
+}
+
+// newMetrics возвращает хранилище метрик для /api/v1: в СУБД, если пул db задан, иначе в памяти.
+func newMetrics(cfg config.ServerFlags, db *pgxpool.Pool) handlers.Store {
+	if db == nil {
+		memory := handlers.MemoryStore{StoreInterval: cfg.FlagStoreInterval, FileStoragePath: cfg.FlagFileStoragePath}
+		return handlers.ObserveStore(memory, "memory")
+	}
+	return handlers.ObserveStore(handlers.NewDBStore(db), "db")
+}
+
+// registerTelemetry добавляет в метрики сервера счётчики, которые ведутся в других пакетах.
+func registerTelemetry() {
+	telemetry.Default.NewCounterFunc("stale_updates_total", "Rejected stale gauge updates.", func() float64 {
+		return float64(storage.Stale().Total)
+	})
+	rejected := "Requests rejected for exceeding a size limit."
+	telemetry.Default.NewCounterFunc("requests_too_large_total", rejected, func() float64 {
+		return float64(limits.Rejected().Body)
+	}, telemetry.Label{Name: "limit", Value: "body"})
+	telemetry.Default.NewCounterFunc("requests_too_large_total", rejected, func() float64 {
+		return float64(limits.Rejected().Decoded)
+	}, telemetry.Label{Name: "limit", Value: "decoded"})
+	telemetry.Default.NewCounterFunc("requests_too_large_total", rejected, func() float64 {
+		return float64(limits.Rejected().Batch)
+	}, telemetry.Label{Name: "limit", Value: "batch"})
+}
+
+// registerPoolStats добавляет в метрики сервера статистику пула соединений с СУБД.
+func registerPoolStats(db *pgxpool.Pool) {
+	pool := telemetry.Label{Name: "pool", Value: "server"}
+	gauges := []struct {
+		name, help string
+		fn         func() float64
+	}{
+		{"db_pool_acquired_conns", "Database connections in use.", func() float64 { return float64(db.Stat().AcquiredConns()) }},
+		{"db_pool_idle_conns", "Idle database connections.", func() float64 { return float64(db.Stat().IdleConns()) }},
+		{"db_pool_total_conns", "Open database connections.", func() float64 { return float64(db.Stat().TotalConns()) }},
+		{"db_pool_max_conns", "Maximum size of the database connection pool.", func() float64 { return float64(db.Stat().MaxConns()) }},
+	}
+	for _, g := range gauges {
+		telemetry.Default.NewGaugeFunc(g.name, g.help, g.fn, pool)
+	}
+	telemetry.Default.NewCounterFunc("db_pool_acquires_total", "Database connections acquired from the pool.", func() float64 {
+		return float64(db.Stat().AcquireCount())
+	}, pool)
+	telemetry.Default.NewCounterFunc("db_pool_empty_acquires_total", "Acquires that waited for a connection because the pool was empty.", func() float64 {
+		return float64(db.Stat().EmptyAcquireCount())
+	}, pool)
+	telemetry.Default.NewCounterFunc("db_pool_acquire_duration_seconds_total", "Total time spent acquiring connections from the pool.", func() float64 {
+		return db.Stat().AcquireDuration().Seconds()
+	}, pool)
+}
+
+// writeTelemetry возвращает функцию записи метрик сервера в хранилище store.
+// Значения записываются как gauge с префиксом telemetry.Prefix, метки передаются источником метрики,
+// поэтому накопленные значения счётчиков не суммируются повторно при каждой записи.
+func writeTelemetry(store handlers.Store) func(context.Context, []telemetry.Sample) {
+	return func(ctx context.Context, samples []telemetry.Sample) {
+		batch := make([]handlers.MetricsJSON, 0, len(samples))
+		for _, s := range samples {
+			value := s.Value
+			m := handlers.MetricsJSON{ID: telemetry.Prefix + s.Name, MType: "gauge", Value: &value, Source: s.LabelString()}
+			if err := handlers.ValidateMetric(m); err != nil {
+				continue
+			}
+			batch = append(batch, m)
+		}
+		if _, err := store.Updates(ctx, batch, false); err != nil {
+			logger.Warnf("Server metrics store error: " + err.Error())
+		}
+	}
+}
+
+// newAdmin возвращает параметры удаления и сброса метрик с журналом аудита.
+// Если файл журнала не открывается, записи хранятся только в памяти.
+func newAdmin(cfg config.ServerFlags) *handlers.Admin {
+	trail, err := audit.New(cfg.FlagAuditFile)
+	if err != nil {
+		logger.Warnf("Audit file error: " + err.Error())
+		trail, _ = audit.New("")
+	}
+	return &handlers.Admin{Token: cfg.FlagAdminToken, Trail: trail}
+}
+
+// newCompression возвращает middleware сжатия с кодеками и ограничениями из конфигурации.
+// При неизвестном кодеке используются кодеки по умолчанию.
+func newCompression(cfg config.ServerFlags) *compress.Compression {
+	c := compress.DefaultConfig()
+	codecs, err := compress.ParseCodecs(cfg.FlagCompress)
+	if err != nil {
+		logger.Warnf("Compression error: " + err.Error())
+	} else {
+		c.Encodings = codecs
+	}
+	c.MinSize = cfg.FlagCompressMinSize
+	c.MaxDecodedSize = cfg.FlagMaxDecodedBody
+	if cfg.FlagCompressTypes != "" {
+		c.ContentTypes = strings.Split(strings.ReplaceAll(cfg.FlagCompressTypes, " ", ""), ",")
+	}
+	return compress.New(c)
+}
+
+// newKeys возвращает хранилище ключей применённых пакетов метрик:
+// в СУБД, если пул db задан, иначе в памяти. Ключи с истёкшим временем хранения
+// удаляются периодически до отмены ctx.
+func newKeys(ctx context.Context, cfg config.ServerFlags, db *pgxpool.Pool) idempotency.Store {
+	ttl := time.Duration(cfg.FlagIdempotencyTTL) * time.Second
+	var keys idempotency.Store = idempotency.NewCache(ttl)
+	if db != nil {
+		keys = idempotency.NewDBStore(db, ttl)
+	}
+	go idempotency.Run(ctx, keys, idempotency.ExpireInterval)
+	return keys
+}
+
+// newRollup запускает вычисление производных метрик по правилам из конфигурации.
+// Возвращает nil, если правила не заданы.
+func newRollup(ctx context.Context, cfg config.ServerFlags, store rollup.Store) *rollup.Engine {
+	if len(cfg.RollupRules) == 0 {
+		return nil
+	}
+	engine, err := rollup.New(cfg.RollupRules, store, time.Duration(cfg.FlagRollupInterval)*time.Second)
+	if err != nil {
+		logger.Warnf("Rollup rules error: " + err.Error())
+		return nil
+	}
+	go engine.Run(ctx)
+	return engine
+}
+
+// newAlerts запускает проверку правил оповещения из конфигурации.
+// Возвращает nil, если правила не заданы.
+func newAlerts(ctx context.Context, cfg config.ServerFlags, store rollup.Store) *alerts.Engine {
+	if len(cfg.AlertRules) == 0 {
+		return nil
+	}
+	var notifier *alerts.Notifier
+	if cfg.FlagAlertWebhook != "" {
+		notifier = alerts.NewNotifier(cfg.FlagAlertWebhook)
+	}
+	engine, err := alerts.New(cfg.AlertRules, store, notifier, time.Duration(cfg.FlagAlertInterval)*time.Second)
+	if err != nil {
+		logger.Warnf("Alert rules error: " + err.Error())
+		return nil
+	}
+	go engine.Run(ctx)
+	return engine
+}
+
 func storeMetrics(cfg config.ServerFlags) {
 	f := func() {
 		handlers.StoreMetrics(cfg.FlagFileStoragePath)
@@ -168,21 +414,11 @@ func storeMetrics(cfg config.ServerFlags) {
 	time.AfterFunc(time.Duration(cfg.FlagStoreInterval)*time.Second, f)
 }
 
-func updateHandler(cfg config.ServerFlags) http.Handler {
+func listHandler(cfg config.ServerFlags) http.Handler {
 	if cfg.FlagDatabaseDSN != "" {
-		ctx := context.Background()
-		postgres.SetDB(ctx, cfg.FlagDatabaseDSN)
-		return handlers.UpdateDBHandler(ctx, cfg.FlagDatabaseDSN, cfg.FlagHashKey)
+		return handlers.ListDBHandler(cfg.FlagDatabaseDSN)
 	}
-	return handlers.UpdateJSONHandler(cfg.FlagStoreInterval, cfg.FlagFileStoragePath)
-}
-
-func valueHandler(cfg config.ServerFlags) http.Handler {
-	if cfg.FlagDatabaseDSN != "" {
-		ctx := context.Background()
-		return handlers.GetValueDBHandler(ctx, cfg.FlagDatabaseDSN, cfg.FlagHashKey)
-	}
-	return handlers.GetValueJSONHandler()
+	return handlers.ListHandler()
 }
 
 func Profiler() http.Handler {
@@ -213,11 +449,13 @@ func Profiler() http.Handler {
 	return r
 }
 
-func newServer(cfg config.ServerFlags) (*srv, error) {
+func newServer(cfg config.ServerFlags, registry *agents.Registry) (*srv, error) {
 	return &srv{
-		ts: service.NewTrustedSubnet(cfg.FlagTrustedSubnet),
-		kd: service.NewKeyData(cfg.FlagCryptoKey),
-		st: "SecretToken"}, nil
+		ts:         service.NewTrustedSubnet(cfg.FlagTrustedSubnet),
+		kd:         service.NewKeyData(cfg.FlagCryptoKey),
+		st:         "SecretToken",
+		agents:     registry,
+		maxMsgSize: cfg.FlagMaxBodySize}, nil
 }
 
 func (srv *srv) runGRPCServer() {
@@ -227,7 +465,11 @@ func (srv *srv) runGRPCServer() {
 		logger.Warnf("gRPC Server error: " + err.Error())
 	}
 
-	srv.gRPCServer = grpc.NewServer(grpc.ChainUnaryInterceptor(srv.lookupIPInterceptor, srv.rsaInterceptor))
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(srv.metricsInterceptor, srv.agentsInterceptor, srv.lookupIPInterceptor, srv.rsaInterceptor)}
+	if srv.maxMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(srv.maxMsgSize)))
+	}
+	srv.gRPCServer = grpc.NewServer(opts...)
 
 	proto.RegisterMetricServerServer(srv.gRPCServer, srv)
 	logger.Infof("Сервер gRPC начал работу")
@@ -242,15 +484,54 @@ func (srv *srv) runGRPCServer() {
 
 func (srv *srv) PushProtoMetrics(ctx context.Context, in *proto.PushProtoMetricsRequest) (*proto.PushProtoMetricsResponse, error) {
 	var response proto.PushProtoMetricsResponse
+	if err := limits.CheckBatch(len(in.Metrics)); err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
 
+	md, _ := metadata.FromIncomingContext(ctx)
+	source := firstMetadata(md, service.AgentIDHeader)
+	key := in.IdempotencyKey
+	if key == "" {
+		key = firstMetadata(md, service.IdempotencyKeyHeader)
+	}
+	if key != "" && srv.keys != nil {
+		key = idempotency.Key(source, key)
+		_, done, err := srv.keys.Reserve(ctx, key)
+		if errors.Is(err, idempotency.ErrInProgress) {
+			// пакет с тем же ключом ещё обрабатывается: агент повторит запрос
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if done {
+			// пакет уже применён
+			return &response, nil
+		}
+		defer func() {
+			if err := srv.keys.Complete(context.WithoutCancel(ctx), key, idempotency.Result{Status: http.StatusOK}); err != nil {
+				logger.Warnf("Idempotency store error: " + err.Error())
+			}
+		}()
+	}
 	for _, m := range in.Metrics {
+		metric := handlers.MetricsJSON{ID: m.ID, MType: m.MType, Delta: m.Delta, Value: m.Value, Source: source, Timestamp: m.Timestamp}
+		if err := handlers.ValidateReport(metric); err != nil {
+			logger.Warnf("no valid metric " + m.ID + ": " + err.Error())
+			continue
+		}
 		if m.MType == "gauge" {
-			err := storage.GaugeMetric{Name: m.ID, Value: strconv.FormatFloat(*m.Value, 'g', -1, 64)}.Add()
-			if err != nil {
+			err := storage.GaugeMetric{
+				Name:      m.ID,
+				Value:     strconv.FormatFloat(*m.Value, 'g', -1, 64),
+				Source:    source,
+				Timestamp: storage.UnixMilli(m.Timestamp),
+			}.Add()
+			if err != nil && !errors.Is(err, storage.ErrStaleUpdate) {
 				logger.Warnf("GaugeMetric add error: " + err.Error())
 			}
 		} else if m.MType == "counter" {
-			err := storage.CounterMetric{Name: m.ID, Value: strconv.FormatInt(*m.Delta, 10)}.Add()
+			err := storage.CounterMetric{Name: m.ID, Value: strconv.FormatInt(*m.Delta, 10), Source: source}.Add()
 			if err != nil {
 				logger.Warnf("CounterMetric add error: " + err.Error())
 			}
@@ -259,10 +540,166 @@ func (srv *srv) PushProtoMetrics(ctx context.Context, in *proto.PushProtoMetrics
 			logger.Infof("no valid metric type: " + m.MType)
 		}
 	}
+	if srv.rollup != nil {
+		srv.rollup.Notify()
+	}
+
+	return &response, nil
+}
+
+// ListAgents возвращает сведения об агентах, присылавших метрики.
+func (srv *srv) ListAgents(ctx context.Context, in *proto.ListAgentsRequest) (*proto.ListAgentsResponse, error) {
+	var response proto.ListAgentsResponse
+	for _, a := range srv.agents.List() {
+		response.Agents = append(response.Agents, &proto.Agent{
+			ID:             a.ID,
+			Address:        a.Address,
+			Version:        a.Version,
+			Transport:      a.Transport,
+			ReportInterval: a.ReportInterval,
+			FirstSeen:      a.FirstSeen.UnixMilli(),
+			LastReport:     a.LastReport.UnixMilli(),
+			Reports:        a.Reports,
+			Errors:         a.Errors,
+			Stale:          a.Stale,
+			Group:          a.Group,
+			ConfigVersion:  a.ConfigVersion,
+		})
+	}
+	return &response, nil
+}
+
+// DeleteMetrics удаляет метрики по имени или шаблону имени.
+func (srv *srv) DeleteMetrics(ctx context.Context, in *proto.DeleteMetricsRequest) (*proto.DeleteMetricsResponse, error) {
+	f := storage.Filter{MType: in.MType, Pattern: in.Pattern, Source: in.Source}
+	affected, err := srv.applyAdmin(ctx, audit.ActionDelete, f)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.DeleteMetricsResponse{Affected: affected}, nil
+}
+
+// ResetCounters обнуляет значения counter по имени или шаблону имени.
+func (srv *srv) ResetCounters(ctx context.Context, in *proto.ResetCountersRequest) (*proto.ResetCountersResponse, error) {
+	f := storage.Filter{MType: "counter", Pattern: in.Pattern, Source: in.Source}
+	affected, err := srv.applyAdmin(ctx, audit.ActionReset, f)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.ResetCountersResponse{Affected: affected}, nil
+}
+
+// GetMetric возвращает значение метрики: для gauge — последнее полученное,
+// для counter — сумму по источникам или значение, накопленное источником Source.
+func (srv *srv) GetMetric(ctx context.Context, in *proto.GetMetricRequest) (*proto.GetMetricResponse, error) {
+	if in.MType != "gauge" && in.MType != "counter" {
+		return nil, status.Error(codes.InvalidArgument, "unknown metric type "+in.MType)
+	}
+	m, err := srv.metrics.Value(ctx, in.MType, in.ID, in.Source)
+	if errors.Is(err, handlers.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &proto.GetMetricResponse{Metric: handlers.ProtoMetric(m)}, nil
+}
 
+// ListMetrics возвращает страницу значений метрик в разрезе источников.
+func (srv *srv) ListMetrics(ctx context.Context, in *proto.ListMetricsRequest) (*proto.ListMetricsResponse, error) {
+	values := url.Values{
+		"type":   {in.MType},
+		"prefix": {in.Prefix},
+		"match":  {in.Match},
+		"source": {in.Source},
+		"sort":   {in.Sort},
+		"cursor": {in.Cursor},
+	}
+	if in.Limit > 0 {
+		values.Set("limit", strconv.FormatInt(in.Limit, 10))
+	}
+	q, err := handlers.ParseListQuery(values)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	records, err := srv.metrics.List(ctx, q.Source)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	page, next, err := q.Page(records)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	response := proto.ListMetricsResponse{NextCursor: next}
+	for _, r := range page {
+		response.Metrics = append(response.Metrics, handlers.ProtoMetric(handlers.RecordJSON(r)))
+	}
 	return &response, nil
 }
 
+// applyAdmin выполняет операцию администратора с токеном из метаданных authorization.
+func (srv *srv) applyAdmin(ctx context.Context, action string, f storage.Filter) (int64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	caller := firstMetadata(md, "X-Real-IP")
+	if p, ok := peer.FromContext(ctx); ok && caller == "" {
+		caller = p.Addr.String()
+	}
+	affected, err := srv.admin.Apply(ctx, srv.metrics, action, f, firstMetadata(md, service.AuthorizationHeader), caller, "grpc")
+	switch {
+	case errors.Is(err, service.ErrAdminDisabled):
+		return 0, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, service.ErrUnauthorized):
+		return 0, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, storage.ErrBadFilter):
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return 0, status.Error(codes.Internal, err.Error())
+	}
+	return affected, nil
+}
+
+// metricsInterceptor учитывает вызовы gRPC в метриках сервера, в том числе отклонённые при проверках.
+func (srv *srv) metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	telemetry.ObserveGRPC(info.FullMethod, status.Code(err).String(), time.Since(start))
+	return resp, err
+}
+
+// agentsInterceptor учитывает в реестре отправки метрик агентами по gRPC,
+// в том числе отклонённые другими перехватчиками.
+func (srv *srv) agentsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if info.FullMethod != proto.MetricServer_PushProtoMetrics_FullMethodName || srv.agents == nil {
+		return resp, err
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	report := agents.Report{
+		ID:             firstMetadata(md, service.AgentIDHeader),
+		Address:        firstMetadata(md, "X-Real-IP"),
+		Version:        firstMetadata(md, service.AgentVersionHeader),
+		Transport:      agents.TransportGRPC,
+		ReportInterval: agents.ParseInterval(firstMetadata(md, service.AgentIntervalHeader)),
+		Group:          firstMetadata(md, service.AgentGroupHeader),
+		ConfigVersion:  firstMetadata(md, service.AgentConfigVersionHeader),
+		Failed:         err != nil,
+	}
+	if report.Address == "" {
+		if p, ok := peer.FromContext(ctx); ok {
+			report.Address = p.Addr.String()
+		}
+	}
+	srv.agents.Observe(report)
+	return resp, err
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
 func (srv *srv) lookupIPInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	var locallinkIP string
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
@@ -272,14 +709,17 @@ func (srv *srv) lookupIPInterceptor(ctx context.Context, req interface{}, info *
 		}
 	}
 	if len(locallinkIP) == 0 {
-		return nil, status.Error(codes.Aborted, "not found client IP")
+		telemetry.AuthFailures.Inc("grpc", telemetry.ReasonSubnet)
+		return nil, status.Error(codes.PermissionDenied, "not found client IP")
 	}
 	trusted, err := service.FindIPInTrustedSubnet(locallinkIP, srv.ts.TrustedSubnet)
 	if err != nil {
-		return nil, status.Error(codes.Aborted, err.Error())
+		telemetry.AuthFailures.Inc("grpc", telemetry.ReasonSubnet)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
 	}
 	if !trusted {
-		return nil, status.Error(codes.Aborted, "agent IP not in trusted subnet")
+		telemetry.AuthFailures.Inc("grpc", telemetry.ReasonSubnet)
+		return nil, status.Error(codes.PermissionDenied, "agent IP not in trusted subnet")
 
 	}
 	return handler(ctx, req)
@@ -293,6 +733,11 @@ func (srv *srv) rsaInterceptor(ctx context.Context, req interface{}, info *grpc.
 			cryptoKey = paramKey[0]
 		}
 		paramToken := md.Get("X-Secret-Token")
+		if len(paramToken) == 0 {
+			// зашифрованный токен в формате PEM содержит переводы строк,
+			// поэтому передаётся в бинарных метаданных
+			paramToken = md.Get("X-Secret-Token-Bin")
+		}
 		if len(paramToken) > 0 {
 			secretToken = paramToken[0]
 		}
@@ -300,17 +745,20 @@ func (srv *srv) rsaInterceptor(ctx context.Context, req interface{}, info *grpc.
 	if len(cryptoKey) > 0 {
 
 		if len(secretToken) == 0 {
-			return nil, status.Error(codes.Aborted, "not found secret token")
+			telemetry.AuthFailures.Inc("grpc", telemetry.ReasonToken)
+			return nil, status.Error(codes.Unauthenticated, "not found secret token")
 		}
 
 		decryptToken, err := crypt.Decrypt(srv.kd.PrivateKeyPath, secretToken)
 
 		if err != nil {
-			return nil, status.Error(codes.Aborted, err.Error())
+			telemetry.AuthFailures.Inc("grpc", telemetry.ReasonDecrypt)
+			return nil, status.Error(codes.Unauthenticated, err.Error())
 		}
 
 		if decryptToken != srv.st {
-			return nil, status.Error(codes.Aborted, "wrong secret token")
+			telemetry.AuthFailures.Inc("grpc", telemetry.ReasonToken)
+			return nil, status.Error(codes.Unauthenticated, "wrong secret token")
 		}
 	}
 
@@ -1,10 +1,26 @@
 package main
 
 import (
+	"context"
 	"musthave-metrics/cmd/server/config"
+	"musthave-metrics/handlers"
+	"musthave-metrics/internal/agents"
+	"musthave-metrics/internal/idempotency"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/internal/storage"
+	"musthave-metrics/internal/telemetry"
+	"musthave-metrics/proto"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestProfiler(t *testing.T) {
@@ -26,26 +42,224 @@ func TestProfiler(t *testing.T) {
 	}
 }
 
-func Test_updateHandler(t *testing.T) {
-	type args struct {
-		cfg config.ServerFlags
+func Test_legacyRoutes(t *testing.T) {
+	cfg := config.ServerFlags{FlagStoreInterval: 300}
+	metrics := handlers.MemoryStore{StoreInterval: 300}
+	server := run(cfg, agents.NewRegistry(0), agents.NewConfigStore(agents.ConfigSet{}), nil, nil, idempotency.NewCache(time.Minute), metrics, nil)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+		body   string
+	}{
+		{name: "1", method: http.MethodPost, path: "/update/gauge/TestLegacyRoute/2.5?source=host-legacy", want: http.StatusOK},
+		{name: "2", method: http.MethodGet, path: "/value/gauge/TestLegacyRoute?source=host-legacy", want: http.StatusOK, body: "2.5"},
+		{name: "3", method: http.MethodGet, path: "/api/v1/metrics/gauge/TestLegacyRoute?source=host-legacy", want: http.StatusOK},
+		{name: "4", method: http.MethodPost, path: "/update/unknown/TestLegacyRoute/1", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.Handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.want, w.Code)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestListAgents(t *testing.T) {
+	s, err := newServer(config.ServerFlags{}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	md := metadata.New(map[string]string{
+		service.AgentIDHeader:       "host-a",
+		service.AgentVersionHeader:  "v1.0.0",
+		service.AgentIntervalHeader: "5",
+		"X-Real-IP":                 "10.0.0.1",
+	})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	info := &grpc.UnaryServerInfo{FullMethod: proto.MetricServer_PushProtoMetrics_FullMethodName}
+	_, err = s.agentsInterceptor(ctx, &proto.PushProtoMetricsRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.PushProtoMetrics(ctx, req.(*proto.PushProtoMetricsRequest))
+	})
+	assert.NoError(t, err)
+
+	resp, err := s.ListAgents(context.Background(), &proto.ListAgentsRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Agents, 1) {
+		a := resp.Agents[0]
+		assert.Equal(t, "host-a", a.ID)
+		assert.Equal(t, "10.0.0.1", a.Address)
+		assert.Equal(t, "v1.0.0", a.Version)
+		assert.Equal(t, agents.TransportGRPC, a.Transport)
+		assert.Equal(t, int64(5), a.ReportInterval)
+		assert.Equal(t, int64(1), a.Reports)
+		assert.False(t, a.Stale)
+	}
+}
+
+func TestPushProtoMetricsIdempotent(t *testing.T) {
+	s, err := newServer(config.ServerFlags{}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	s.keys = idempotency.NewCache(time.Minute)
+	md := metadata.New(map[string]string{service.AgentIDHeader: "host-idempotent"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	delta := int64(5)
+	req := &proto.PushProtoMetricsRequest{
+		Metrics:        []*proto.Metric{{ID: "TestIdempotentCount", MType: "counter", Delta: &delta}},
+		IdempotencyKey: "batch-1",
+	}
+	for i := 0; i < 3; i++ {
+		_, err = s.PushProtoMetrics(ctx, req)
+		assert.NoError(t, err)
+	}
+	value, err := storage.CounterMetric{Name: "TestIdempotentCount", Source: "host-idempotent"}.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "5", value)
+
+	req.IdempotencyKey = "batch-2"
+	_, err = s.PushProtoMetrics(ctx, req)
+	assert.NoError(t, err)
+	value, err = storage.CounterMetric{Name: "TestIdempotentCount", Source: "host-idempotent"}.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "10", value)
+
+	// пакет с тем же ключом ещё обрабатывается: агент повторит запрос
+	_, _, err = s.keys.Reserve(ctx, idempotency.Key("host-idempotent", "batch-3"))
+	assert.NoError(t, err)
+	req.IdempotencyKey = "batch-3"
+	_, err = s.PushProtoMetrics(ctx, req)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestRSAInterceptor(t *testing.T) {
+	s, err := newServer(config.ServerFlags{}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	s.kd.PrivateKeyPath = "testdata/missing.pem"
+	info := &grpc.UnaryServerInfo{FullMethod: proto.MetricServer_PushProtoMetrics_FullMethodName}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &proto.PushProtoMetricsResponse{}, nil
 	}
 	tests := []struct {
 		name string
-		args args
-		want http.Handler
+		md   map[string]string
+		want codes.Code
 	}{
-		{
-			name: "1",
-			args: args{},
-			want: nil,
-		},
+		{name: "1", md: map[string]string{}, want: codes.OK},
+		{name: "2", md: map[string]string{"X-Crypto-Key": "public.pem"}, want: codes.Unauthenticated},
+		{name: "3", md: map[string]string{"X-Crypto-Key": "public.pem", "X-Secret-Token": "token"}, want: codes.Unauthenticated},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := updateHandler(tt.args.cfg); reflect.DeepEqual(got, tt.want) {
-				t.Errorf("updateHandler() = %v, want %v", got, tt.want)
-			}
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.New(tt.md))
+			_, err := s.rsaInterceptor(ctx, &proto.PushProtoMetricsRequest{}, info, handler)
+			assert.Equal(t, tt.want, status.Code(err))
+		})
+	}
+}
+
+func TestDeleteMetricsGRPC(t *testing.T) {
+	s, err := newServer(config.ServerFlags{}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	s.metrics = handlers.MemoryStore{StoreInterval: 300}
+	s.admin = &handlers.Admin{Token: "secret"}
+	assert.NoError(t, storage.CounterMetric{Name: "TestGRPCDelete", Value: "5", Source: "host-grpc-delete"}.Add())
+
+	req := &proto.DeleteMetricsRequest{Pattern: "TestGRPC*", Source: "host-grpc-delete"}
+	_, err = s.DeleteMetrics(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	md := metadata.New(map[string]string{service.AuthorizationHeader: "Bearer secret"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	reset, err := s.ResetCounters(ctx, &proto.ResetCountersRequest{Pattern: "TestGRPCDelete", Source: "host-grpc-delete"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), reset.Affected)
+	value, err := storage.CounterMetric{Name: "TestGRPCDelete", Source: "host-grpc-delete"}.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "0", value)
+
+	deleted, err := s.DeleteMetrics(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.Affected)
+	value, err = storage.CounterMetric{Name: "TestGRPCDelete", Source: "host-grpc-delete"}.GetValue()
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+
+	_, err = s.DeleteMetrics(ctx, &proto.DeleteMetricsRequest{MType: "histogram", Pattern: "TestGRPC*"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetListMetricsGRPC(t *testing.T) {
+	s, err := newServer(config.ServerFlags{}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	s.metrics = handlers.MemoryStore{StoreInterval: 300}
+	assert.NoError(t, storage.GaugeMetric{Name: "TestGRPCListA", Value: "1.5", Source: "host-grpc-list"}.Add())
+	assert.NoError(t, storage.GaugeMetric{Name: "TestGRPCListB", Value: "2.5", Source: "host-grpc-list"}.Add())
+	assert.NoError(t, storage.CounterMetric{Name: "TestGRPCListC", Value: "3", Source: "host-grpc-list"}.Add())
+
+	got, err := s.GetMetric(context.Background(), &proto.GetMetricRequest{MType: "gauge", ID: "TestGRPCListB", Source: "host-grpc-list"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, got.Metric.GetValue())
+	assert.Equal(t, "host-grpc-list", got.Metric.Source)
+	_, err = s.GetMetric(context.Background(), &proto.GetMetricRequest{MType: "gauge", ID: "TestGRPCListMissing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.GetMetric(context.Background(), &proto.GetMetricRequest{MType: "histogram", ID: "TestGRPCListB"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	req := &proto.ListMetricsRequest{Prefix: "TestGRPCList", Source: "host-grpc-list", Sort: "name", Limit: 2}
+	page, err := s.ListMetrics(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, page.Metrics, 2)
+	assert.NotEmpty(t, page.NextCursor)
+	req.Cursor = page.NextCursor
+	page, err = s.ListMetrics(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, page.Metrics, 1)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = s.ListMetrics(context.Background(), &proto.ListMetricsRequest{Sort: "size"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerTelemetry(t *testing.T) {
+	s, err := newServer(config.ServerFlags{FlagTrustedSubnet: "192.168.1.0/24"}, agents.NewRegistry(0))
+	assert.NoError(t, err)
+	info := &grpc.UnaryServerInfo{FullMethod: proto.MetricServer_PushProtoMetrics_FullMethodName}
+	md := metadata.New(map[string]string{service.AgentIDHeader: "host-telemetry", "X-Real-IP": "10.0.0.1"})
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	push := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.lookupIPInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.PushProtoMetrics(ctx, req.(*proto.PushProtoMetricsRequest))
 		})
 	}
+	value := 1.5
+	req := &proto.PushProtoMetricsRequest{Metrics: []*proto.Metric{{ID: telemetry.Prefix + "TestTelemetryGauge", MType: "gauge", Value: &value}}}
+
+	calls := telemetry.GRPCRequests.Value(info.FullMethod, codes.PermissionDenied.String())
+	failures := telemetry.AuthFailures.Value("grpc", telemetry.ReasonSubnet)
+	_, err = s.metricsInterceptor(ctx, req, info, push)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, calls+1, telemetry.GRPCRequests.Value(info.FullMethod, codes.PermissionDenied.String()))
+	assert.Equal(t, failures+1, telemetry.AuthFailures.Value("grpc", telemetry.ReasonSubnet))
+
+	// метрики с префиксом метрик сервера от агентов не принимаются
+	s.ts.TrustedSubnet = "10.0.0.0/8"
+	_, err = s.metricsInterceptor(ctx, req, info, push)
+	assert.NoError(t, err)
+	stored, err := storage.GaugeMetric{Name: telemetry.Prefix + "TestTelemetryGauge", Source: "host-telemetry"}.GetValue()
+	assert.NoError(t, err)
+	assert.Empty(t, stored)
+
+	// метрики сервера записываются в хранилище как gauge с метками в источнике
+	store := handlers.ObserveStore(handlers.MemoryStore{StoreInterval: 300}, "memory")
+	writeTelemetry(store)(context.Background(), []telemetry.Sample{
+		{Name: "grpc_requests_total", Type: telemetry.TypeCounter, Labels: []telemetry.Label{{Name: "method", Value: "TestTelemetry"}, {Name: "code", Value: "OK"}}, Value: 3},
+	})
+	m, err := store.Value(context.Background(), "gauge", telemetry.Prefix+"grpc_requests_total", "method=TestTelemetry,code=OK")
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, *m.Value)
+	assert.NotZero(t, telemetry.StorageDuration.Count("memory", "updates", "ok"))
+	assert.NotZero(t, telemetry.StorageDuration.Count("memory", "value", "ok"))
 }
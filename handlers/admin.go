@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"musthave-metrics/internal/audit"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/internal/storage"
+	"musthave-metrics/internal/telemetry"
+)
+
+// AdminResult — ответ на удаление или сброс метрик.
+type AdminResult struct {
+	Affected int64 `json:"affected"` // число удалённых или сброшенных значений
+}
+
+// Admin выполняет удаление и сброс метрик для вызывающих с токеном администратора
+// и записывает операции в журнал аудита.
+type Admin struct {
+	Token string       // токен администратора, пустой запрещает операции
+	Trail *audit.Trail // журнал аудита, nil отключает запись
+}
+
+// Apply выполняет над метриками store, подходящими под фильтр f, операцию action
+// (audit.ActionDelete или audit.ActionReset), если authorization содержит токен администратора.
+// Каждая попытка, в том числе отклонённая, записывается в журнал аудита от имени caller.
+func (a *Admin) Apply(ctx context.Context, store Store, action string, f storage.Filter, authorization string, caller string, via string) (int64, error) {
+	err := a.authorize(authorization, via)
+	if err == nil {
+		err = f.Validate()
+	}
+	var affected int64
+	if err == nil {
+		if action == audit.ActionReset {
+			f.MType = "counter"
+			affected, err = store.Reset(ctx, f)
+		} else {
+			affected, err = store.Delete(ctx, f)
+		}
+	}
+	a.record(audit.Event{Action: action, MType: f.MType, Pattern: f.Pattern, Source: f.Source, Caller: caller, Via: via, Affected: affected}, err)
+	return affected, err
+}
+
+// Replace заменяет все метрики store пакетом metrics, если authorization содержит токен администратора.
+// Удаление метрик записывается в журнал аудита от имени caller.
+func (a *Admin) Replace(ctx context.Context, store Store, metrics []MetricsJSON, authorization string, caller string, via string) (int64, error) {
+	err := a.authorize(authorization, via)
+	var deleted int64
+	if err == nil {
+		deleted, err = store.Replace(ctx, metrics)
+	}
+	a.record(audit.Event{Action: audit.ActionDelete, Pattern: "*", Caller: caller, Via: via, Affected: deleted}, err)
+	return deleted, err
+}
+
+// authorize проверяет токен администратора в authorization.
+func (a *Admin) authorize(authorization string, via string) error {
+	token := ""
+	if a != nil {
+		token = a.Token
+	}
+	err := service.CheckAdminToken(token, authorization)
+	if errors.Is(err, service.ErrUnauthorized) {
+		telemetry.AuthFailures.Inc(via, telemetry.ReasonAdminToken)
+	}
+	return err
+}
+
+// record записывает операцию в журнал аудита.
+func (a *Admin) record(e audit.Event, err error) {
+	if a == nil || a.Trail == nil {
+		return
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	a.Trail.Record(e)
+}
+
+// adminStatus возвращает код ответа для ошибки операции администратора.
+func adminStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrAdminDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, storage.ErrBadFilter):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// apply выполняет операцию администратора по запросу HTTP.
+// Имя метрики берётся из пути, а при его отсутствии — шаблон из параметра pattern.
+func (a api) apply(w http.ResponseWriter, r *http.Request, action string) {
+	query := r.URL.Query()
+	f := storage.Filter{MType: query.Get("type"), Pattern: query.Get("pattern"), Source: query.Get("source")}
+	name := chi.URLParam(r, "metricName")
+	if name != "" {
+		if strings.ContainsAny(name, "*?") {
+			writeError(w, http.StatusBadRequest, "use the pattern parameter to match several metrics", nil)
+			return
+		}
+		f.MType, f.Pattern = chi.URLParam(r, "metricType"), name
+	}
+	if action == audit.ActionReset && f.MType == "gauge" {
+		writeError(w, http.StatusBadRequest, "only counters can be reset", nil)
+		return
+	}
+	affected, err := a.admin.Apply(r.Context(), a.store, action, f, r.Header.Get(service.AuthorizationHeader), r.RemoteAddr, "http")
+	if err != nil {
+		writeError(w, adminStatus(err), err.Error(), nil)
+		return
+	}
+	if name != "" && affected == 0 {
+		writeError(w, http.StatusNotFound, ErrNotFound.Error(), nil)
+		return
+	}
+	writeJSON(w, AdminResult{Affected: affected})
+}
+
+func (a api) delete(w http.ResponseWriter, r *http.Request) {
+	a.apply(w, r, audit.ActionDelete)
+}
+
+func (a api) reset(w http.ResponseWriter, r *http.Request) {
+	a.apply(w, r, audit.ActionReset)
+}
+
+// auditLog выводит журнал аудита.
+func (a api) auditLog(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if a.admin != nil {
+		token = a.admin.Token
+	}
+	if err := service.CheckAdminToken(token, r.Header.Get(service.AuthorizationHeader)); err != nil {
+		writeError(w, adminStatus(err), err.Error(), nil)
+		return
+	}
+	events := make([]audit.Event, 0)
+	if a.admin.Trail != nil {
+		events = a.admin.Trail.List()
+	}
+	writeJSON(w, events)
+}
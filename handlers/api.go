@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"musthave-metrics/internal/limits"
+	"musthave-metrics/internal/logger"
+)
+
+// openAPISpec — описание API в формате OpenAPI.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// APIError — описание ошибки в ответе API.
+type APIError struct {
+	Status  int          `json:"status"`           // код ответа HTTP
+	Code    string       `json:"code"`             // краткое имя ошибки, например not_found
+	Message string       `json:"message"`          // описание ошибки
+	Errors  []BatchError `json:"errors,omitempty"` // ошибки метрик пакета по индексам
+}
+
+// errorResponse — тело ответа API с ошибкой.
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeError выводит ошибку API в JSON.
+func writeError(w http.ResponseWriter, status int, message string, errs []BatchError) {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	resp, err := json.Marshal(errorResponse{Error: APIError{Status: status, Code: code, Message: message, Errors: errs}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err = w.Write(resp); err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
+// api обрабатывает запросы к /api/v1.
+type api struct {
+	store Store
+	mode  string
+	admin *Admin
+}
+
+// APIRouter возвращает обработчик /api/v1 для метрик из store.
+// mode — режим применения пакетов по умолчанию, admin разрешает удаление и сброс метрик
+// (nil запрещает их). Middleware writes применяются только к запросам, присылающим метрики.
+func APIRouter(store Store, mode string, admin *Admin, writes ...func(http.Handler) http.Handler) chi.Router {
+	a := api{store: store, mode: mode, admin: admin}
+	r := chi.NewRouter()
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "route not found", nil)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method "+r.Method+" is not allowed", nil)
+	})
+	r.Get("/openapi.json", a.openAPI)
+	r.Get("/metrics", a.list)
+	r.Get("/metrics/{metricType}/{metricName}", a.value)
+	r.Delete("/metrics", a.delete)
+	r.Delete("/metrics/{metricType}/{metricName}", a.delete)
+	r.Post("/metrics/reset", a.reset)
+	r.Post("/metrics/{metricType}/{metricName}/reset", a.reset)
+	r.Get("/audit", a.auditLog)
+	r.Get("/export", a.export)
+	r.Group(func(r chi.Router) {
+		r.Use(writes...)
+		r.Post("/metrics", a.updates)
+		r.Put("/metrics/{metricType}/{metricName}", a.update)
+		r.Post("/import", a.importMetrics)
+	})
+	return r
+}
+
+func (a api) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(openAPISpec); err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
+// list выводит страницу значений метрик с отбором по параметрам запроса.
+// Курсор следующей страницы передаётся в заголовках X-Next-Cursor и Link.
+func (a api) list(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseListQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	records, err := a.store.List(r.Context(), q.Source)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	page, next, err := q.Page(records)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if next != "" {
+		values := r.URL.Query()
+		values.Set("cursor", next)
+		w.Header().Set(NextCursorHeader, next)
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+values.Encode()+`>; rel="next"`)
+	}
+	writeBody(w, r, http.StatusOK, recordsJSON(page))
+}
+
+// value выводит значение метрики.
+func (a api) value(w http.ResponseWriter, r *http.Request) {
+	mtype, name := chi.URLParam(r, "metricType"), chi.URLParam(r, "metricName")
+	if mtype != "gauge" && mtype != "counter" {
+		writeError(w, http.StatusBadRequest, "unknown metric type "+mtype, nil)
+		return
+	}
+	m, err := a.store.Value(r.Context(), mtype, name, requestSource(r))
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	writeBody(w, r, http.StatusOK, m)
+}
+
+// update сохраняет метрику, тип и имя которой заданы в пути запроса.
+func (a api) update(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		writeError(w, limits.Status(err), err.Error(), nil)
+		return
+	}
+	var m MetricsJSON
+	if err := unmarshalMetric(buf.Bytes(), requestContentType(r), &m); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error(), nil)
+		return
+	}
+	mtype, name := chi.URLParam(r, "metricType"), chi.URLParam(r, "metricName")
+	if (m.ID != "" && m.ID != name) || (m.MType != "" && m.MType != mtype) {
+		writeError(w, http.StatusBadRequest, "metric id and type must match the path", nil)
+		return
+	}
+	m.ID, m.MType = name, mtype
+	if m.Source == "" {
+		m.Source = requestSource(r)
+	}
+	if err := ValidateReport(m); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	stored, err := a.store.Update(r.Context(), m)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	writeBody(w, r, http.StatusOK, stored)
+}
+
+// updates сохраняет пакет метрик.
+func (a api) updates(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		writeError(w, limits.Status(err), err.Error(), nil)
+		return
+	}
+	status, result, err := updateBatch(r.Context(), a.store, buf.Bytes(), requestContentType(r), requestSource(r), batchMode(r, a.mode))
+	if err != nil {
+		writeError(w, status, "invalid body: "+err.Error(), nil)
+		return
+	}
+	if status >= http.StatusBadRequest {
+		result.sortErrors()
+		writeError(w, status, "batch rejected", result.Errors)
+		return
+	}
+	writeBatchResult(w, r, status, result)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"musthave-metrics/internal/limits"
+	"musthave-metrics/internal/postgres"
+	"musthave-metrics/internal/telemetry"
+)
+
+// режимы применения пакета метрик
+const (
+	// BatchAtomic — пакет применяется, только если все метрики корректны.
+	BatchAtomic = "atomic"
+	// BatchPartial — применяются корректные метрики, ошибочные перечисляются в ответе.
+	BatchPartial = "partial"
+)
+
+// MaxNameLength — наибольшая длина имени метрики и идентификатора источника.
+const MaxNameLength = 255
+
+var metricNameRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// BatchError описывает ошибку метрики пакета.
+type BatchError struct {
+	Index int    `json:"index"`        // индекс метрики в пакете
+	ID    string `json:"id,omitempty"` // имя метрики
+	Error string `json:"error"`        // описание ошибки
+}
+
+// BatchResult — ответ на пакетное обновление метрик.
+type BatchResult struct {
+	Accepted int          `json:"accepted"`         // число применённых метрик
+	Rejected int          `json:"rejected"`         // число отклонённых метрик
+	Errors   []BatchError `json:"errors,omitempty"` // ошибки по индексам метрик
+}
+
+func (res *BatchResult) reject(index int, id string, err error) {
+	res.Rejected++
+	res.Errors = append(res.Errors, BatchError{Index: index, ID: id, Error: err.Error()})
+}
+
+// rejectAll отклоняет корректные метрики вместе с ошибочными в режиме BatchAtomic.
+func (res *BatchResult) rejectAll(items []batchItem) {
+	res.Rejected += len(items)
+	res.Accepted = 0
+}
+
+// sortErrors упорядочивает ошибки по индексам метрик.
+func (res *BatchResult) sortErrors() {
+	sort.SliceStable(res.Errors, func(i, j int) bool {
+		return res.Errors[i].Index < res.Errors[j].Index
+	})
+}
+
+// batchItem — корректная метрика пакета и её индекс.
+type batchItem struct {
+	index  int
+	metric MetricsJSON
+}
+
+// ValidateMetric проверяет имя, тип и значение метрики.
+func ValidateMetric(m MetricsJSON) error {
+	switch {
+	case m.ID == "":
+		return errors.New("metric name is required")
+	case len(m.ID) > MaxNameLength:
+		return fmt.Errorf("metric name is longer than %d characters", MaxNameLength)
+	case !metricNameRe.MatchString(m.ID):
+		return errors.New("metric name may contain only letters, digits and _.:-")
+	case len(m.Source) > MaxNameLength:
+		return fmt.Errorf("source is longer than %d characters", MaxNameLength)
+	}
+	switch m.MType {
+	case "gauge":
+		if m.Value == nil {
+			return errors.New("gauge value is required")
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return errors.New("gauge value must be a finite number")
+		}
+	case "counter":
+		if m.Delta == nil {
+			return errors.New("counter delta is required")
+		}
+	default:
+		return fmt.Errorf("unknown metric type %q", m.MType)
+	}
+	if m.Timestamp != nil && *m.Timestamp <= 0 {
+		return errors.New("timestamp must be positive")
+	}
+	return nil
+}
+
+// ErrReservedName возвращается для метрик агентов с префиксом имён метрик самого сервера.
+var ErrReservedName = errors.New("metric name prefix " + telemetry.Prefix + " is reserved for server metrics")
+
+// ValidateReport проверяет метрику, полученную от агента: как ValidateMetric
+// и кроме того, что имя метрики не начинается с префикса метрик сервера telemetry.Prefix.
+func ValidateReport(m MetricsJSON) error {
+	if strings.HasPrefix(m.ID, telemetry.Prefix) {
+		return ErrReservedName
+	}
+	return ValidateMetric(m)
+}
+
+// decodeBatch разбирает пакет метрик в формате ctype и проверяет каждую метрику отдельно.
+// Метрикам без источника назначается source. Возвращает корректные метрики
+// и результат с ошибками остальных или ошибку, если тело не является пакетом метрик
+// или метрик в пакете больше допустимого.
+func decodeBatch(data []byte, ctype string, source string) ([]batchItem, BatchResult, error) {
+	var (
+		items  []batchItem
+		result BatchResult
+	)
+	metrics, errs, err := unmarshalMetrics(data, ctype)
+	if err != nil {
+		return nil, result, err
+	}
+	if err := limits.CheckBatch(len(metrics)); err != nil {
+		return nil, result, err
+	}
+	for i, m := range metrics {
+		if errs[i] != nil {
+			result.reject(i, "", errs[i])
+			continue
+		}
+		if err := ValidateReport(m); err != nil {
+			result.reject(i, m.ID, err)
+			continue
+		}
+		if m.Source == "" {
+			m.Source = source
+		}
+		items = append(items, batchItem{index: i, metric: m})
+	}
+	return items, result, nil
+}
+
+// batchMode возвращает режим применения пакета из параметра запроса mode или режим по умолчанию.
+func batchMode(r *http.Request, defaultMode string) string {
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case BatchAtomic, BatchPartial:
+		return mode
+	}
+	if defaultMode == BatchPartial {
+		return BatchPartial
+	}
+	return BatchAtomic
+}
+
+func writeBatchResult(w http.ResponseWriter, r *http.Request, status int, result BatchResult) {
+	result.sortErrors()
+	writeBody(w, r, status, result)
+}
+
+// updateBatch проверяет и сохраняет пакет метрик в формате ctype в режиме mode.
+// Возвращает код ответа и результат с ошибками по индексам
+// или ошибку, если тело не является пакетом метрик (код 400) или пакет слишком велик (код 413).
+func updateBatch(ctx context.Context, store Store, data []byte, ctype string, source string, mode string) (int, BatchResult, error) {
+	items, result, err := decodeBatch(data, ctype, source)
+	if err != nil {
+		return limits.Status(err), result, err
+	}
+	atomic := mode == BatchAtomic
+	if result.Rejected > 0 && atomic {
+		result.rejectAll(items)
+		return http.StatusBadRequest, result, nil
+	}
+	metrics := make([]MetricsJSON, 0, len(items))
+	for _, item := range items {
+		metrics = append(metrics, item.metric)
+	}
+	errs, err := store.Updates(ctx, metrics, atomic)
+	if err != nil {
+		result.rejectAll(items)
+		var itemErr postgres.ItemError
+		if errors.As(err, &itemErr) {
+			item := items[itemErr.Index]
+			result.Errors = append(result.Errors, BatchError{Index: item.index, ID: item.metric.ID, Error: itemErr.Err.Error()})
+		}
+		return http.StatusInternalServerError, result, nil
+	}
+	for i, err := range errs {
+		if err != nil {
+			result.reject(items[i].index, items[i].metric.ID, err)
+			continue
+		}
+		result.Accepted++
+	}
+	if result.Accepted == 0 && result.Rejected > 0 {
+		return http.StatusBadRequest, result, nil
+	}
+	return http.StatusOK, result, nil
+}
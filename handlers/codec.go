@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/proto"
+)
+
+// типы тел запросов и ответов с метриками
+const (
+	ContentTypeJSON = "application/json"
+	// ContentTypeProtobuf — метрика передаётся сообщением proto.Metric,
+	// пакет и список метрик — сообщением proto.PushProtoMetricsRequest.
+	ContentTypeProtobuf = "application/x-protobuf"
+	// ContentTypeMsgpack — MessagePack с теми же именами полей, что и в JSON.
+	ContentTypeMsgpack = "application/msgpack"
+)
+
+// contentTypes сопоставляет распространённые имена типов поддерживаемым.
+var contentTypes = map[string]string{
+	ContentTypeJSON:                   ContentTypeJSON,
+	ContentTypeProtobuf:               ContentTypeProtobuf,
+	"application/protobuf":            ContentTypeProtobuf,
+	"application/vnd.google.protobuf": ContentTypeProtobuf,
+	ContentTypeMsgpack:                ContentTypeMsgpack,
+	"application/x-msgpack":           ContentTypeMsgpack,
+	"application/vnd.msgpack":         ContentTypeMsgpack,
+}
+
+// requestContentType возвращает тип тела запроса по заголовку Content-Type.
+// Тело без заголовка или с другим типом разбирается как JSON, как и прежде.
+func requestContentType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ctype, ok := contentTypes[mediaType]; ok && err == nil {
+		return ctype
+	}
+	return ContentTypeJSON
+}
+
+// responseContentType выбирает тип тела ответа по заголовку Accept с учётом q-значений.
+// Без заголовка Accept или с */* ответ передаётся в формате запроса.
+func responseContentType(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, item := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ctype, ok := contentTypes[mediaType]
+		if mediaType == "*/*" || mediaType == "application/*" {
+			ctype, ok = requestContentType(r), true
+		}
+		if ok && q > bestQ {
+			best, bestQ = ctype, q
+		}
+	}
+	if best == "" {
+		return requestContentType(r)
+	}
+	return best
+}
+
+// unmarshalMetric разбирает метрику в формате ctype.
+func unmarshalMetric(data []byte, ctype string, m *MetricsJSON) error {
+	switch ctype {
+	case ContentTypeProtobuf:
+		var pm proto.Metric
+		if err := protobuf.Unmarshal(data, &pm); err != nil {
+			return err
+		}
+		*m = MetricFromProto(&pm)
+		return nil
+	case ContentTypeMsgpack:
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		return dec.Decode(m)
+	}
+	return json.Unmarshal(data, m)
+}
+
+// unmarshalMetrics разбирает пакет метрик в формате ctype.
+// Ошибки отдельных метрик возвращаются в errs по индексам, err — ошибка разбора пакета целиком.
+func unmarshalMetrics(data []byte, ctype string) (metrics []MetricsJSON, errs []error, err error) {
+	switch ctype {
+	case ContentTypeProtobuf:
+		var req proto.PushProtoMetricsRequest
+		if err := protobuf.Unmarshal(data, &req); err != nil {
+			return nil, nil, err
+		}
+		for _, pm := range req.Metrics {
+			metrics = append(metrics, MetricFromProto(pm))
+		}
+		return metrics, make([]error, len(metrics)), nil
+	case ContentTypeMsgpack:
+		var raw []msgpack.RawMessage
+		if err := msgpack.Unmarshal(data, &raw); err != nil {
+			return nil, nil, err
+		}
+		metrics, errs = make([]MetricsJSON, len(raw)), make([]error, len(raw))
+		for i, r := range raw {
+			errs[i] = unmarshalMetric(r, ctype, &metrics[i])
+		}
+		return metrics, errs, nil
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, err
+	}
+	metrics, errs = make([]MetricsJSON, len(raw)), make([]error, len(raw))
+	for i, r := range raw {
+		errs[i] = json.Unmarshal(r, &metrics[i])
+	}
+	return metrics, errs, nil
+}
+
+// marshalBody кодирует метрику, список метрик или результат пакета в формате ctype.
+func marshalBody(v any, ctype string) ([]byte, error) {
+	switch ctype {
+	case ContentTypeProtobuf:
+		var msg protobuf.Message
+		switch v := v.(type) {
+		case MetricsJSON:
+			msg = ProtoMetric(v)
+		case []MetricsJSON:
+			req := &proto.PushProtoMetricsRequest{Metrics: make([]*proto.Metric, 0, len(v))}
+			for _, m := range v {
+				req.Metrics = append(req.Metrics, ProtoMetric(m))
+			}
+			msg = req
+		case BatchResult:
+			res := &proto.BatchResult{Accepted: int64(v.Accepted), Rejected: int64(v.Rejected)}
+			for _, e := range v.Errors {
+				res.Errors = append(res.Errors, &proto.BatchError{Index: int64(e.Index), ID: e.ID, Error: e.Error})
+			}
+			msg = res
+		default:
+			return nil, fmt.Errorf("%T cannot be encoded as %s", v, ctype)
+		}
+		return protobuf.Marshal(msg)
+	case ContentTypeMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	}
+	return json.Marshal(v)
+}
+
+// writeBody выводит v в формате, выбранном по заголовку Accept.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, v any) {
+	ctype := responseContentType(r)
+	resp, err := marshalBody(v, ctype)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.WriteHeader(status)
+	if _, err = w.Write(resp); err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
+// ProtoMetric переводит метрику из JSON в сообщение protobuf.
+func ProtoMetric(m MetricsJSON) *proto.Metric {
+	return &proto.Metric{ID: m.ID, MType: m.MType, Delta: m.Delta, Value: m.Value, Timestamp: m.Timestamp, Source: m.Source}
+}
+
+// MetricFromProto переводит метрику из сообщения protobuf в JSON.
+func MetricFromProto(m *proto.Metric) MetricsJSON {
+	return MetricsJSON{ID: m.ID, MType: m.MType, Delta: m.Delta, Value: m.Value, Source: m.Source, Timestamp: m.Timestamp}
+}
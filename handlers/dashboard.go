@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/storage"
+)
+
+// DashboardRefresh — период отправки изменившихся метрик на панель метрик.
+const DashboardRefresh = 2 * time.Second
+
+// web содержит шаблон панели метрик, стили и скрипты. Панель не загружает внешних ресурсов.
+//
+//go:embed web
+var web embed.FS
+
+var dashboardPage = template.Must(template.ParseFS(web, "web/index.html"))
+
+// dashboardContent — данные панели метрик.
+type dashboardContent struct {
+	Source string   // агент, метрики которого выводятся, пустой — все агенты
+	Agents []string // агенты, приславшие метрики
+	Search string   // строка поиска
+	MType  string   // отбор по типу
+	Sort   string   // порядок сортировки
+	Groups []metricsGroup
+	Next   string // ссылка на следующую страницу
+	Events string // адрес потока изменений
+}
+
+// metricsGroup — метрики одного агента.
+type metricsGroup struct {
+	Source string
+	Rows   []metricsRow
+}
+
+type metricsRow struct {
+	MType   string
+	Name    string
+	Source  string
+	Value   string
+	Updated string
+}
+
+// metricEvent — изменение метрики в потоке событий панели.
+type metricEvent struct {
+	MType   string    `json:"type"`
+	Name    string    `json:"id"`
+	Source  string    `json:"source,omitempty"`
+	Value   string    `json:"value"`
+	Updated time.Time `json:"updated"`
+}
+
+// AllMetricsHandler выводит панель метрик из store, сгруппированных по агентам, в устойчивом порядке.
+// Параметр q ищет метрики по части имени, шаблону с * и ? или регулярному выражению в /.../,
+// source выводит метрики одного агента; остальные параметры совпадают с параметрами списка /api/v1/metrics.
+func AllMetricsHandler(store Store) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		search := values.Get("q")
+		if _, ok := regexpMatch(search); !ok && search != "" && !strings.ContainsAny(search, "*?") {
+			search = "*" + search + "*"
+		}
+		values.Set("match", search)
+		q, err := ParseListQuery(values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		records, err := store.List(r.Context(), "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page, next, err := q.Page(records)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content := dashboardContent{
+			Source: q.Source,
+			Agents: recordSources(records),
+			Search: values.Get("q"),
+			MType:  q.MType,
+			Sort:   q.sortKey(),
+			Groups: groupBySource(page),
+			Events: "/events",
+		}
+		if q.Source != "" {
+			content.Events += "?" + url.Values{"source": {q.Source}}.Encode()
+		}
+		if next != "" {
+			values.Del("match")
+			values.Set("cursor", next)
+			content.Next = "?" + values.Encode()
+		}
+		var buf bytes.Buffer
+		if err := dashboardPage.Execute(&buf, content); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			logger.Warnf("Write response error: " + err.Error())
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// StaticHandler отдаёт стили и скрипты панели метрик по адресам /static/.
+func StaticHandler() http.Handler {
+	static, err := fs.Sub(web, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
+
+// EventsHandler передаёт панели метрик значения метрик из store, изменившиеся с прошлой отправки,
+// в формате Server-Sent Events с периодом interval. Параметр source ограничивает поток одним агентом.
+// Поток завершается при отключении клиента или отмене ctx, например при остановке сервера.
+func EventsHandler(ctx context.Context, store Store, interval time.Duration) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			logger.Warnf("Dashboard events error: " + err.Error())
+			return
+		}
+		since := time.Now()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+			records, err := store.List(r.Context(), source)
+			if err != nil {
+				logger.Warnf("Dashboard events error: " + err.Error())
+				continue
+			}
+			var changed []metricEvent
+			last := since
+			for _, rec := range records {
+				if !rec.Updated.After(since) {
+					continue
+				}
+				changed = append(changed, metricEvent{MType: rec.MType, Name: rec.Name, Source: rec.Source, Value: recordValue(rec), Updated: rec.Updated})
+				if rec.Updated.After(last) {
+					last = rec.Updated
+				}
+			}
+			since = last
+			if len(changed) == 0 {
+				continue
+			}
+			data, err := json.Marshal(changed)
+			if err != nil {
+				logger.Warnf("Dashboard events error: " + err.Error())
+				continue
+			}
+			if _, err := w.Write([]byte("event: metrics\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// groupBySource группирует метрики по агентам, сохраняя порядок метрик внутри группы.
+func groupBySource(records []storage.Record) []metricsGroup {
+	var groups []metricsGroup
+	index := make(map[string]int)
+	for _, r := range records {
+		i, ok := index[r.Source]
+		if !ok {
+			i = len(groups)
+			index[r.Source] = i
+			groups = append(groups, metricsGroup{Source: r.Source})
+		}
+		row := metricsRow{MType: r.MType, Name: r.Name, Source: r.Source, Value: recordValue(r)}
+		if !r.Updated.IsZero() {
+			row.Updated = r.Updated.Format(time.RFC3339)
+		}
+		groups[i].Rows = append(groups[i].Rows, row)
+	}
+	return groups
+}
+
+// recordSources возвращает агентов, приславших метрики, в порядке имён.
+func recordSources(records []storage.Record) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, r := range records {
+		if r.Source != "" && !seen[r.Source] {
+			seen[r.Source] = true
+			sources = append(sources, r.Source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// recordValue возвращает значение метрики в виде строки.
+func recordValue(r storage.Record) string {
+	if r.MType == "counter" {
+		return strconv.FormatInt(r.Delta, 10)
+	}
+	return strconv.FormatFloat(r.Value, 'g', -1, 64)
+}
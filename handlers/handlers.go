@@ -3,24 +3,28 @@ package handlers
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
-	"text/template"
 	"time"
 
 	"musthave-metrics/cmd/agent/client"
+	"musthave-metrics/internal/agents"
+	"musthave-metrics/internal/alerts"
+	"musthave-metrics/internal/compress"
 	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/limits"
 	"musthave-metrics/internal/logger"
 	"musthave-metrics/internal/postgres"
 	"musthave-metrics/internal/service"
 	"musthave-metrics/internal/storage"
+	"musthave-metrics/internal/telemetry"
 
 	"github.com/avast/retry-go/v4"
 	"github.com/go-chi/chi/v5"
@@ -32,460 +36,240 @@ type Metric struct {
 	metricType  string
 	metricName  string
 	metricValue string
+	source      string
 }
 
 // MetricsJSON хранит информацию о JSON-описании метрик.
 type MetricsJSON struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string   `json:"id"`               // имя метрики
+	MType  string   `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Source string   `json:"source,omitempty"` // идентификатор агента, приславшего метрику
+	// Timestamp — время значения gauge по часам агента, unix-время в миллисекундах
+	Timestamp *int64 `json:"timestamp,omitempty"`
 }
 
-type metricsContent struct {
-	Rowsg string
-	Rowsc string
+// MetricHistoryJSON хранит историю значений метрики.
+type MetricHistoryJSON struct {
+	ID      string           `json:"id"`      // имя метрики
+	MType   string           `json:"type"`    // параметр, принимающий значение gauge или counter
+	Samples []storage.Sample `json:"samples"` // значения в порядке времени, для counter — накопленные
 }
 
-// UpdateHandler обновляет метрики.
-func UpdateHandler() http.Handler {
+// PingDBHandler проверяет работоспособность.
+func PingDBHandler(DatabaseDSN string) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		m := Metric{}
-		w.Header().Set("Content-Type", "text/plain")
-		m.setValue(r)
-		if !m.isValid() || m.add() != nil {
-			w.WriteHeader(http.StatusBadRequest)
-		} else {
-			w.WriteHeader(http.StatusOK)
+		settings := postgres.NewPSQLStr(DatabaseDSN)
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		err := settings.Ping(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
 	}
 	return http.HandlerFunc(fn)
 }
 
-// UpdateJSONHandler обновляет метрики в JSON.
-func UpdateJSONHandler(storeInterval int, fileStoragePath string) http.Handler {
+// ListHandler выводит значения метрик в разрезе источников в JSON.
+// Параметр запроса source ограничивает вывод одним агентом.
+func ListHandler() http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		var buf bytes.Buffer
-		// читаем тело запроса
-		n, err := buf.ReadFrom(r.Body)
-		if err != nil {
-			return
-		}
-		if r.Method == http.MethodPost && n != 0 {
-			var metric MetricsJSON
-			err = retry.Do(func() error {
-				// десериализуем JSON в Visitor
-				if errjson := json.Unmarshal(buf.Bytes(), &metric); err != nil {
-					return errjson
-				}
-				return nil
-			},
-				retry.Attempts(3),
-				retry.Delay(1000*time.Millisecond),
-			)
-			if err != nil {
-				logger.Warnf("JSON error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			if metric.MType == "gauge" {
-				err = repo(metric.ID, metric.MType, strconv.FormatFloat(*metric.Value, 'g', -1, 64)).Add()
-			} else if metric.MType == "counter" {
-				err = repo(metric.ID, metric.MType, strconv.FormatInt(*metric.Delta, 10)).Add()
-			} else {
-				http.Error(w, "unknown metric type", http.StatusInternalServerError)
-				return
-			}
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			resp, err := json.Marshal(metric)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_, err = w.Write(resp)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			if storeInterval == 0 {
-				storeMetric(metric, fileStoragePath)
-			}
-		}
+		listMetrics(w, r, MemoryStore{})
 	}
 	return http.HandlerFunc(fn)
 }
 
-// GetValueHandler получает значение метрики.
-func GetValueHandler() http.Handler {
+// ListDBHandler выводит значения метрик из СУБД в разрезе источников в JSON.
+func ListDBHandler(DatabaseDSN string) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		m := Metric{}
-		m.setValue(r)
-		val, err := m.getValue()
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		db, err := pgxpool.New(ctx, DatabaseDSN)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		_, err = w.Write([]byte(val))
-		if err != nil {
-			log.Fatal(err)
-		}
+		defer db.Close()
+		listMetrics(w, r.WithContext(ctx), &DBStore{settings: postgres.NewPSQLStr(DatabaseDSN), db: db})
 	}
 	return http.HandlerFunc(fn)
 }
 
-// GetValueJSONHandler получает значение метрики в JSON.
-func GetValueJSONHandler() http.Handler {
+func listMetrics(w http.ResponseWriter, r *http.Request, store Store) {
+	records, err := store.List(r.Context(), r.URL.Query().Get("source"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page, _, err := ListQuery{Sort: SortName, Limit: len(records)}.Page(records)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, recordsJSON(page))
+}
+
+// AgentsHandler выводит сведения об агентах, присылавших метрики, в JSON.
+func AgentsHandler(registry *agents.Registry) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		var buf bytes.Buffer
-		// читаем тело запроса
-		n, err := buf.ReadFrom(r.Body)
-		if err != nil {
-			return
-		}
-		if r.Method == http.MethodPost && n != 0 {
-			var metric MetricsJSON
-			err = retry.Do(func() error {
-				// десериализуем JSON в Visitor
-				if errjson := json.Unmarshal(buf.Bytes(), &metric); err != nil {
-					return errjson
-				}
-				return nil
-			},
-				retry.Attempts(3),
-				retry.Delay(1000*time.Millisecond),
-			)
-			if err != nil {
-				logger.Warnf("JSON error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			val, err := repo(metric.ID, metric.MType, "").GetValue()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if val == "" {
-				val = "0"
-			}
-			if metric.MType == "gauge" {
-				gaugeValue, errprs := strconv.ParseFloat(val, 64)
-				if errprs != nil {
-					http.Error(w, errprs.Error(), http.StatusInternalServerError)
-					return
-				}
-				metric.Value = &gaugeValue
-			} else if metric.MType == "counter" {
-				counterValue, errprs := strconv.ParseInt(val, 10, 64)
-				if errprs != nil {
-					http.Error(w, errprs.Error(), http.StatusInternalServerError)
-					return
-				}
-				metric.Delta = &counterValue
-			} else {
-				http.Error(w, "unknown metric type", http.StatusInternalServerError)
-				return
-			}
-			resp, err := json.Marshal(metric)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_, err = w.Write(resp)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-		}
+		writeJSON(w, registry.List())
 	}
 	return http.HandlerFunc(fn)
 }
 
-// AllMetricsHandler выводит все метрики.
-func AllMetricsHandler() http.Handler {
+// AlertsHandler выводит состояние оповещений в JSON.
+func AlertsHandler(engine *alerts.Engine) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		content := metricsContent{
-			Rowsg: repo("", "gauge", "").AllValuesHTML(),
-			Rowsc: repo("", "counter", "").AllValuesHTML(),
-		}
-		body, err := template.New("temp").Parse(metricstemplate())
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-		} else {
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusOK)
-			err = body.Execute(w, content)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-			}
+		if engine == nil {
+			writeJSON(w, []alerts.Alert{})
+			return
 		}
+		writeJSON(w, engine.List())
 	}
 	return http.HandlerFunc(fn)
 }
 
-// PingDBHandler проверяет работоспособность.
-func PingDBHandler(DatabaseDSN string) http.Handler {
+// AgentConfigHandler выводит параметры агента, заданные на сервере, в JSON.
+// Группа агента передаётся в заголовке X-Agent-Group или в параметре запроса group.
+func AgentConfigHandler(store *agents.ConfigStore) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		settings := postgres.NewPSQLStr(DatabaseDSN)
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		err := settings.Ping(ctx)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		group := r.Header.Get(service.AgentGroupHeader)
+		if group == "" {
+			group = r.URL.Query().Get("group")
 		}
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
+		writeJSON(w, store.Resolve(chi.URLParam(r, "agentID"), group))
 	}
 	return http.HandlerFunc(fn)
 }
 
-// UpdateDBHandler обновляет метрики в СУБД.
-func UpdateDBHandler(ctx context.Context, DatabaseDSN string, HashKey string) http.Handler {
+// AgentConfigListHandler выводит параметры агентов, заданные на сервере, в JSON.
+func AgentConfigListHandler(store *agents.ConfigStore) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		settings := postgres.NewPSQLStr(DatabaseDSN)
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		err := settings.Ping(ctx)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		var buf bytes.Buffer
-		// читаем тело запроса
-		n, err := buf.ReadFrom(r.Body)
-		if err != nil {
-			return
-		}
-		if r.Method == http.MethodPost && n != 0 {
-			var metric MetricsJSON
-			err = retry.Do(func() error {
-				// десериализуем JSON в Visitor
-				if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
-					return err
-				}
-				return nil
-			},
-				retry.Attempts(3),
-				retry.Delay(1000*time.Millisecond),
-				retry.Context(ctx),
-			)
-			if err != nil {
-				logger.Warnf("JSON error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			db, err := pgxpool.New(ctx, DatabaseDSN)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			defer db.Close()
-			err = settings.UpdateNew(ctx, db, metric.MType, metric.ID, metric.Delta, metric.Value)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			resp, err := json.Marshal(metric)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_, err = w.Write(resp)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if HashKey != "" {
-				w.Header().Set("HashSHA256", service.GetHashString(resp, HashKey))
-			}
-			w.WriteHeader(http.StatusOK)
-		}
+		writeJSON(w, store.Get())
 	}
 	return http.HandlerFunc(fn)
 }
 
-// UpdateBatchDBHandler обновляет метрики в СУБД.
-func UpdateBatchDBHandler(DatabaseDSN string) http.Handler {
+// AgentConfigSetHandler заменяет параметры агентов, заданные на сервере,
+// если запрос содержит токен администратора.
+func AgentConfigSetHandler(store *agents.ConfigStore, admin *Admin) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		if DatabaseDSN == "" {
-			logger.Warnf("DatabaseDSN: empty string")
-			return
+		token := ""
+		if admin != nil {
+			token = admin.Token
 		}
-		settings := postgres.NewPSQLStr(DatabaseDSN)
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		err := settings.Ping(ctx)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		err := service.CheckAdminToken(token, r.Header.Get(service.AuthorizationHeader))
+		if errors.Is(err, service.ErrUnauthorized) {
+			telemetry.AuthFailures.Inc("http", telemetry.ReasonAdminToken)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		var buf bytes.Buffer
-		// читаем тело запроса
-		n, err := buf.ReadFrom(r.Body)
 		if err != nil {
+			logger.Warnf("Agent config error: " + err.Error())
+			http.Error(w, err.Error(), adminStatus(err))
 			return
 		}
-		if r.Method == http.MethodPost && n != 0 {
-			var metrics []postgres.Metrics
-			err = retry.Do(func() error {
-				// десериализуем JSON в Visitor
-				if err = json.Unmarshal(buf.Bytes(), &metrics); err != nil {
-					return err
-				}
-				return nil
-			},
-				retry.Attempts(3),
-				retry.Delay(1000*time.Millisecond),
-				retry.Context(ctx),
-			)
-			if err != nil {
-				logger.Warnf("JSON error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			db, err := pgxpool.New(ctx, DatabaseDSN)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			defer db.Close()
-			err = settings.Updates(ctx, db, metrics)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
+		var set agents.ConfigSet
+		if err := json.NewDecoder(r.Body).Decode(&set); err != nil {
+			logger.Warnf("JSON error: " + err.Error())
+			http.Error(w, err.Error(), limits.Status(err))
+			return
 		}
+		store.Set(set)
+		writeJSON(w, store.Get())
 	}
 	return http.HandlerFunc(fn)
 }
 
-// GetValueDBHandler получает метрики из СУБД.
-func GetValueDBHandler(ctx context.Context, DatabaseDSN string, HashKey string) http.Handler {
+// HistoryHandler выводит историю значений метрики в JSON.
+// Параметр запроса source ограничивает историю одним агентом.
+func HistoryHandler() http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		settings := postgres.NewPSQLStr(DatabaseDSN)
-		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
-		defer cancel()
-		err := settings.Ping(ctx)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		var buf bytes.Buffer
-		// читаем тело запроса
-		n, err := buf.ReadFrom(r.Body)
-		if err != nil {
+		m := Metric{}
+		m.setValue(r)
+		samples := storage.History(m.metricType, m.metricName, r.URL.Query().Get("source"))
+		if len(samples) == 0 {
+			w.WriteHeader(http.StatusNotFound)
 			return
 		}
-		if r.Method == http.MethodPost && n != 0 {
-			var metric MetricsJSON
-			err = retry.Do(func() error {
-				// десериализуем JSON в Visitor
-				if err = json.Unmarshal(buf.Bytes(), &metric); err != nil {
-					return err
-				}
-				return nil
-			},
-				retry.Attempts(3),
-				retry.Delay(1000*time.Millisecond),
-				retry.Context(ctx),
-			)
-			if err != nil {
-				logger.Warnf("JSON error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
-			val, err := settings.GetValue(ctx, DatabaseDSN, metric.MType, metric.ID)
-			if err != nil {
-				w.WriteHeader(http.StatusNotFound)
-				return
-			}
-			if metric.MType == "gauge" {
-				gaugeValue, errprs := strconv.ParseFloat(val, 64)
-				if errprs != nil {
-					http.Error(w, errprs.Error(), http.StatusInternalServerError)
-					return
-				}
-				metric.Value = &gaugeValue
-			} else if metric.MType == "counter" {
-				counterValue, errprs := strconv.ParseInt(val, 10, 64)
-				if errprs != nil {
-					http.Error(w, errprs.Error(), http.StatusInternalServerError)
-					return
-				}
-				metric.Delta = &counterValue
-			} else {
-				http.Error(w, "unknown metric type", http.StatusInternalServerError)
-				return
-			}
-			resp, err := json.Marshal(metric)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			_, err = w.Write(resp)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if HashKey != "" {
-				w.Header().Set("HashSHA256", service.GetHashString(resp, HashKey))
-			}
-			w.WriteHeader(http.StatusOK)
-		}
+		writeJSON(w, MetricHistoryJSON{ID: m.metricName, MType: m.metricType, Samples: samples})
 	}
 	return http.HandlerFunc(fn)
 }
 
+func writeJSON(w http.ResponseWriter, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(resp)
+	if err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
 func (m *Metric) setValue(r *http.Request) {
 	m.metricType = chi.URLParam(r, "metricType")
 	m.metricName = chi.URLParam(r, "metricName")
 	m.metricValue = chi.URLParam(r, "metricValue")
+	m.source = requestSource(r)
 }
 
-func (m Metric) isValid() bool {
-	if m.metricType == "" || m.metricName == "" || m.metricValue == "" {
-		return false
+// requestSource возвращает идентификатор агента из заголовка X-Agent-ID,
+// а при его отсутствии — из параметра запроса source.
+func requestSource(r *http.Request) string {
+	if source := r.Header.Get(service.AgentIDHeader); source != "" {
+		return source
 	}
-	if !(m.metricType == "counter" || m.metricType == "gauge") {
-		return false
-	}
-	return true
+	return r.URL.Query().Get("source")
 }
 
-func (m Metric) add() error {
-	err := repo(m.metricName, m.metricType, m.metricValue).Add()
-	return err
-}
-
-func (m Metric) getValue() (string, error) {
-	value, err := repo(m.metricName, m.metricType, m.metricValue).GetValue()
-	if value == "" && err == nil {
-		err = fmt.Errorf("unknown metric")
+// addSample добавляет сохранённое в СУБД значение метрики в историю.
+func addSample(mtype string, mname string, source string, val string) {
+	value, err := strconv.ParseFloat(val, 64)
+	if err == nil {
+		storage.AddSample(mtype, mname, source, value)
 	}
-	return value, err
 }
 
-func repo(metricName string, metricType string, metricValue string) (repository storage.Repository) {
+// sourceRepo возвращает хранилище метрики, полученной от источника source.
+func sourceRepo(source string, metricName string, metricType string, metricValue string) (repository storage.Repository) {
 	if metricType == "gauge" {
-		repository = storage.GaugeMetric{Name: metricName, Value: metricValue}
+		repository = storage.GaugeMetric{Name: metricName, Value: metricValue, Source: source}
 	} else if metricType == "counter" {
-		repository = storage.CounterMetric{Name: metricName, Value: metricValue}
+		repository = storage.CounterMetric{Name: metricName, Value: metricValue, Source: source}
 	}
 	return repository
 }
 
+// metricRepo возвращает хранилище метрики, полученной в JSON.
+func metricRepo(metric MetricsJSON) storage.Repository {
+	if metric.MType == "gauge" {
+		return storage.GaugeMetric{
+			Name:      metric.ID,
+			Value:     strconv.FormatFloat(*metric.Value, 'g', -1, 64),
+			Source:    metric.Source,
+			Timestamp: storage.UnixMilli(metric.Timestamp),
+		}
+	}
+	return sourceRepo(metric.Source, metric.ID, metric.MType, strconv.FormatInt(*metric.Delta, 10))
+}
+
+// storedJSON возвращает метрику с сохранённым для её источника значением.
+func storedJSON(metric MetricsJSON) (MetricsJSON, error) {
+	for _, r := range storage.Records() {
+		if r.MType == metric.MType && r.Name == metric.ID && r.Source == metric.Source {
+			return RecordJSON(r), nil
+		}
+	}
+	return metric, errors.New("metric not found")
+}
+
 // UpdateMetrics обновляет метрики.
 func UpdateMetrics(locallink client.Locallink, mtype string, mname string, mvalue string) error {
 	client := &http.Client{}
@@ -499,12 +283,13 @@ func UpdateMetrics(locallink client.Locallink, mtype string, mname string, mvalu
 
 	locallinkIP := service.GetIP(locallink.RunAddr)
 	request.Header.Set("X-Real-IP", locallinkIP.String())
+	setAgentHeaders(request, locallink)
 
 	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
-	response.Body.Close()
+	defer response.Body.Close()
 	_, err = io.Copy(os.Stdout, response.Body)
 	if err != nil {
 		return err
@@ -518,17 +303,18 @@ func UpdateBatchMetrics(locallink client.Locallink, metrics []postgres.Metrics)
 	url := service.MakeBatchUpdatesURL(locallink.RunAddr)
 	data := new(bytes.Buffer)
 	defer data.Reset()
-	gzb := gzip.NewWriter(data)
-	err := json.NewEncoder(gzb).Encode(metrics)
+	err := json.NewEncoder(data).Encode(metrics)
 	if err != nil {
 		logger.Warnf("Error encode request body: " + err.Error())
 		return err
 	}
-	err = gzb.Close()
+	// сжимаем пакет кодеком из настроек агента
+	compressed, err := compress.Compress(locallink.ContentEncoding, data.Bytes())
 	if err != nil {
 		logger.Warnf("Error encode request body: " + err.Error())
 		return err
 	}
+	data = bytes.NewBuffer(compressed)
 	encrypteddata := data.String()
 	if locallink.PublicKeyPath != "" {
 		encrypteddata, err = crypt.Encrypt(locallink.PublicKeyPath, data.String())
@@ -543,57 +329,84 @@ func UpdateBatchMetrics(locallink client.Locallink, metrics []postgres.Metrics)
 		logger.Warnf("Error encode request body: " + err.Error())
 		return err
 	}
-	request, err := http.NewRequest(http.MethodPost, url, data)
-	if err != nil {
-		return err
-	}
-	request.Header.Set("Content-Type", `application/json`)
-	request.Header.Set("Content-Encoding", "gzip")
+	// ключ создаётся один раз для пакета: повторные попытки отправляют пакет с тем же ключом,
+	// поэтому сервер, уже применивший пакет, не применит его ещё раз
+	key := service.NewIdempotencyKey()
+	body := data.Bytes()
+	return retry.Do(func() error {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return retry.Unrecoverable(err)
+		}
+		request.Header.Set("Content-Type", `application/json`)
+		if encoding := compress.Normalize(locallink.ContentEncoding); encoding != compress.Identity {
+			request.Header.Set("Content-Encoding", encoding)
+		}
+		request.Header.Set(service.IdempotencyKeyHeader, key)
 
-	locallinkIP := service.GetIP(locallink.RunAddr)
-	request.Header.Set("X-Real-IP", locallinkIP.String())
+		locallinkIP := service.GetIP(locallink.RunAddr)
+		request.Header.Set("X-Real-IP", locallinkIP.String())
+		setAgentHeaders(request, locallink)
 
-	if locallink.HashKey != "" {
-		request.Header.Set("HashSHA256", service.GetHashString(data.Bytes(), locallink.HashKey))
+		if locallink.HashKey != "" {
+			request.Header.Set("HashSHA256", service.GetHashString(body, locallink.HashKey))
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		// ответы 5xx и 409 (пакет ещё обрабатывается) не сохраняются сервером, отправка повторяется
+		if response.StatusCode >= http.StatusInternalServerError || response.StatusCode == http.StatusConflict {
+			return fmt.Errorf("unexpected status %s", response.Status)
+		}
+		return nil
+	},
+		retry.Attempts(3),
+		retry.Delay(1000*time.Millisecond),
+		retry.LastErrorOnly(true),
+	)
+}
+
+// setAgentHeaders добавляет в запрос сведения об агенте.
+func setAgentHeaders(request *http.Request, locallink client.Locallink) {
+	if locallink.AgentID != "" {
+		request.Header.Set(service.AgentIDHeader, locallink.AgentID)
 	}
-	response, err := client.Do(request)
-	if err != nil {
-		return err
+	if locallink.Version != "" {
+		request.Header.Set(service.AgentVersionHeader, locallink.Version)
+	}
+	if locallink.ReportInterval > 0 {
+		request.Header.Set(service.AgentIntervalHeader, strconv.Itoa(locallink.ReportInterval))
+	}
+	if locallink.Group != "" {
+		request.Header.Set(service.AgentGroupHeader, locallink.Group)
+	}
+	if locallink.ConfigVersion != "" {
+		request.Header.Set(service.AgentConfigVersionHeader, locallink.ConfigVersion)
 	}
-	response.Body.Close()
-	return nil
 }
 
-func metricstemplate() string {
-	return `<html>
-	<head>
-	<title></title>
-	</head>
-	<body>
-		<table border="1" cellpadding="1" cellspacing="1" style="width: 500px">
-			<thead>
-				<tr>
-					<th scope="col">Gauge metric</th>
-					<th scope="col">Value</th>
-				</tr>
-			</thead>
-			<tbody>
-				{{ .Rowsg }}
-			</tbody>
-		</table>
-		<table border="1" cellpadding="1" cellspacing="1" style="width: 500px">
-			<thead>
-				<tr>
-					<th scope="col">Counter metric</th>
-					<th scope="col">Value</th>
-				</tr>
-			</thead>
-			<tbody>
-				{{ .Rowsc }}
-			</tbody>
-		</table>
-	</body>
-</html>`
+// GetAgentConfig запрашивает параметры агента, заданные на сервере.
+func GetAgentConfig(ctx context.Context, locallink client.Locallink) (agents.Config, error) {
+	var cfg agents.Config
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, service.MakeAgentConfigURL(locallink.RunAddr, locallink.AgentID), nil)
+	if err != nil {
+		return cfg, err
+	}
+	locallinkIP := service.GetIP(locallink.RunAddr)
+	request.Header.Set("X-Real-IP", locallinkIP.String())
+	setAgentHeaders(request, locallink)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return cfg, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return cfg, fmt.Errorf("agent config: unexpected status %s", response.Status)
+	}
+	err = json.NewDecoder(response.Body).Decode(&cfg)
+	return cfg, err
 }
 
 // RestoreMetrics восстанавливает значения метрик.
@@ -623,10 +436,8 @@ func readFile(fileStoragePath string) ([]MetricsJSON, error) {
 
 func restoreMetric(metric MetricsJSON, line int) {
 	var err error
-	if metric.MType == "gauge" {
-		err = repo(metric.ID, metric.MType, strconv.FormatFloat(*metric.Value, 'g', -1, 64)).Add()
-	} else if metric.MType == "counter" {
-		err = repo(metric.ID, metric.MType, strconv.FormatInt(*metric.Delta, 10)).Add()
+	if metric.MType == "gauge" || metric.MType == "counter" {
+		err = metricRepo(metric).Add()
 	} else {
 		logger.Warnf("Read file error: unknown metric type - " + metric.MType + ", line: " + strconv.Itoa(line))
 	}
@@ -637,6 +448,7 @@ func restoreMetric(metric MetricsJSON, line int) {
 
 // StoreMetrics сохраняет значения метрик.
 func StoreMetrics(fileStoragePath string) {
+	start := time.Now()
 	data, err := json.MarshalIndent(allMetricsJSON(), "", "   ")
 	if err != nil {
 		logger.Warnf("Write file error: " + err.Error())
@@ -646,29 +458,37 @@ func StoreMetrics(fileStoragePath string) {
 	if err != nil {
 		logger.Warnf("Write file error: " + err.Error())
 	}
+	telemetry.SnapshotDuration.Observe(time.Since(start).Seconds(), telemetry.Result(err))
 }
 
-func storeMetric(m MetricsJSON, fileStoragePath string) {
-	metric, err := json.MarshalIndent(m, "", "   ")
-	if err != nil {
-		logger.Warnf("Write file error: " + err.Error())
-	}
-	// сохраняем данные в файл
-	err = os.WriteFile(fileStoragePath, metric, 0666)
-	if err != nil {
-		logger.Warnf("Write file error: " + err.Error())
-	}
-}
-
+// allMetricsJSON возвращает значения метрик в разрезе источников
+// в порядке их обновления, чтобы при восстановлении последним
+// применялось самое свежее значение gauge.
 func allMetricsJSON() []MetricsJSON {
 	var metrics []MetricsJSON
-	storGauges := repo("", "gauge", "").GetValues().Gauges
-	for name, val := range storGauges {
-		metrics = append(metrics, MetricsJSON{ID: name, MType: "gauge", Value: &val})
-	}
-	storCounters := repo("", "counter", "").GetValues().Counters
-	for name, del := range storCounters {
-		metrics = append(metrics, MetricsJSON{ID: name, MType: "counter", Delta: &del})
+	records := storage.Records()
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Updated.Before(records[j].Updated)
+	})
+	for _, r := range records {
+		metrics = append(metrics, RecordJSON(r))
 	}
 	return metrics
 }
+
+// RecordJSON переводит значение метрики источника в JSON.
+func RecordJSON(r storage.Record) MetricsJSON {
+	m := MetricsJSON{ID: r.Name, MType: r.MType, Source: r.Source}
+	if r.MType == "gauge" {
+		value := r.Value
+		m.Value = &value
+		if !r.Timestamp.IsZero() {
+			ts := r.Timestamp.UnixMilli()
+			m.Timestamp = &ts
+		}
+	} else {
+		delta := r.Delta
+		m.Delta = &delta
+	}
+	return m
+}
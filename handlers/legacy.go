@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"musthave-metrics/internal/limits"
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/service"
+)
+
+// LegacyRoutes добавляет в r прежние маршруты /update/, /updates/ и /value/.
+// Маршруты сохранены для совместимости и передают запросы обработчикам /api/v1 для store,
+// поэтому работают одинаково с хранилищем в памяти и в СУБД. Маршруты с метрикой в пути
+// отвечают, как прежде, текстом, остальные — как /api/v1.
+// Middleware writes применяются только к запросам, присылающим метрики.
+func LegacyRoutes(r chi.Router, store Store, mode string, writes ...func(http.Handler) http.Handler) {
+	a := api{store: store, mode: mode}
+	r.HandleFunc("/value/{metricType}/{metricName}", a.legacyValue)
+	r.HandleFunc("/value/", a.legacyValueBody)
+	r.Group(func(r chi.Router) {
+		r.Use(writes...)
+		r.HandleFunc("/update/{metricType}/{metricName}/{metricValue}", a.legacyUpdate)
+		r.HandleFunc("/update/", a.legacyUpdateBody)
+		r.HandleFunc("/updates/", a.updates)
+	})
+}
+
+// bufferedWriter запоминает ответ обработчика /api/v1,
+// чтобы прежний маршрут вывел его в своём формате.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{header: make(http.Header)}
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// legacyUpdate сохраняет метрику из пути /update/{metricType}/{metricName}/{metricValue}
+// обработчиком update и отвечает только кодом.
+func (a api) legacyUpdate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	var m MetricsJSON
+	value := chi.URLParam(r, "metricValue")
+	switch chi.URLParam(r, "metricType") {
+	case "gauge":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.Value = &v
+	case "counter":
+		d, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.Delta = &d
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.Header.Set("Content-Type", ContentTypeJSON)
+	bw := newBufferedWriter()
+	a.update(bw, r)
+	w.WriteHeader(bw.status)
+}
+
+// legacyValue выводит значение метрики из пути /value/{metricType}/{metricName},
+// полученное обработчиком value, текстом.
+func (a api) legacyValue(w http.ResponseWriter, r *http.Request) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Accept", ContentTypeJSON)
+	bw := newBufferedWriter()
+	a.value(bw, r)
+	if bw.status != http.StatusOK {
+		w.WriteHeader(bw.status)
+		return
+	}
+	var m MetricsJSON
+	if err := json.Unmarshal(bw.body.Bytes(), &m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var val string
+	if m.Delta != nil {
+		val = strconv.FormatInt(*m.Delta, 10)
+	} else if m.Value != nil {
+		val = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(val)); err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
+// legacyUpdateBody сохраняет метрику из тела запроса /update/ обработчиком update.
+func (a api) legacyUpdateBody(w http.ResponseWriter, r *http.Request) {
+	r, _, ok := bodyMetricPath(w, r)
+	if !ok {
+		return
+	}
+	a.update(w, r)
+}
+
+// legacyValueBody выводит значение метрики из тела запроса /value/ обработчиком value.
+// Источник метрики из тела запроса передаётся обработчику в заголовке X-Agent-ID.
+func (a api) legacyValueBody(w http.ResponseWriter, r *http.Request) {
+	r, m, ok := bodyMetricPath(w, r)
+	if !ok {
+		return
+	}
+	if m.Source != "" {
+		r.Header.Set(service.AgentIDHeader, m.Source)
+	}
+	a.value(w, r)
+}
+
+// bodyMetricPath добавляет в параметры маршрута тип и имя метрики из тела запроса,
+// чтобы запрос обработал обработчик /api/v1 с метрикой в пути.
+// Тело запроса сохраняется для обработчика. При ошибке разбора выводит ошибку и возвращает false.
+func bodyMetricPath(w http.ResponseWriter, r *http.Request) (*http.Request, MetricsJSON, bool) {
+	var m MetricsJSON
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		writeError(w, limits.Status(err), err.Error(), nil)
+		return r, m, false
+	}
+	if err := unmarshalMetric(buf.Bytes(), requestContentType(r), &m); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error(), nil)
+		return r, m, false
+	}
+	r = r.Clone(r.Context())
+	r.Body = io.NopCloser(&buf)
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		rctx.URLParams.Add("metricType", m.MType)
+		rctx.URLParams.Add("metricName", m.ID)
+	}
+	return r, m, true
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"musthave-metrics/internal/storage"
+)
+
+// размер страницы списка метрик
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// NextCursorHeader содержит курсор следующей страницы списка метрик.
+const NextCursorHeader = "X-Next-Cursor"
+
+// порядок сортировки списка метрик
+const (
+	SortName    = "name"
+	SortValue   = "value"
+	SortUpdated = "updated"
+)
+
+// ErrBadQuery возвращается для некорректных параметров списка метрик.
+var ErrBadQuery = errors.New("invalid query")
+
+// ListQuery — параметры списка метрик.
+type ListQuery struct {
+	MType  string // gauge или counter, пустой — оба типа
+	Prefix string // начало имени метрики
+	Match  string // шаблон имени с * и ? или регулярное выражение в /.../
+	Source string // идентификатор агента, пустой — все источники
+	Sort   string // SortName, SortValue или SortUpdated
+	Desc   bool   // сортировка по убыванию
+	Limit  int    // размер страницы
+	Cursor string // курсор страницы, полученный в NextCursorHeader
+
+	re *regexp.Regexp
+}
+
+// cursor — положение в списке метрик: ключ сортировки последней выведенной метрики.
+type cursor struct {
+	Sort    string  `json:"s"`
+	MType   string  `json:"t"`
+	Name    string  `json:"n"`
+	Source  string  `json:"src,omitempty"`
+	Value   float64 `json:"v,omitempty"`
+	Updated int64   `json:"u,omitempty"`
+}
+
+// ParseListQuery разбирает параметры списка метрик:
+// type, prefix, match, source, sort (name, value, updated, с - — по убыванию), limit и cursor.
+func ParseListQuery(values url.Values) (ListQuery, error) {
+	q := ListQuery{
+		MType:  values.Get("type"),
+		Prefix: values.Get("prefix"),
+		Match:  values.Get("match"),
+		Source: values.Get("source"),
+		Sort:   values.Get("sort"),
+		Cursor: values.Get("cursor"),
+		Limit:  DefaultPageSize,
+	}
+	if q.MType != "" && q.MType != "gauge" && q.MType != "counter" {
+		return q, fmt.Errorf("%w: unknown metric type %q", ErrBadQuery, q.MType)
+	}
+	q.Sort, q.Desc = strings.CutPrefix(q.Sort, "-")
+	switch q.Sort {
+	case "":
+		q.Sort = SortName
+	case SortName, SortValue, SortUpdated:
+	default:
+		return q, fmt.Errorf("%w: unknown sort %q", ErrBadQuery, q.Sort)
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxPageSize {
+			return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrBadQuery, MaxPageSize)
+		}
+		q.Limit = n
+	}
+	if expr, ok := regexpMatch(q.Match); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return q, fmt.Errorf("%w: %v", ErrBadQuery, err)
+		}
+		q.re = re
+	} else if _, err := path.Match(q.Match, ""); err != nil {
+		return q, fmt.Errorf("%w: %v", ErrBadQuery, err)
+	}
+	return q, nil
+}
+
+// regexpMatch возвращает регулярное выражение из шаблона вида /.../.
+func regexpMatch(match string) (string, bool) {
+	if len(match) > 1 && strings.HasPrefix(match, "/") && strings.HasSuffix(match, "/") {
+		return match[1 : len(match)-1], true
+	}
+	return "", false
+}
+
+// Page отбирает, упорядочивает и выводит одну страницу значений метрик.
+// Возвращает курсор следующей страницы, пустой для последней страницы.
+func (q ListQuery) Page(records []storage.Record) ([]storage.Record, string, error) {
+	var after *storage.Record
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.sortKey() {
+			return nil, "", fmt.Errorf("%w: cursor does not match the query", ErrBadQuery)
+		}
+		after = &storage.Record{MType: c.MType, Name: c.Name, Source: c.Source, Value: c.Value, Updated: time.Unix(0, c.Updated)}
+		if c.MType == "counter" {
+			after.Delta = int64(c.Value)
+		}
+	}
+	page := make([]storage.Record, 0)
+	for _, r := range records {
+		if q.match(r) && (after == nil || q.compare(r, *after) > 0) {
+			page = append(page, r)
+		}
+	}
+	sort.SliceStable(page, func(i, j int) bool {
+		return q.compare(page[i], page[j]) < 0
+	})
+	if len(page) <= q.Limit {
+		return page, "", nil
+	}
+	page = page[:q.Limit]
+	last := page[len(page)-1]
+	next := cursor{
+		Sort:    q.sortKey(),
+		MType:   last.MType,
+		Name:    last.Name,
+		Source:  last.Source,
+		Value:   numericValue(last),
+		Updated: last.Updated.UnixNano(),
+	}
+	return page, encodeCursor(next), nil
+}
+
+func (q ListQuery) sortKey() string {
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+func (q ListQuery) match(r storage.Record) bool {
+	if q.MType != "" && r.MType != q.MType {
+		return false
+	}
+	if q.Source != "" && r.Source != q.Source {
+		return false
+	}
+	if !strings.HasPrefix(r.Name, q.Prefix) {
+		return false
+	}
+	if q.re != nil {
+		return q.re.MatchString(r.Name)
+	}
+	if q.Match != "" {
+		ok, _ := path.Match(q.Match, r.Name)
+		return ok
+	}
+	return true
+}
+
+// compare сравнивает метрики в порядке сортировки. При равенстве ключа
+// порядок определяют тип, имя и источник, поэтому он не меняется между запросами.
+func (q ListQuery) compare(a, b storage.Record) int {
+	var c int
+	switch q.Sort {
+	case SortValue:
+		c = cmp.Compare(numericValue(a), numericValue(b))
+	case SortUpdated:
+		c = a.Updated.Compare(b.Updated)
+	}
+	if c == 0 {
+		// gauge выводится перед counter, как на странице метрик
+		c = cmp.Compare(b.MType, a.MType)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.Name, b.Name)
+	}
+	if c == 0 {
+		c = cmp.Compare(a.Source, b.Source)
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+// numericValue возвращает значение метрики в виде числа.
+func numericValue(r storage.Record) float64 {
+	if r.MType == "counter" {
+		return float64(r.Delta)
+	}
+	return r.Value
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal(data, &c)
+}
+
+// recordsJSON переводит значения метрик в JSON.
+func recordsJSON(records []storage.Record) []MetricsJSON {
+	metrics := make([]MetricsJSON, 0, len(records))
+	for _, r := range records {
+		metrics = append(metrics, RecordJSON(r))
+	}
+	return metrics
+}
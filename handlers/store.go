@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"musthave-metrics/internal/postgres"
+	"musthave-metrics/internal/storage"
+	"musthave-metrics/internal/telemetry"
+)
+
+// ErrNotFound возвращается, если метрика не найдена.
+var ErrNotFound = errors.New("metric not found")
+
+// Store сохраняет и читает метрики независимо от места их хранения.
+type Store interface {
+	// Update сохраняет метрику и возвращает сохранённое значение (для counter — накопленное источником).
+	// Устаревшее значение gauge не сохраняется, возвращается сохранённое.
+	Update(ctx context.Context, m MetricsJSON) (MetricsJSON, error)
+	// Updates сохраняет пакет проверенных метрик. При atomic пакет сохраняется целиком
+	// или не сохраняется вовсе, ошибка метрики возвращается как postgres.ItemError.
+	// Иначе метрики сохраняются по отдельности, ошибки возвращаются по индексам.
+	Updates(ctx context.Context, metrics []MetricsJSON, atomic bool) ([]error, error)
+	// Value возвращает значение метрики: для gauge — последнее полученное,
+	// для counter — сумму по всем источникам. Непустой source ограничивает выборку одним источником.
+	Value(ctx context.Context, mtype string, name string, source string) (MetricsJSON, error)
+	// List возвращает значения метрик в разрезе источников. Непустой source ограничивает вывод одним агентом.
+	List(ctx context.Context, source string) ([]storage.Record, error)
+	// Delete удаляет подходящие под фильтр значения метрик вместе с историей и возвращает их число.
+	Delete(ctx context.Context, f storage.Filter) (int64, error)
+	// Reset обнуляет подходящие под фильтр значения counter и возвращает их число.
+	Reset(ctx context.Context, f storage.Filter) (int64, error)
+	// Replace удаляет все значения метрик и сохраняет пакет проверенных метрик.
+	// При ошибке сохранённые значения не меняются. Возвращает число удалённых значений.
+	Replace(ctx context.Context, metrics []MetricsJSON) (int64, error)
+}
+
+// MemoryStore хранит метрики в памяти сервера.
+type MemoryStore struct {
+	// StoreInterval — интервал сохранения метрик на диск, при 0 метрики сохраняются при каждом обновлении.
+	StoreInterval int
+	// FileStoragePath — путь к файлу для сохранения метрик.
+	FileStoragePath string
+}
+
+// Update сохраняет метрику в памяти.
+func (s MemoryStore) Update(ctx context.Context, m MetricsJSON) (MetricsJSON, error) {
+	err := metricRepo(m).Add()
+	if err != nil && !errors.Is(err, storage.ErrStaleUpdate) {
+		return m, err
+	}
+	s.flush()
+	return storedJSON(m)
+}
+
+// Updates сохраняет пакет метрик в памяти.
+// Проверенные метрики сохраняются без ошибок, поэтому atomic не учитывается.
+func (s MemoryStore) Updates(ctx context.Context, metrics []MetricsJSON, atomic bool) ([]error, error) {
+	errs := make([]error, len(metrics))
+	for i, m := range metrics {
+		if err := metricRepo(m).Add(); err != nil && !errors.Is(err, storage.ErrStaleUpdate) {
+			errs[i] = err
+		}
+	}
+	s.flush()
+	return errs, nil
+}
+
+// Value возвращает значение метрики из памяти.
+func (s MemoryStore) Value(ctx context.Context, mtype string, name string, source string) (MetricsJSON, error) {
+	m := MetricsJSON{ID: name, MType: mtype, Source: source}
+	repository := sourceRepo(source, name, mtype, "")
+	if repository == nil {
+		return m, ErrNotFound
+	}
+	val, err := repository.GetValue()
+	if err != nil {
+		return m, err
+	}
+	if val == "" {
+		return m, ErrNotFound
+	}
+	return parseValue(m, val)
+}
+
+// List возвращает значения метрик из памяти.
+func (s MemoryStore) List(ctx context.Context, source string) ([]storage.Record, error) {
+	return filterSource(storage.Records(), source), nil
+}
+
+// Delete удаляет метрики из памяти и из файла.
+func (s MemoryStore) Delete(ctx context.Context, f storage.Filter) (int64, error) {
+	n := len(storage.Delete(f))
+	s.snapshot(n)
+	return int64(n), nil
+}
+
+// Reset обнуляет значения counter в памяти и в файле.
+func (s MemoryStore) Reset(ctx context.Context, f storage.Filter) (int64, error) {
+	n := len(storage.Reset(f))
+	s.snapshot(n)
+	return int64(n), nil
+}
+
+// Replace заменяет метрики в памяти и в файле.
+// Проверенные метрики сохраняются без ошибок, поэтому замена не прерывается после удаления.
+func (s MemoryStore) Replace(ctx context.Context, metrics []MetricsJSON) (int64, error) {
+	n := len(storage.Delete(storage.Filter{Pattern: "*"}))
+	for _, m := range metrics {
+		if err := metricRepo(m).Add(); err != nil && !errors.Is(err, storage.ErrStaleUpdate) {
+			return int64(n), err
+		}
+	}
+	s.snapshot(n + len(metrics))
+	return int64(n), nil
+}
+
+// snapshot сохраняет метрики в файл после удаления или сброса независимо от интервала сохранения,
+// чтобы при восстановлении из файла удалённые значения не вернулись.
+func (s MemoryStore) snapshot(changed int) {
+	if changed > 0 && s.FileStoragePath != "" {
+		StoreMetrics(s.FileStoragePath)
+	}
+}
+
+func (s MemoryStore) flush() {
+	if s.StoreInterval == 0 {
+		StoreMetrics(s.FileStoragePath)
+	}
+}
+
+// DBStore хранит метрики в СУБД.
+type DBStore struct {
+	settings postgres.Settings
+	db       *pgxpool.Pool
+}
+
+// NewDBStore создаёт хранилище метрик в СУБД с пулом соединений db.
+// Пул закрывает вызывающий.
+func NewDBStore(db *pgxpool.Pool) *DBStore {
+	return &DBStore{settings: postgres.NewPSQLStr(db.Config().ConnString()), db: db}
+}
+
+// Update сохраняет метрику в СУБД и добавляет её значение в историю.
+func (s *DBStore) Update(ctx context.Context, m MetricsJSON) (MetricsJSON, error) {
+	val, err := s.settings.UpdateNew(ctx, s.db, m.MType, m.ID, m.Source, m.Delta, m.Value, m.Timestamp)
+	if err != nil && !errors.Is(err, storage.ErrStaleUpdate) {
+		return m, err
+	}
+	if err == nil {
+		addSample(m.MType, m.ID, m.Source, val)
+	}
+	return parseValue(m, val)
+}
+
+// Updates сохраняет пакет метрик в СУБД и добавляет их значения в историю.
+func (s *DBStore) Updates(ctx context.Context, metrics []MetricsJSON, atomic bool) ([]error, error) {
+	errs := make([]error, len(metrics))
+	if atomic {
+		batch := make([]postgres.Metrics, 0, len(metrics))
+		for _, m := range metrics {
+			batch = append(batch, postgres.Metrics(m))
+		}
+		stored, err := s.settings.Updates(ctx, s.db, batch)
+		if err != nil {
+			return nil, err
+		}
+		addSamples(stored)
+		return errs, nil
+	}
+	// каждая метрика сохраняется в своей транзакции, ошибка не отменяет остальные
+	for i, m := range metrics {
+		stored, err := s.settings.Updates(ctx, s.db, []postgres.Metrics{postgres.Metrics(m)})
+		var itemErr postgres.ItemError
+		if errors.As(err, &itemErr) {
+			err = itemErr.Err
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		addSamples(stored)
+	}
+	return errs, nil
+}
+
+// Value возвращает значение метрики из СУБД.
+func (s *DBStore) Value(ctx context.Context, mtype string, name string, source string) (MetricsJSON, error) {
+	m := MetricsJSON{ID: name, MType: mtype, Source: source}
+	val, err := s.settings.Value(ctx, s.db, mtype, name, source)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	return parseValue(m, val)
+}
+
+// List возвращает значения метрик из СУБД.
+func (s *DBStore) List(ctx context.Context, source string) ([]storage.Record, error) {
+	records, err := s.settings.Records(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return filterSource(records, source), nil
+}
+
+// Delete удаляет метрики из СУБД и их историю из памяти.
+func (s *DBStore) Delete(ctx context.Context, f storage.Filter) (int64, error) {
+	n, err := s.settings.Delete(ctx, s.db, f.MType, f.Pattern, f.Source)
+	if err != nil {
+		return 0, err
+	}
+	storage.Delete(f)
+	return n, nil
+}
+
+// Reset обнуляет значения counter в СУБД.
+func (s *DBStore) Reset(ctx context.Context, f storage.Filter) (int64, error) {
+	n, err := s.settings.ResetCounters(ctx, s.db, f.Pattern, f.Source)
+	if err != nil {
+		return 0, err
+	}
+	storage.Reset(f)
+	return n, nil
+}
+
+// Replace заменяет метрики в СУБД в одной транзакции и их историю в памяти.
+func (s *DBStore) Replace(ctx context.Context, metrics []MetricsJSON) (int64, error) {
+	batch := make([]postgres.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		batch = append(batch, postgres.Metrics(m))
+	}
+	n, stored, err := s.settings.Replace(ctx, s.db, batch)
+	if err != nil {
+		return 0, err
+	}
+	storage.Delete(storage.Filter{Pattern: "*"})
+	addSamples(stored)
+	return n, nil
+}
+
+// filterSource оставляет значения метрик, полученные от источника source; пустой source оставляет все.
+func filterSource(records []storage.Record, source string) []storage.Record {
+	result := make([]storage.Record, 0, len(records))
+	for _, r := range records {
+		if source == "" || r.Source == source {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// addSamples добавляет сохранённые в СУБД значения метрик в историю.
+func addSamples(stored []postgres.Metrics) {
+	for _, m := range stored {
+		if m.MType == "gauge" {
+			storage.AddSample(m.MType, m.ID, m.Source, *m.Value)
+		} else if m.MType == "counter" {
+			storage.AddSample(m.MType, m.ID, m.Source, float64(*m.Delta))
+		}
+	}
+}
+
+// parseValue подставляет в метрику значение val.
+func parseValue(m MetricsJSON, val string) (MetricsJSON, error) {
+	switch m.MType {
+	case "gauge":
+		value, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Value, m.Delta = &value, nil
+	case "counter":
+		delta, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Delta, m.Value = &delta, nil
+	default:
+		return m, ErrNotFound
+	}
+	return m, nil
+}
+
+// observedStore учитывает длительность операций хранилища в метриках сервера.
+type observedStore struct {
+	store Store
+	name  string
+}
+
+// ObserveStore возвращает хранилище store, длительность операций которого учитывается
+// в метриках сервера с меткой name.
+func ObserveStore(store Store, name string) Store {
+	return observedStore{store: store, name: name}
+}
+
+// observe учитывает операцию op, начатую в start. Ошибка передаётся указателем,
+// чтобы в отложенном вызове учитывался результат операции.
+func (s observedStore) observe(op string, start time.Time, err *error) {
+	telemetry.StorageDuration.Observe(time.Since(start).Seconds(), s.name, op, telemetry.Result(*err))
+}
+
+// Update сохраняет метрику.
+func (s observedStore) Update(ctx context.Context, m MetricsJSON) (stored MetricsJSON, err error) {
+	defer s.observe("update", time.Now(), &err)
+	return s.store.Update(ctx, m)
+}
+
+// Updates сохраняет пакет метрик.
+func (s observedStore) Updates(ctx context.Context, metrics []MetricsJSON, atomic bool) (errs []error, err error) {
+	defer s.observe("updates", time.Now(), &err)
+	return s.store.Updates(ctx, metrics, atomic)
+}
+
+// Value возвращает значение метрики. Ненайденная метрика не считается ошибкой хранилища.
+func (s observedStore) Value(ctx context.Context, mtype string, name string, source string) (m MetricsJSON, err error) {
+	start := time.Now()
+	m, err = s.store.Value(ctx, mtype, name, source)
+	result := err
+	if errors.Is(err, ErrNotFound) {
+		result = nil
+	}
+	s.observe("value", start, &result)
+	return m, err
+}
+
+// List возвращает значения метрик.
+func (s observedStore) List(ctx context.Context, source string) (records []storage.Record, err error) {
+	defer s.observe("list", time.Now(), &err)
+	return s.store.List(ctx, source)
+}
+
+// Delete удаляет метрики.
+func (s observedStore) Delete(ctx context.Context, f storage.Filter) (n int64, err error) {
+	defer s.observe("delete", time.Now(), &err)
+	return s.store.Delete(ctx, f)
+}
+
+// Reset обнуляет значения counter.
+func (s observedStore) Reset(ctx context.Context, f storage.Filter) (n int64, err error) {
+	defer s.observe("reset", time.Now(), &err)
+	return s.store.Reset(ctx, f)
+}
+
+// Replace заменяет все метрики.
+func (s observedStore) Replace(ctx context.Context, metrics []MetricsJSON) (n int64, err error) {
+	defer s.observe("replace", time.Now(), &err)
+	return s.store.Replace(ctx, metrics)
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"musthave-metrics/internal/limits"
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/internal/storage"
+)
+
+// форматы выгрузки и загрузки метрик
+const (
+	// FormatJSON — массив JSON, как в файле сохранения метрик.
+	FormatJSON = "json"
+	// FormatNDJSON — по одной метрике JSON в строке.
+	FormatNDJSON = "ndjson"
+	// FormatCSV — таблица с заголовком id,type,delta,value,source,timestamp.
+	FormatCSV = "csv"
+	// FormatProm — текстовый формат Prometheus, только для выгрузки.
+	FormatProm = "prom"
+)
+
+// режимы загрузки метрик
+const (
+	// ImportMerge добавляет метрики к имеющимся.
+	ImportMerge = "merge"
+	// ImportReplace удаляет все метрики перед загрузкой.
+	ImportReplace = "replace"
+)
+
+// применение значений counter при загрузке
+const (
+	// CountersAdd прибавляет загружаемое значение к накопленному, как при восстановлении из файла.
+	CountersAdd = "add"
+	// CountersSet заменяет накопленное значение загружаемым.
+	CountersSet = "set"
+)
+
+// ErrBadFormat возвращается для неизвестного формата выгрузки или загрузки.
+var ErrBadFormat = errors.New("unknown format")
+
+var csvHeader = []string{"id", "type", "delta", "value", "source", "timestamp"}
+
+// formatContentTypes — типы содержимого форматов.
+var formatContentTypes = map[string]string{
+	FormatJSON:   "application/json",
+	FormatNDJSON: "application/x-ndjson",
+	FormatCSV:    "text/csv",
+	FormatProm:   "text/plain; version=0.0.4",
+}
+
+// ImportResult — ответ на загрузку метрик.
+type ImportResult struct {
+	Imported int   `json:"imported"`          // число загруженных метрик
+	Deleted  int64 `json:"deleted,omitempty"` // число значений, удалённых в режиме ImportReplace
+}
+
+// WriteExport выводит значения метрик в формате format.
+// Метрики выводятся в порядке обновления, чтобы при загрузке последним применялось самое свежее значение gauge,
+// а в FormatProm — по именам, как того требует формат.
+func WriteExport(w io.Writer, format string, records []storage.Record) error {
+	records = append([]storage.Record(nil), records...)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Updated.Before(records[j].Updated)
+	})
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(recordsJSON(records), "", "   ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(RecordJSON(r)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := cw.Write(csvRecord(RecordJSON(r))); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatProm:
+		return writeProm(w, records)
+	}
+	return fmt.Errorf("%w %q", ErrBadFormat, format)
+}
+
+// ReadImport читает метрики в формате format: FormatJSON, FormatNDJSON или FormatCSV.
+func ReadImport(r io.Reader, format string) ([]MetricsJSON, error) {
+	switch format {
+	case FormatJSON:
+		metrics := make([]MetricsJSON, 0)
+		if err := json.NewDecoder(r).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		return metrics, nil
+	case FormatNDJSON:
+		metrics := make([]MetricsJSON, 0)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var m MetricsJSON
+			if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			metrics = append(metrics, m)
+		}
+		return metrics, scanner.Err()
+	case FormatCSV:
+		return readCSV(r)
+	}
+	return nil, fmt.Errorf("%w %q", ErrBadFormat, format)
+}
+
+func csvRecord(m MetricsJSON) []string {
+	row := []string{m.ID, m.MType, "", "", m.Source, ""}
+	if m.Delta != nil {
+		row[2] = strconv.FormatInt(*m.Delta, 10)
+	}
+	if m.Value != nil {
+		row[3] = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+	}
+	if m.Timestamp != nil {
+		row[5] = strconv.FormatInt(*m.Timestamp, 10)
+	}
+	return row
+}
+
+// readCSV читает метрики из таблицы, столбцы которой определяются заголовком.
+func readCSV(r io.Reader) ([]MetricsJSON, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"id", "type"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header: column %s is required", name)
+		}
+	}
+	cell := func(row []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return row[i]
+		}
+		return ""
+	}
+	metrics := make([]MetricsJSON, 0)
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		m := MetricsJSON{ID: cell(row, "id"), MType: cell(row, "type"), Source: cell(row, "source")}
+		if s := cell(row, "delta"); s != "" {
+			delta, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: delta: %w", line, err)
+			}
+			m.Delta = &delta
+		}
+		if s := cell(row, "value"); s != "" {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: value: %w", line, err)
+			}
+			m.Value = &value
+		}
+		if s := cell(row, "timestamp"); s != "" {
+			ts, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: timestamp: %w", line, err)
+			}
+			m.Timestamp = &ts
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// writeProm выводит метрики в текстовом формате Prometheus. Источник передаётся меткой source.
+// К именам counter добавляется суффикс _total, чтобы gauge и counter с одним именем
+// были разными семействами метрик: Prometheus не принимает семейство с двумя типами.
+func writeProm(w io.Writer, records []storage.Record) error {
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = promName(r.Name)
+		if r.MType == "counter" {
+			names[i] += "_total"
+		}
+	}
+	order := make([]int, len(records))
+	for i := range order {
+		order[i] = i
+	}
+	// значения одного семейства выводятся подряд после строки # TYPE
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if names[a] != names[b] {
+			return names[a] < names[b]
+		}
+		return records[a].Source < records[b].Source
+	})
+	bw := bufio.NewWriter(w)
+	for i, k := range order {
+		r, name := records[k], names[k]
+		if i == 0 || names[order[i-1]] != name {
+			fmt.Fprintf(bw, "# TYPE %s %s\n", name, r.MType)
+		}
+		bw.WriteString(name)
+		if r.Source != "" {
+			fmt.Fprintf(bw, `{source="%s"}`, promLabel(r.Source))
+		}
+		bw.WriteString(" " + recordValue(r))
+		if !r.Timestamp.IsZero() {
+			bw.WriteString(" " + strconv.FormatInt(r.Timestamp.UnixMilli(), 10))
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// promName заменяет недопустимые в имени метрики Prometheus символы на _.
+func promName(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		case c >= '0' && c <= '9':
+			b.WriteByte('_')
+		default:
+			c = '_'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+var promLabelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(value string) string {
+	return promLabelReplacer.Replace(value)
+}
+
+// requestFormat возвращает формат из параметра format, а при его отсутствии — по типу содержимого запроса.
+func requestFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case formatContentTypes[FormatNDJSON]:
+		return FormatNDJSON
+	case formatContentTypes[FormatCSV]:
+		return FormatCSV
+	}
+	return FormatJSON
+}
+
+// export выгружает значения метрик. Параметр source ограничивает выгрузку одним агентом.
+func (a api) export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatJSON
+	}
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%s %q", ErrBadFormat, format), nil)
+		return
+	}
+	records, err := a.store.List(r.Context(), r.URL.Query().Get("source"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="metrics.`+format+`"`)
+	if err := WriteExport(w, format, records); err != nil {
+		logger.Warnf("Write response error: " + err.Error())
+	}
+}
+
+// importMetrics загружает метрики. Пакет загружается, только если все метрики корректны.
+// Режим ImportReplace заменяет все метрики пакетом, поэтому требует токена администратора;
+// при ошибке сохранённые метрики не меняются.
+// При CountersSet к значению counter прибавляется разница с накопленным источником значением.
+func (a api) importMetrics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode, counters := query.Get("mode"), query.Get("counters")
+	if mode == "" {
+		mode = ImportMerge
+	}
+	if counters == "" {
+		counters = CountersAdd
+	}
+	if (mode != ImportMerge && mode != ImportReplace) || (counters != CountersAdd && counters != CountersSet) {
+		writeError(w, http.StatusBadRequest, "mode must be merge or replace, counters must be add or set", nil)
+		return
+	}
+	metrics, err := ReadImport(r.Body, requestFormat(r))
+	if err == nil {
+		err = limits.CheckBatch(len(metrics))
+	}
+	if err != nil {
+		writeError(w, limits.Status(err), err.Error(), nil)
+		return
+	}
+	var result BatchResult
+	for i, m := range metrics {
+		if err := ValidateMetric(m); err != nil {
+			result.reject(i, m.ID, err)
+		}
+	}
+	if result.Rejected > 0 {
+		writeError(w, http.StatusBadRequest, "import rejected", result.Errors)
+		return
+	}
+	var imported ImportResult
+	if mode == ImportReplace {
+		// удаление и загрузка выполняются одной операцией хранилища
+		imported.Deleted, err = a.admin.Replace(r.Context(), a.store, metrics, r.Header.Get(service.AuthorizationHeader), r.RemoteAddr, "http")
+		if err != nil {
+			writeError(w, adminStatus(err), err.Error(), nil)
+			return
+		}
+		imported.Imported = len(metrics)
+		writeJSON(w, imported)
+		return
+	}
+	if counters == CountersSet {
+		records, err := a.store.List(r.Context(), "")
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error(), nil)
+			return
+		}
+		metrics = counterDifferences(metrics, records)
+	}
+	if _, err := a.store.Updates(r.Context(), metrics, true); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	imported.Imported = len(metrics)
+	writeJSON(w, imported)
+}
+
+// counterDifferences заменяет значения counter разницей между ними и накопленными значениями records,
+// чтобы после сохранения накопленные значения стали равны загруженным.
+func counterDifferences(metrics []MetricsJSON, records []storage.Record) []MetricsJSON {
+	type key struct{ name, source string }
+	stored := make(map[key]int64)
+	for _, r := range records {
+		if r.MType == "counter" {
+			stored[key{r.Name, r.Source}] = r.Delta
+		}
+	}
+	result := make([]MetricsJSON, 0, len(metrics))
+	for _, m := range metrics {
+		if m.MType == "counter" {
+			k := key{m.ID, m.Source}
+			delta := *m.Delta - stored[k]
+			// следующий counter того же источника в пакете прибавляется к уже загруженному
+			stored[k] = *m.Delta
+			m.Delta = &delta
+		}
+		result = append(result, m)
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+// Панель метрик: графики истории значений и обновление таблицы по событиям сервера.
+(function () {
+	"use strict";
+
+	// число точек графика
+	var historySize = 60;
+	var events = document.currentScript.dataset.events;
+	var status = document.getElementById("status");
+	var rows = {};
+
+	function key(type, name, source) {
+		return type + "/" + name + "/" + (source || "");
+	}
+
+	function formatTime(value) {
+		var t = new Date(value);
+		return isNaN(t) ? "" : t.toLocaleTimeString();
+	}
+
+	function draw(row) {
+		var points = row.points;
+		var line = row.tr.querySelector(".spark polyline");
+		if (points.length < 2) {
+			line.setAttribute("points", "");
+			return;
+		}
+		var min = Math.min.apply(null, points);
+		var max = Math.max.apply(null, points);
+		var span = max - min || 1;
+		line.setAttribute("points", points.map(function (v, i) {
+			var x = i * 100 / (points.length - 1);
+			var y = 19 - (v - min) / span * 18;
+			return x.toFixed(2) + "," + y.toFixed(2);
+		}).join(" "));
+	}
+
+	function push(row, value) {
+		row.points.push(value);
+		if (row.points.length > historySize) {
+			row.points.splice(0, row.points.length - historySize);
+		}
+		draw(row);
+	}
+
+	function loadHistory(row) {
+		var d = row.tr.dataset;
+		var url = "/history/" + encodeURIComponent(d.type) + "/" + encodeURIComponent(d.name) +
+			"?source=" + encodeURIComponent(d.source);
+		fetch(url).then(function (res) {
+			return res.ok ? res.json() : { samples: [] };
+		}).then(function (history) {
+			row.points = history.samples.map(function (s) { return s.value; }).slice(-historySize);
+			draw(row);
+		}).catch(function () {});
+	}
+
+	document.querySelectorAll("time[datetime]").forEach(function (t) {
+		t.textContent = formatTime(t.getAttribute("datetime"));
+	});
+
+	document.querySelectorAll("tr[data-name]").forEach(function (tr) {
+		var row = { tr: tr, points: [] };
+		rows[key(tr.dataset.type, tr.dataset.name, tr.dataset.source)] = row;
+		loadHistory(row);
+	});
+
+	if (!events || !window.EventSource) {
+		status.textContent = "static";
+		return;
+	}
+	var source = new EventSource(events);
+	source.addEventListener("open", function () {
+		status.textContent = "live";
+		status.classList.add("live");
+	});
+	source.addEventListener("error", function () {
+		status.textContent = "reconnecting";
+		status.classList.remove("live");
+	});
+	source.addEventListener("metrics", function (e) {
+		JSON.parse(e.data).forEach(function (m) {
+			var row = rows[key(m.type, m.id, m.source)];
+			if (!row) {
+				return;
+			}
+			row.tr.querySelector(".value").textContent = m.value;
+			var updated = row.tr.querySelector("time");
+			updated.setAttribute("datetime", m.updated);
+			updated.textContent = formatTime(m.updated);
+			push(row, Number(m.value));
+			row.tr.classList.add("changed");
+			clearTimeout(row.timer);
+			row.timer = setTimeout(function () {
+				row.tr.classList.remove("changed");
+			}, 1000);
+		});
+	});
+})();
@@ -0,0 +1,186 @@
+// Package agents ведёт реестр агентов, присылающих метрики на сервер.
+package agents
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"musthave-metrics/internal/service"
+)
+
+// способы отправки метрик агентом
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+const (
+	// DefaultStaleIntervals — число пропущенных интервалов отправки, после которого агент считается неактивным.
+	DefaultStaleIntervals = 3
+	// DefaultReportInterval — интервал отправки для агентов, которые его не сообщают.
+	DefaultReportInterval = 10 * time.Second
+)
+
+// Report описывает одну отправку метрик агентом.
+type Report struct {
+	ID             string
+	Address        string
+	Version        string
+	Transport      string
+	ReportInterval time.Duration
+	Group          string
+	ConfigVersion  string
+	Failed         bool // отправка завершилась ошибкой
+}
+
+// Agent хранит сведения об агенте.
+type Agent struct {
+	ID             string    `json:"id"`                       // идентификатор агента
+	Address        string    `json:"address"`                  // адрес последней отправки
+	Version        string    `json:"version"`                  // версия сборки агента
+	Transport      string    `json:"transport"`                // способ последней отправки: http или grpc
+	ReportInterval int64     `json:"report_interval"`          // интервал отправки в секундах
+	FirstSeen      time.Time `json:"first_seen"`               // время первой отправки
+	LastReport     time.Time `json:"last_report"`              // время последней отправки
+	Reports        int64     `json:"reports"`                  // число отправок
+	Errors         int64     `json:"errors"`                   // число отправок, завершившихся ошибкой
+	Stale          bool      `json:"stale"`                    // агент пропустил несколько интервалов отправки
+	Group          string    `json:"group,omitempty"`          // группа агента для конфигурации с сервера
+	ConfigVersion  string    `json:"config_version,omitempty"` // версия применённой конфигурации с сервера
+}
+
+// Registry хранит сведения об агентах.
+// Методы Registry безопасны для одновременного использования из нескольких горутин.
+type Registry struct {
+	mu             sync.RWMutex
+	agents         map[string]*Agent
+	staleIntervals int
+	now            func() time.Time
+}
+
+// NewRegistry создаёт реестр, в котором агент считается неактивным
+// после staleIntervals пропущенных интервалов отправки.
+func NewRegistry(staleIntervals int) *Registry {
+	if staleIntervals <= 0 {
+		staleIntervals = DefaultStaleIntervals
+	}
+	return &Registry{
+		agents:         make(map[string]*Agent),
+		staleIntervals: staleIntervals,
+		now:            time.Now,
+	}
+}
+
+// Observe учитывает отправку метрик агентом. Отправки без идентификатора агента не учитываются.
+func (r *Registry) Observe(rep Report) {
+	if rep.ID == "" {
+		return
+	}
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.agents[rep.ID]
+	if !ok {
+		a = &Agent{ID: rep.ID, FirstSeen: now}
+		r.agents[rep.ID] = a
+	}
+	a.Address = rep.Address
+	if rep.Version != "" {
+		a.Version = rep.Version
+	}
+	a.Transport = rep.Transport
+	a.Group = rep.Group
+	a.ConfigVersion = rep.ConfigVersion
+	if rep.ReportInterval > 0 {
+		a.ReportInterval = int64(rep.ReportInterval / time.Second)
+	}
+	a.LastReport = now
+	a.Reports++
+	if rep.Failed {
+		a.Errors++
+	}
+}
+
+// List возвращает сведения об агентах, упорядоченные по идентификатору.
+func (r *Registry) List() []Agent {
+	now := r.now()
+	r.mu.RLock()
+	list := make([]Agent, 0, len(r.agents))
+	for _, a := range r.agents {
+		agent := *a
+		interval := DefaultReportInterval
+		if agent.ReportInterval > 0 {
+			interval = time.Duration(agent.ReportInterval) * time.Second
+		}
+		agent.Stale = now.Sub(agent.LastReport) > time.Duration(r.staleIntervals)*interval
+		list = append(list, agent)
+	}
+	r.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WithTracking учитывает в реестре запросы агентов на отправку метрик.
+// Ответы с кодом 4xx и 5xx учитываются как ошибки.
+func (r *Registry) WithTracking(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, req)
+		r.Observe(Report{
+			ID:             req.Header.Get(service.AgentIDHeader),
+			Address:        requestAddress(req),
+			Version:        req.Header.Get(service.AgentVersionHeader),
+			Transport:      TransportHTTP,
+			ReportInterval: ParseInterval(req.Header.Get(service.AgentIntervalHeader)),
+			Group:          req.Header.Get(service.AgentGroupHeader),
+			ConfigVersion:  req.Header.Get(service.AgentConfigVersionHeader),
+			Failed:         sw.status >= http.StatusBadRequest,
+		})
+	}
+	return http.HandlerFunc(fn)
+}
+
+// ParseInterval разбирает интервал отправки в секундах, переданный агентом.
+func ParseInterval(s string) time.Duration {
+	seconds, err := strconv.Atoi(s)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func requestAddress(req *http.Request) string {
+	if ip := req.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,100 @@
+package agents
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/internal/service"
+)
+
+func TestRegistry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRegistry(3)
+	r.now = func() time.Time { return now }
+
+	r.Observe(Report{ID: "host-a", Address: "10.0.0.1", Version: "v1.0.0", Transport: TransportHTTP, ReportInterval: 2 * time.Second})
+	r.Observe(Report{ID: "host-a", Address: "10.0.0.1", Transport: TransportGRPC, Failed: true})
+	r.Observe(Report{ID: "host-b", Address: "10.0.0.2", Transport: TransportHTTP})
+	r.Observe(Report{Address: "10.0.0.3", Transport: TransportHTTP})
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    []Agent
+	}{
+		{
+			name:    "1",
+			elapsed: 5 * time.Second,
+			want: []Agent{
+				{ID: "host-a", Address: "10.0.0.1", Version: "v1.0.0", Transport: TransportGRPC, ReportInterval: 2,
+					FirstSeen: now, LastReport: now, Reports: 2, Errors: 1},
+				{ID: "host-b", Address: "10.0.0.2", Transport: TransportHTTP, FirstSeen: now, LastReport: now, Reports: 1},
+			},
+		},
+		{
+			name:    "2",
+			elapsed: 7 * time.Second,
+			want: []Agent{
+				{ID: "host-a", Address: "10.0.0.1", Version: "v1.0.0", Transport: TransportGRPC, ReportInterval: 2,
+					FirstSeen: now, LastReport: now, Reports: 2, Errors: 1, Stale: true},
+				{ID: "host-b", Address: "10.0.0.2", Transport: TransportHTTP, FirstSeen: now, LastReport: now, Reports: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.now = func() time.Time { return now.Add(tt.elapsed) }
+			assert.Equal(t, tt.want, r.List())
+		})
+	}
+}
+
+func TestWithTracking(t *testing.T) {
+	r := NewRegistry(0)
+	h := r.WithTracking(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	for _, path := range []string{"/ok", "/bad"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(service.AgentIDHeader, "host-a")
+		req.Header.Set(service.AgentVersionHeader, "v1.0.0")
+		req.Header.Set(service.AgentIntervalHeader, "10")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ok", nil))
+
+	list := r.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, "host-a", list[0].ID)
+	assert.Equal(t, "192.0.2.1", list[0].Address)
+	assert.Equal(t, "v1.0.0", list[0].Version)
+	assert.Equal(t, int64(10), list[0].ReportInterval)
+	assert.Equal(t, int64(2), list[0].Reports)
+	assert.Equal(t, int64(1), list[0].Errors)
+	assert.False(t, list[0].Stale)
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want time.Duration
+	}{
+		{name: "1", s: "10", want: 10 * time.Second},
+		{name: "2", s: "", want: 0},
+		{name: "3", s: "-1", want: 0},
+		{name: "4", s: "abc", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseInterval(tt.s))
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package agents
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// Config хранит параметры агента, задаваемые на сервере.
+// Нулевые значения означают, что агент использует локальные параметры.
+type Config struct {
+	Version        string          `json:"version,omitempty"`         // версия конфигурации, вычисляется сервером
+	PollInterval   int             `json:"poll_interval,omitempty"`   // интервал опроса в секундах
+	ReportInterval int             `json:"report_interval,omitempty"` // интервал отправки в секундах
+	RateLimit      int             `json:"rate_limit,omitempty"`      // число одновременных пакетных отправок
+	Collectors     map[string]bool `json:"collectors,omitempty"`      // включение и отключение коллекторов по имени
+}
+
+// ConfigSet задаёт параметры агентов: общие, для групп и для отдельных агентов.
+// Параметры агента переопределяют параметры группы, а те — общие.
+type ConfigSet struct {
+	Default Config            `json:"default"`
+	Groups  map[string]Config `json:"groups,omitempty"`
+	Agents  map[string]Config `json:"agents,omitempty"`
+}
+
+// ConfigStore хранит параметры агентов.
+// Методы ConfigStore безопасны для одновременного использования из нескольких горутин.
+type ConfigStore struct {
+	mu  sync.RWMutex
+	set ConfigSet
+}
+
+// NewConfigStore создаёт хранилище с заданными параметрами.
+func NewConfigStore(set ConfigSet) *ConfigStore {
+	return &ConfigStore{set: set}
+}
+
+// Set заменяет параметры агентов.
+func (s *ConfigStore) Set(set ConfigSet) {
+	s.mu.Lock()
+	s.set = set
+	s.mu.Unlock()
+}
+
+// Get возвращает параметры агентов.
+func (s *ConfigStore) Get() ConfigSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.set
+}
+
+// Resolve возвращает параметры агента id из группы group с вычисленной версией.
+// Версия меняется только при изменении итоговых параметров агента.
+func (s *ConfigStore) Resolve(id string, group string) Config {
+	s.mu.RLock()
+	cfg := merge(Config{}, s.set.Default)
+	if group != "" {
+		cfg = merge(cfg, s.set.Groups[group])
+	}
+	cfg = merge(cfg, s.set.Agents[id])
+	s.mu.RUnlock()
+	cfg.Version = version(cfg)
+	return cfg
+}
+
+// merge переносит в base ненулевые параметры override.
+func merge(base Config, override Config) Config {
+	if override.PollInterval > 0 {
+		base.PollInterval = override.PollInterval
+	}
+	if override.ReportInterval > 0 {
+		base.ReportInterval = override.ReportInterval
+	}
+	if override.RateLimit > 0 {
+		base.RateLimit = override.RateLimit
+	}
+	if len(override.Collectors) > 0 {
+		collectors := make(map[string]bool, len(base.Collectors)+len(override.Collectors))
+		for name, enabled := range base.Collectors {
+			collectors[name] = enabled
+		}
+		for name, enabled := range override.Collectors {
+			collectors[name] = enabled
+		}
+		base.Collectors = collectors
+	}
+	return base
+}
+
+func version(cfg Config) string {
+	cfg.Version = ""
+	// json.Marshal сортирует ключи map, поэтому представление детерминировано
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
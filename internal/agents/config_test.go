@@ -0,0 +1,62 @@
+package agents
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigStoreResolve(t *testing.T) {
+	store := NewConfigStore(ConfigSet{
+		Default: Config{PollInterval: 2, ReportInterval: 10, Collectors: map[string]bool{"disk": false}},
+		Groups: map[string]Config{
+			"web": {ReportInterval: 5, Collectors: map[string]bool{"disk": true, "network": true}},
+		},
+		Agents: map[string]Config{
+			"web-01": {RateLimit: 3, Collectors: map[string]bool{"network": false}},
+		},
+	})
+	tests := []struct {
+		name  string
+		id    string
+		group string
+		want  Config
+	}{
+		{
+			name: "1",
+			id:   "db-01",
+			want: Config{PollInterval: 2, ReportInterval: 10, Collectors: map[string]bool{"disk": false}},
+		},
+		{
+			name:  "2",
+			id:    "web-02",
+			group: "web",
+			want:  Config{PollInterval: 2, ReportInterval: 5, Collectors: map[string]bool{"disk": true, "network": true}},
+		},
+		{
+			name:  "3",
+			id:    "web-01",
+			group: "web",
+			want:  Config{PollInterval: 2, ReportInterval: 5, RateLimit: 3, Collectors: map[string]bool{"disk": true, "network": false}},
+		},
+	}
+	versions := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := store.Resolve(tt.id, tt.group)
+			assert.NotEmpty(t, got.Version)
+			assert.Equal(t, got.Version, store.Resolve(tt.id, tt.group).Version)
+			versions[got.Version] = true
+			got.Version = ""
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.Len(t, versions, len(tests))
+
+	// исходные параметры группы не меняются при слиянии
+	assert.Equal(t, map[string]bool{"disk": true, "network": true}, store.Get().Groups["web"].Collectors)
+
+	before := store.Resolve("db-01", "")
+	store.Set(ConfigSet{Default: Config{PollInterval: 2, ReportInterval: 20}})
+	assert.NotEqual(t, before.Version, store.Resolve("db-01", "").Version)
+}
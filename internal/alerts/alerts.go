@@ -0,0 +1,237 @@
+// Package alerts проверяет значения метрик по правилам оповещения
+// и отправляет уведомления о срабатываниях на HTTP-адрес.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/storage"
+)
+
+// состояния оповещения
+const (
+	StatePending  = "pending"  // условие выполняется, но меньше заданного времени
+	StateFiring   = "firing"   // условие выполняется заданное время
+	StateResolved = "resolved" // условие перестало выполняться после срабатывания
+)
+
+// DefaultInterval — интервал проверки правил по умолчанию.
+const DefaultInterval = 10 * time.Second
+
+// ResolvedRetention — время, в течение которого завершившееся оповещение остаётся в списке.
+const ResolvedRetention = 15 * time.Minute
+
+// Alert хранит состояние оповещения по правилу для одного агента.
+type Alert struct {
+	Rule       string     `json:"rule"`                  // имя правила
+	Expr       string     `json:"expr"`                  // условие правила
+	Source     string     `json:"source,omitempty"`      // идентификатор агента
+	State      string     `json:"state"`                 // pending, firing или resolved
+	Value      float64    `json:"value"`                 // последнее проверенное значение
+	ActiveAt   time.Time  `json:"active_at"`             // время, с которого выполняется условие
+	FiredAt    *time.Time `json:"fired_at,omitempty"`    // время срабатывания
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"` // время, когда условие перестало выполняться
+}
+
+// Source — хранилище, из которого читаются значения метрик.
+type Source interface {
+	Records(ctx context.Context) ([]storage.Record, error)
+}
+
+type alertKey struct {
+	rule   string
+	source string
+}
+
+type sample struct {
+	value float64
+	time  time.Time
+}
+
+// Engine проверяет правила оповещения и хранит состояние оповещений.
+// Методы Engine безопасны для одновременного использования из нескольких горутин.
+type Engine struct {
+	mu         sync.RWMutex
+	conditions []condition
+	source     Source
+	notifier   *Notifier
+	interval   time.Duration
+	alerts     map[alertKey]*Alert
+	samples    map[alertKey]sample
+	queue      chan []Alert
+	now        func() time.Time
+}
+
+// New разбирает правила и создаёт проверяющий их механизм.
+// Если notifier равен nil, уведомления не отправляются.
+func New(rules []Rule, source Source, notifier *Notifier, interval time.Duration) (*Engine, error) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	e := &Engine{
+		source:   source,
+		notifier: notifier,
+		interval: interval,
+		alerts:   make(map[alertKey]*Alert),
+		samples:  make(map[alertKey]sample),
+		queue:    make(chan []Alert, 16),
+		now:      time.Now,
+	}
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		c, err := parse(rule)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %d: %w", i, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %d: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+		e.conditions = append(e.conditions, c)
+	}
+	return e, nil
+}
+
+// Run проверяет правила с заданным интервалом и отправляет уведомления до отмены ctx.
+func (e *Engine) Run(ctx context.Context) {
+	go e.sendNotifications(ctx)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := e.Evaluate(ctx); err != nil {
+			logger.Warnf("Alerts evaluation error: " + err.Error())
+		}
+	}
+}
+
+// Evaluate однократно проверяет все правила и ставит в очередь уведомления
+// о сработавших и завершившихся оповещениях.
+func (e *Engine) Evaluate(ctx context.Context) error {
+	records, err := e.source.Records(ctx)
+	if err != nil {
+		return err
+	}
+	now := e.now()
+	active := make(map[alertKey]bool)
+	seen := make(map[alertKey]bool)
+	var changed []Alert
+
+	e.mu.Lock()
+	for _, c := range e.conditions {
+		for _, r := range records {
+			if !c.matches(r) {
+				continue
+			}
+			key := alertKey{rule: c.Name, source: r.Source}
+			seen[key] = true
+			value, ok := e.value(c, key, r, now)
+			if !ok || !c.holds(value) {
+				continue
+			}
+			active[key] = true
+			a, ok := e.alerts[key]
+			if !ok || a.State == StateResolved {
+				a = &Alert{Rule: c.Name, Expr: c.Expr, Source: r.Source, State: StatePending, ActiveAt: now}
+				e.alerts[key] = a
+			}
+			a.Value = value
+			if a.State == StatePending && now.Sub(a.ActiveAt) >= c.hold {
+				fired := now
+				a.State, a.FiredAt = StateFiring, &fired
+				changed = append(changed, *a)
+			}
+		}
+	}
+	for key, a := range e.alerts {
+		if active[key] {
+			continue
+		}
+		if a.State == StateResolved {
+			if now.Sub(*a.ResolvedAt) >= ResolvedRetention {
+				delete(e.alerts, key)
+			}
+			continue
+		}
+		if a.State == StatePending {
+			delete(e.alerts, key)
+			continue
+		}
+		resolved := now
+		a.State, a.ResolvedAt = StateResolved, &resolved
+		changed = append(changed, *a)
+	}
+	// значения удалённых и переставших поступать метрик не хранятся
+	for key := range e.samples {
+		if !seen[key] {
+			delete(e.samples, key)
+		}
+	}
+	e.mu.Unlock()
+
+	if len(changed) > 0 && e.notifier != nil {
+		select {
+		case e.queue <- changed:
+		default:
+			logger.Warnf("Alerts notification queue is full, notification dropped")
+		}
+	}
+	return nil
+}
+
+// value возвращает проверяемое значение метрики. Для правил со скоростью изменения
+// значение вычисляется по предыдущей проверке, при первой проверке его нет.
+func (e *Engine) value(c condition, key alertKey, r storage.Record, now time.Time) (float64, bool) {
+	value := r.Value
+	if r.MType == "counter" {
+		value = float64(r.Delta)
+	}
+	if !c.rate {
+		return value, true
+	}
+	prev, ok := e.samples[key]
+	e.samples[key] = sample{value: value, time: now}
+	if !ok || !now.After(prev.time) {
+		return 0, false
+	}
+	return (value - prev.value) / now.Sub(prev.time).Seconds(), true
+}
+
+func (e *Engine) sendNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case alerts := <-e.queue:
+			if err := e.notifier.Send(ctx, alerts); err != nil {
+				logger.Warnf("Alerts notification error: " + err.Error())
+			}
+		}
+	}
+}
+
+// List возвращает оповещения, упорядоченные по имени правила и агенту.
+func (e *Engine) List() []Alert {
+	e.mu.RLock()
+	list := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		list = append(list, *a)
+	}
+	e.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rule != list[j].Rule {
+			return list[i].Rule < list[j].Rule
+		}
+		return list[i].Source < list[j].Source
+	})
+	return list
+}
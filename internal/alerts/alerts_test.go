@@ -0,0 +1,253 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/internal/storage"
+)
+
+// testSource возвращает заданные значения метрик.
+type testSource struct {
+	mu      sync.Mutex
+	records []storage.Record
+}
+
+func (s *testSource) Records(ctx context.Context) ([]storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]storage.Record(nil), s.records...), nil
+}
+
+func (s *testSource) set(records ...storage.Record) {
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    condition
+		wantErr bool
+	}{
+		{
+			name: "1",
+			expr: "gauge FreeMemory < 500MB for 2m",
+			want: condition{mtype: "gauge", metric: "FreeMemory", op: "<", threshold: 500 << 20, hold: 2 * time.Minute},
+		},
+		{
+			name: "2",
+			expr: "counter PollCount rate == 0 for 5m",
+			want: condition{mtype: "counter", metric: "PollCount", rate: true, op: "==", threshold: 0, hold: 5 * time.Minute},
+		},
+		{
+			name: "3",
+			expr: "gauge CPUutilization1 >= 90.5",
+			want: condition{mtype: "gauge", metric: "CPUutilization1", op: ">=", threshold: 90.5},
+		},
+		{name: "4", expr: "gauge FreeMemory <", wantErr: true},
+		{name: "5", expr: "histogram FreeMemory < 1", wantErr: true},
+		{name: "6", expr: "gauge FreeMemory ~ 1", wantErr: true},
+		{name: "7", expr: "gauge FreeMemory < 1XB", wantErr: true},
+		{name: "8", expr: "gauge FreeMemory < 1 during 2m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := Rule{Name: "test", Expr: tt.expr}
+			got, err := parse(rule)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			tt.want.Rule = rule
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	source := &testSource{}
+	e, err := New([]Rule{{Name: "LowMemory", Expr: "gauge FreeMemory < 500MB for 2m"}}, source, nil, 0)
+	require.NoError(t, err)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	steps := []struct {
+		name  string
+		after time.Duration
+		value float64
+		want  []string // состояния оповещений агентов a и b
+	}{
+		{name: "1", value: 100 << 20, want: []string{StatePending}},
+		{name: "2", after: time.Minute, value: 100 << 20, want: []string{StatePending}},
+		{name: "3", after: time.Minute, value: 100 << 20, want: []string{StateFiring}},
+		{name: "4", after: time.Minute, value: 900 << 20, want: []string{StateResolved}},
+		{name: "5", after: time.Minute, value: 100 << 20, want: []string{StatePending}},
+		{name: "6", after: time.Minute, value: 900 << 20, want: []string{}},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			now = now.Add(step.after)
+			source.set(
+				storage.Record{Source: "a", MType: "gauge", Name: "FreeMemory", Value: step.value},
+				storage.Record{Source: "b", MType: "gauge", Name: "FreeMemory", Value: 4 << 30},
+			)
+			require.NoError(t, e.Evaluate(ctx))
+			got := make([]string, 0)
+			for _, a := range e.List() {
+				assert.Equal(t, "a", a.Source)
+				got = append(got, a.State)
+			}
+			assert.Equal(t, step.want, got)
+		})
+	}
+}
+
+func TestEvaluateResolvedRetention(t *testing.T) {
+	source := &testSource{}
+	e, err := New([]Rule{{Name: "LowMemory", Expr: "gauge FreeMemory < 500MB"}}, source, nil, 0)
+	require.NoError(t, err)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	source.set(storage.Record{Source: "a", MType: "gauge", Name: "FreeMemory", Value: 100 << 20})
+	require.NoError(t, e.Evaluate(ctx))
+	source.set(storage.Record{Source: "a", MType: "gauge", Name: "FreeMemory", Value: 900 << 20})
+	require.NoError(t, e.Evaluate(ctx))
+	list := e.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, StateResolved, list[0].State)
+
+	now = now.Add(ResolvedRetention - time.Second)
+	require.NoError(t, e.Evaluate(ctx))
+	assert.Len(t, e.List(), 1)
+
+	// завершившееся оповещение удаляется по истечении ResolvedRetention
+	now = now.Add(time.Second)
+	require.NoError(t, e.Evaluate(ctx))
+	assert.Empty(t, e.List())
+}
+
+func TestEvaluateRate(t *testing.T) {
+	source := &testSource{}
+	e, err := New([]Rule{{Name: "AgentStopped", Expr: "counter PollCount rate == 0"}}, source, nil, 0)
+	require.NoError(t, err)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	source.set(storage.Record{Source: "a", MType: "counter", Name: "PollCount", Delta: 10})
+	require.NoError(t, e.Evaluate(ctx))
+	// при первой проверке скорость изменения неизвестна
+	assert.Empty(t, e.List())
+
+	now = now.Add(10 * time.Second)
+	source.set(storage.Record{Source: "a", MType: "counter", Name: "PollCount", Delta: 20})
+	require.NoError(t, e.Evaluate(ctx))
+	assert.Empty(t, e.List())
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, e.Evaluate(ctx))
+	list := e.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, StateFiring, list[0].State)
+	assert.Len(t, e.samples, 1)
+
+	// значения метрики, переставшей поступать, удаляются
+	source.set()
+	now = now.Add(10 * time.Second)
+	require.NoError(t, e.Evaluate(ctx))
+	assert.Empty(t, e.samples)
+}
+
+func TestNewDuplicate(t *testing.T) {
+	_, err := New([]Rule{
+		{Name: "LowMemory", Expr: "gauge FreeMemory < 500MB"},
+		{Name: "LowMemory", Expr: "gauge FreeMemory < 100MB"},
+	}, &testSource{}, nil, 0)
+	assert.Error(t, err)
+}
+
+// webhook запоминает полученные уведомления и отвечает ошибкой на первые fail запросов.
+type webhook struct {
+	mu       sync.Mutex
+	fail     int
+	requests int
+	received []Alert
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	if h.fail > 0 {
+		h.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var n Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.received = append(h.received, n.Alerts...)
+}
+
+func (h *webhook) states() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	states := make([]string, 0, len(h.received))
+	for _, a := range h.received {
+		states = append(states, a.State)
+	}
+	return states
+}
+
+func TestNotifierRetry(t *testing.T) {
+	h := &webhook{fail: 2}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	n := NewNotifier(ts.URL)
+	n.Delay = time.Millisecond
+
+	require.NoError(t, n.Send(context.Background(), []Alert{{Rule: "LowMemory", State: StateFiring}}))
+	assert.Equal(t, 3, h.requests)
+	assert.Equal(t, []string{StateFiring}, h.states())
+
+	h.fail = 5
+	assert.Error(t, n.Send(context.Background(), []Alert{{Rule: "LowMemory", State: StateResolved}}))
+}
+
+func TestRunNotifies(t *testing.T) {
+	h := &webhook{}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	source := &testSource{}
+	source.set(storage.Record{Source: "a", MType: "gauge", Name: "FreeMemory", Value: 1})
+	e, err := New([]Rule{{Name: "LowMemory", Expr: "gauge FreeMemory < 500MB"}}, source, NewNotifier(ts.URL), 10*time.Millisecond)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{StateFiring}, h.states())
+	}, time.Second, 10*time.Millisecond)
+	source.set(storage.Record{Source: "a", MType: "gauge", Name: "FreeMemory", Value: 1 << 30})
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{StateFiring, StateResolved}, h.states())
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,65 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+)
+
+// Notification — тело запроса, отправляемого на адрес уведомлений.
+type Notification struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// Notifier отправляет уведомления на HTTP-адрес с повторами при ошибках.
+type Notifier struct {
+	URL      string
+	Client   *http.Client
+	Attempts uint
+	Delay    time.Duration
+}
+
+// NewNotifier создаёт отправителя уведомлений на адрес url.
+func NewNotifier(url string) *Notifier {
+	return &Notifier{
+		URL:      url,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Attempts: 3,
+		Delay:    time.Second,
+	}
+}
+
+// Send отправляет уведомление об изменении состояния оповещений.
+// Ответы с кодом 4xx и 5xx считаются ошибкой и приводят к повтору.
+func (n *Notifier) Send(ctx context.Context, alerts []Alert) error {
+	body, err := json.Marshal(Notification{Alerts: alerts})
+	if err != nil {
+		return err
+	}
+	return retry.Do(func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+		if err != nil {
+			return retry.Unrecoverable(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+		return nil
+	},
+		retry.Attempts(n.Attempts),
+		retry.Delay(n.Delay),
+		retry.Context(ctx),
+		retry.LastErrorOnly(true),
+	)
+}
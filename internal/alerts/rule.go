@@ -0,0 +1,116 @@
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"musthave-metrics/internal/storage"
+)
+
+// Rule описывает правило оповещения в конфигурации сервера.
+type Rule struct {
+	Name   string `json:"name"`   // имя правила
+	Expr   string `json:"expr"`   // условие, например "gauge FreeMemory < 500MB for 2m" или "counter PollCount rate == 0 for 5m"
+	Source string `json:"source"` // идентификатор агента, пустой — каждый агент отдельно
+}
+
+// condition — разобранное условие правила.
+type condition struct {
+	Rule
+	mtype     string
+	metric    string
+	rate      bool // сравнивается изменение значения в секунду
+	op        string
+	threshold float64
+	hold      time.Duration // сколько условие должно выполняться до срабатывания
+}
+
+// множители единиц измерения порога
+var units = map[string]float64{
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// parse разбирает условие вида "<type> <metric> [rate] <op> <threshold>[unit] [for <duration>]".
+func parse(rule Rule) (condition, error) {
+	c := condition{Rule: rule}
+	if rule.Name == "" {
+		return c, errors.New("empty name")
+	}
+	fields := strings.Fields(rule.Expr)
+	if len(fields) < 4 {
+		return c, fmt.Errorf("bad expression %q", rule.Expr)
+	}
+	c.mtype, c.metric, fields = fields[0], fields[1], fields[2:]
+	if c.mtype != "gauge" && c.mtype != "counter" {
+		return c, fmt.Errorf("unknown metric type %q", c.mtype)
+	}
+	if fields[0] == "rate" {
+		c.rate, fields = true, fields[1:]
+	}
+	if len(fields) != 2 && len(fields) != 4 {
+		return c, fmt.Errorf("bad expression %q", rule.Expr)
+	}
+	c.op = fields[0]
+	switch c.op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return c, fmt.Errorf("unknown operator %q", c.op)
+	}
+	threshold, err := parseThreshold(fields[1])
+	if err != nil {
+		return c, err
+	}
+	c.threshold = threshold
+	if len(fields) == 4 {
+		if fields[2] != "for" {
+			return c, fmt.Errorf("bad expression %q", rule.Expr)
+		}
+		if c.hold, err = time.ParseDuration(fields[3]); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func parseThreshold(s string) (float64, error) {
+	multiplier := 1.0
+	for unit, m := range units {
+		if strings.HasSuffix(strings.ToUpper(s), unit) {
+			s, multiplier = s[:len(s)-len(unit)], m
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad threshold %q", s)
+	}
+	return value * multiplier, nil
+}
+
+// matches сообщает, относится ли значение метрики к правилу.
+func (c condition) matches(r storage.Record) bool {
+	return r.MType == c.mtype && r.Name == c.metric && (c.Source == "" || r.Source == c.Source)
+}
+
+// holds сообщает, выполняется ли условие для значения.
+func (c condition) holds(value float64) bool {
+	switch c.op {
+	case "<":
+		return value < c.threshold
+	case "<=":
+		return value <= c.threshold
+	case ">":
+		return value > c.threshold
+	case ">=":
+		return value >= c.threshold
+	case "==":
+		return value == c.threshold
+	}
+	return value != c.threshold
+}
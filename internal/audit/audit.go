@@ -0,0 +1,99 @@
+// Package audit ведёт журнал административных операций с метриками.
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"musthave-metrics/internal/logger"
+)
+
+// Size — число последних записей журнала, хранимых в памяти.
+const Size = 1000
+
+// операции с метриками
+const (
+	ActionDelete = "delete"
+	ActionReset  = "reset"
+)
+
+// Event — запись журнала аудита.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`         // delete или reset
+	MType    string    `json:"type,omitempty"` // тип метрик, пустой — оба типа
+	Pattern  string    `json:"pattern"`        // имя метрики или шаблон имени
+	Source   string    `json:"source,omitempty"`
+	Caller   string    `json:"caller"`   // адрес вызывающего
+	Via      string    `json:"via"`      // http или grpc
+	Affected int64     `json:"affected"` // число удалённых или сброшенных значений
+	Error    string    `json:"error,omitempty"`
+}
+
+// Trail хранит последние записи журнала в памяти и дописывает их в файл.
+// Методы Trail безопасны для одновременного использования из нескольких горутин.
+type Trail struct {
+	mu     sync.Mutex
+	file   *os.File
+	events []Event
+}
+
+// New создаёт журнал, дописывающий записи в файл path в формате JSON Lines.
+// При пустом path записи хранятся только в памяти.
+func New(path string) (*Trail, error) {
+	t := &Trail{}
+	if path == "" {
+		return t, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	t.file = f
+	return t, nil
+}
+
+// Record добавляет запись в журнал.
+func (t *Trail) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	logger.Infof("Audit: " + e.Action + " " + e.MType + " " + e.Pattern + " source=" + e.Source +
+		" caller=" + e.Caller + " via=" + e.Via + " affected=" + strconv.FormatInt(e.Affected, 10))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.events) == Size {
+		copy(t.events, t.events[1:])
+		t.events = t.events[:Size-1]
+	}
+	t.events = append(t.events, e)
+	if t.file == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		logger.Warnf("Audit error: " + err.Error())
+		return
+	}
+	if _, err := t.file.Write(append(data, '\n')); err != nil {
+		logger.Warnf("Audit error: " + err.Error())
+	}
+}
+
+// List возвращает записи журнала в порядке времени.
+func (t *Trail) List() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(make([]Event, 0, len(t.events)), t.events...)
+}
+
+// Close закрывает файл журнала.
+func (t *Trail) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	return t.file.Close()
+}
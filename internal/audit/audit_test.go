@@ -0,0 +1,51 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	trail, err := New(path)
+	require.NoError(t, err)
+	trail.Record(Event{Action: ActionDelete, MType: "gauge", Pattern: "Alloc", Caller: "127.0.0.1:1", Via: "http", Affected: 2})
+	trail.Record(Event{Action: ActionReset, Pattern: "Poll*", Caller: "127.0.0.1:2", Via: "grpc", Error: "invalid admin token"})
+	require.NoError(t, trail.Close())
+
+	list := trail.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, ActionDelete, list[0].Action)
+	assert.False(t, list[0].Time.IsZero())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "invalid admin token", events[1].Error)
+	assert.Equal(t, int64(2), events[0].Affected)
+}
+
+func TestTrailSize(t *testing.T) {
+	trail, err := New("")
+	require.NoError(t, err)
+	for i := 0; i < Size+10; i++ {
+		trail.Record(Event{Action: ActionDelete, Pattern: "Alloc", Affected: int64(i)})
+	}
+	list := trail.List()
+	assert.Len(t, list, Size)
+	assert.Equal(t, int64(10), list[0].Affected)
+}
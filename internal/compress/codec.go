@@ -0,0 +1,148 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// кодеки в заголовках Accept-Encoding и Content-Encoding
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Brotli = "br"
+	// Identity — данные без сжатия.
+	Identity = "identity"
+)
+
+// encoder — потоковый кодировщик, который можно использовать повторно.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// decoder — потоковый декодер, который можно использовать повторно.
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+}
+
+// кодировщики и декодеры создаются заново только при пустых пулах:
+// буферы zstd и brotli занимают сотни килобайт
+var (
+	encoders = map[string]*sync.Pool{
+		Gzip: {New: func() any {
+			return gzip.NewWriter(nil)
+		}},
+		Zstd: {New: func() any {
+			// без параметров zstd.NewWriter не возвращает ошибку
+			zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return zw
+		}},
+		Brotli: {New: func() any {
+			return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+		}},
+	}
+	decoders = map[string]*sync.Pool{
+		Gzip: {New: func() any {
+			return new(gzip.Reader)
+		}},
+		Zstd: {New: func() any {
+			// с одним потоком декодер не запускает горутин и не требует Close
+			zr, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+			return zr
+		}},
+		Brotli: {New: func() any {
+			return brotli.NewReader(nil)
+		}},
+	}
+)
+
+// Normalize приводит имя кодека к виду из Supported; x-gzip и none также допускаются.
+func Normalize(codec string) string {
+	switch codec = strings.ToLower(strings.TrimSpace(codec)); codec {
+	case "x-gzip":
+		return Gzip
+	case "", "none":
+		return Identity
+	}
+	return codec
+}
+
+// Supported сообщает, поддерживается ли кодек.
+func Supported(codec string) bool {
+	_, ok := encoders[Normalize(codec)]
+	return ok || Normalize(codec) == Identity
+}
+
+// ParseCodecs разбирает список кодеков через запятую.
+func ParseCodecs(list string) ([]string, error) {
+	var codecs []string
+	for _, codec := range strings.Split(list, ",") {
+		codec = Normalize(codec)
+		if codec == Identity {
+			continue
+		}
+		if !Supported(codec) {
+			return nil, fmt.Errorf("unknown compression codec %q, use gzip, zstd or br", codec)
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+func getEncoder(codec string, w io.Writer) encoder {
+	e := encoders[codec].Get().(encoder)
+	e.Reset(w)
+	return e
+}
+
+func putEncoder(codec string, e encoder) {
+	e.Reset(nil)
+	encoders[codec].Put(e)
+}
+
+func getDecoder(codec string, r io.Reader) (decoder, error) {
+	d := decoders[codec].Get().(decoder)
+	if err := d.Reset(r); err != nil {
+		putDecoder(codec, d)
+		return nil, err
+	}
+	return d, nil
+}
+
+// putDecoder возвращает декодер в пул, предварительно отвязав его от тела запроса.
+// gzip.Reader не принимает nil, поэтому декодер переключается на пустой поток;
+// ошибка чтения заголовка пустого потока не важна.
+func putDecoder(codec string, d decoder) {
+	_ = d.Reset(bytes.NewReader(nil))
+	decoders[codec].Put(d)
+}
+
+// Compress сжимает data кодеком codec. С Identity данные возвращаются без изменений.
+func Compress(codec string, data []byte) ([]byte, error) {
+	codec = Normalize(codec)
+	if codec == Identity {
+		return data, nil
+	}
+	if !Supported(codec) {
+		return nil, fmt.Errorf("unknown compression codec %q", codec)
+	}
+	var buf bytes.Buffer
+	e := getEncoder(codec, &buf)
+	defer putEncoder(codec, e)
+	if _, err := e.Write(data); err != nil {
+		return nil, err
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
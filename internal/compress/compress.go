@@ -1,45 +1,121 @@
+// Package compress предназначен для сжатия ответов и распаковки запросов HTTP.
 package compress
 
 import (
-	"compress/gzip"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"musthave-metrics/internal/limits"
 )
 
+// DefaultMinSize — наименьший размер ответа в байтах, который сжимается по умолчанию.
+// Меньшие ответы после сжатия почти не уменьшаются.
+const DefaultMinSize = 512
+
+// DefaultMaxDecodedSize — наибольший размер распакованного тела запроса по умолчанию, 32 МиБ.
+const DefaultMaxDecodedSize = 32 << 20
+
+// DefaultContentTypes — типы ответов, которые сжимаются по умолчанию.
+var DefaultContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/x-protobuf",
+	"application/msgpack",
+}
+
+// Config — настройки сжатия.
+type Config struct {
+	// Encodings — кодеки ответов в порядке предпочтения сервера при равных q-значениях в Accept-Encoding.
+	Encodings []string
+	// MinSize — наименьший размер ответа в байтах, который сжимается.
+	MinSize int
+	// ContentTypes — типы ответов, которые сжимаются; text/* разрешает все подтипы.
+	ContentTypes []string
+	// MaxDecodedSize — наибольший размер тела запроса после распаковки, 0 — без ограничения.
+	MaxDecodedSize int64
+}
+
+// DefaultConfig возвращает настройки по умолчанию: zstd, br и gzip в порядке предпочтения.
+func DefaultConfig() Config {
+	return Config{
+		Encodings:      []string{Zstd, Brotli, Gzip},
+		MinSize:        DefaultMinSize,
+		ContentTypes:   DefaultContentTypes,
+		MaxDecodedSize: DefaultMaxDecodedSize,
+	}
+}
+
+// Compression сжимает ответы и распаковывает запросы по настройкам Config.
+type Compression struct {
+	cfg Config
+}
+
+// New создаёт middleware сжатия. Неизвестные кодеки в cfg.Encodings не используются.
+func New(cfg Config) *Compression {
+	encodings := make([]string, 0, len(cfg.Encodings))
+	for _, e := range cfg.Encodings {
+		if e = Normalize(e); e != Identity && Supported(e) {
+			encodings = append(encodings, e)
+		}
+	}
+	cfg.Encodings = encodings
+	return &Compression{cfg: cfg}
+}
+
+// WithGzipEncoding сжимает ответы только gzip, остальные настройки — по умолчанию.
 func WithGzipEncoding(h http.Handler) http.Handler {
+	cfg := DefaultConfig()
+	cfg.Encodings = []string{Gzip}
+	return New(cfg).WithCompression(h)
+}
+
+// WithCompression сжимает ответы кодеком, выбранным по Accept-Encoding, и распаковывает тела запросов
+// по Content-Encoding. Запрос с неизвестным кодеком отклоняется с кодом 415, распакованное тело
+// больше MaxDecodedSize не читается дальше предела: обработчик получает ошибку *http.MaxBytesError,
+// а превышение учитывается в limits.Rejected.
+func (c *Compression) WithCompression(h http.Handler) http.Handler {
 	compressFunc := func(w http.ResponseWriter, r *http.Request) {
 		// по умолчанию устанавливаем оригинальный http.ResponseWriter как тот,
 		// который будем передавать следующей функции
 		ow := w
 
-		// проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		supportsGzip := strings.Contains(acceptEncoding, "gzip")
-		// enableCompress := strings.Contains(ContentTypesForCompress, w.Header().Get("Content-Type"))
-		if supportsGzip {
-			// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
-			cw := newCompressWriter(w)
-			cw.Header().Set("Content-Encoding", "gzip")
-			// меняем оригинальный http.ResponseWriter на новый
-			ow = cw
-			// не забываем отправить клиенту все сжатые данные после завершения middleware
-			defer cw.Close()
+		// выбираем кодек, который клиент умеет распаковывать
+		if len(c.cfg.Encodings) > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if encoding := negotiate(r.Header.Get("Accept-Encoding"), c.cfg.Encodings); encoding != "" {
+				// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
+				cw := newCompressWriter(w, encoding, c.cfg)
+				ow = cw
+				// не забываем отправить клиенту все сжатые данные после завершения middleware
+				defer cw.Close()
+			}
 		}
 
-		// проверяем, что клиент отправил серверу сжатые данные в формате gzip
-		contentEncoding := r.Header.Get("Content-Encoding")
-		sendsGzip := strings.EqualFold(contentEncoding, "gzip")
-		if sendsGzip {
+		// проверяем, что клиент отправил серверу сжатые данные
+		if encoding := Normalize(r.Header.Get("Content-Encoding")); encoding != Identity {
+			if !Supported(encoding) {
+				http.Error(w, "unsupported content encoding "+encoding, http.StatusUnsupportedMediaType)
+				return
+			}
 			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
-			cr, err := newCompressReader(r.Body)
+			cr, err := newDecompressReader(r.Body, encoding)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				http.Error(w, err.Error(), limits.Status(err))
 				return
 			}
+			defer cr.Close()
 			// меняем тело запроса на новое
 			r.Body = cr
-			defer cr.Close()
+			if c.cfg.MaxDecodedSize > 0 {
+				r.Body = limits.Reader(w, cr, c.cfg.MaxDecodedSize, limits.Decoded)
+			}
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
 		}
 
 		// передаём управление хендлеру
@@ -48,17 +124,72 @@ func WithGzipEncoding(h http.Handler) http.Handler {
 	return http.HandlerFunc(compressFunc)
 }
 
+// negotiate выбирает кодек из encodings с наибольшим q-значением в заголовке Accept-Encoding.
+// При равных q-значениях выбирается кодек, раньше указанный в encodings. Пустая строка — без сжатия.
+func negotiate(acceptEncoding string, encodings []string) string {
+	weights := make(map[string]float64)
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = Normalize(name)
+		if name == Identity {
+			continue
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				var err error
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		weights[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, e := range encodings {
+		q, ok := weights[e]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best
+}
+
+// compressible сообщает, сжимаются ли ответы типа contentType.
+func compressible(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range allowed {
+		if prefix, ok := strings.CutSuffix(t, "*"); ok && strings.HasPrefix(mediaType, prefix) || t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
 // compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
-// сжимать передаваемые данные и выставлять правильные HTTP-заголовки
+// сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
+// Ответ копится в буфере, пока не станет ясно, сжимать ли его: по размеру, типу и коду ответа.
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w        http.ResponseWriter
+	encoding string
+	cfg      Config
+	status   int
+	buf      []byte
+	decided  bool
+	zw       encoder
 }
 
-func newCompressWriter(w http.ResponseWriter) *compressWriter {
+func newCompressWriter(w http.ResponseWriter, encoding string, cfg Config) *compressWriter {
 	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+		w:        w,
+		encoding: encoding,
+		cfg:      cfg,
 	}
 }
 
@@ -67,47 +198,135 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
+	if !c.decided {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < c.cfg.MinSize {
+			return len(p), nil
+		}
+		return len(p), c.decide(false)
+	}
+	if c.zw != nil {
+		return c.zw.Write(p)
+	}
+	return c.w.Write(p)
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
+	if c.decided {
+		c.w.WriteHeader(statusCode)
+		return
+	}
+	if c.status == 0 {
+		c.status = statusCode
 	}
-	c.w.WriteHeader(statusCode)
 }
 
-// Close закрывает gzip.Writer и досылает все данные из буфера.
+// decide выбирает, сжимать ли ответ, отправляет заголовки и накопленные данные.
+// При досылке данных из буфера (flushing) ответ сжимается независимо от размера.
+func (c *compressWriter) decide(flushing bool) error {
+	c.decided = true
+	h := c.w.Header()
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	compress := (flushing || len(c.buf) >= c.cfg.MinSize) &&
+		c.status >= http.StatusOK && c.status != http.StatusNoContent && c.status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" &&
+		compressible(h.Get("Content-Type"), c.cfg.ContentTypes)
+	if compress {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		c.zw = getEncoder(c.encoding, c.w)
+	}
+	c.w.WriteHeader(c.status)
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.zw != nil {
+		_, err = c.zw.Write(buf)
+	} else {
+		_, err = c.w.Write(buf)
+	}
+	return err
+}
+
+// FlushError досылает клиенту сжатые данные из буфера, например события потока.
+// Вызывается через http.ResponseController.
+func (c *compressWriter) FlushError() error {
+	if !c.decided {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		if err := c.decide(true); err != nil {
+			return err
+		}
+	}
+	if c.zw != nil {
+		if err := c.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(c.w).Flush()
+}
+
+// Unwrap возвращает исходный http.ResponseWriter для http.ResponseController.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// Close досылает накопленные данные, закрывает кодировщик и возвращает его в пул.
 func (c *compressWriter) Close() error {
-	return c.zw.Close()
+	if !c.decided && c.status != 0 {
+		if err := c.decide(false); err != nil {
+			return err
+		}
+	}
+	if c.zw == nil {
+		return nil
+	}
+	err := c.zw.Close()
+	putEncoder(c.encoding, c.zw)
+	c.zw = nil
+	return err
 }
 
 // compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
 // декомпрессировать получаемые от клиента данные
 type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+	r        io.ReadCloser
+	zr       decoder
+	encoding string
 }
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
+func newDecompressReader(r io.ReadCloser, encoding string) (*compressReader, error) {
+	zr, err := getDecoder(encoding, r)
 	if err != nil {
 		return nil, err
 	}
 
 	return &compressReader{
-		r:  r,
-		zr: zr,
+		r:        r,
+		zr:       zr,
+		encoding: encoding,
 	}, nil
 }
 
-func (c compressReader) Read(p []byte) (n int, err error) {
+func (c *compressReader) Read(p []byte) (n int, err error) {
 	return c.zr.Read(p)
 }
 
+// Close закрывает тело запроса и возвращает декодер в пул.
 func (c *compressReader) Close() error {
-	if err := c.r.Close(); err != nil {
-		return err
+	if c.zr == nil {
+		return nil
 	}
-	return c.zr.Close()
+	putDecoder(c.encoding, c.zr)
+	c.zr = nil
+	return c.r.Close()
 }
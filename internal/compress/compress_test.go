@@ -1,11 +1,17 @@
 package compress
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWithGzipEncoding(t *testing.T) {
@@ -71,9 +77,10 @@ func TestWithGzipEncoding(t *testing.T) {
 	}
 }
 
-func Test_newCompressReader(t *testing.T) {
+func Test_newDecompressReader(t *testing.T) {
 	type args struct {
-		r io.ReadCloser
+		r        io.ReadCloser
+		encoding string
 	}
 	tests := []struct {
 		name    string
@@ -83,18 +90,191 @@ func Test_newCompressReader(t *testing.T) {
 	}{
 		{
 			name:    "1",
-			args:    args{r: http.NoBody}, // nolint
+			args:    args{r: http.NoBody, encoding: Gzip}, // nolint
 			want:    nil,
 			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := newCompressReader(tt.args.r)
+			_, err := newDecompressReader(tt.args.r, tt.args.encoding)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("newCompressReader() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("newDecompressReader() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 		})
 	}
 }
+
+func TestPutDecoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec string
+	}{
+		{name: "1", codec: Gzip},
+		{name: "2", codec: Zstd},
+		{name: "3", codec: Brotli},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Compress(tt.codec, []byte("payload"))
+			require.NoError(t, err)
+			d, err := getDecoder(tt.codec, bytes.NewReader(data))
+			require.NoError(t, err)
+			putDecoder(tt.codec, d)
+			// декодер в пуле не читает прежнее тело запроса
+			n, _ := d.Read(make([]byte, 16))
+			assert.Zero(t, n)
+		})
+	}
+}
+
+func TestCompressWriterFlush(t *testing.T) {
+	flushed := make(chan struct{})
+	ts := httptest.NewServer(WithGzipEncoding(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "event")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() error = %v", err)
+		}
+		// ответ не завершается, пока клиент не получит отправленные данные
+		<-flushed
+	})))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer res.Body.Close()
+	buf := make([]byte, len("event"))
+	_, err = io.ReadFull(res.Body, buf)
+	close(flushed)
+	if err != nil || string(buf) != "event" {
+		t.Errorf("got %q, %v before the handler finished", buf, err)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	encodings := []string{Zstd, Brotli, Gzip}
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "1", acceptEncoding: "", want: ""},
+		{name: "2", acceptEncoding: "gzip", want: Gzip},
+		{name: "3", acceptEncoding: "gzip, deflate, br, zstd", want: Zstd},
+		{name: "4", acceptEncoding: "gzip;q=1.0, br;q=0.5, zstd;q=0.1", want: Gzip},
+		{name: "5", acceptEncoding: "*;q=0.2, zstd;q=0", want: Brotli},
+		{name: "6", acceptEncoding: "identity", want: ""},
+		{name: "7", acceptEncoding: "X-GZIP; q=0.7, deflate", want: Gzip},
+		{name: "8", acceptEncoding: "br;q=bad, gzip;q=0.1", want: Gzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiate(tt.acceptEncoding, encodings))
+		})
+	}
+}
+
+func TestWithCompression(t *testing.T) {
+	large := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1}`, 50)
+	c := New(Config{
+		Encodings:      []string{Zstd, Brotli, Gzip},
+		MinSize:        100,
+		ContentTypes:   []string{"application/json", "text/*"},
+		MaxDecodedSize: int64(len(large)),
+	})
+	ts := httptest.NewServer(c.WithCompression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if len(body) == 0 {
+			body = []byte(large)
+		}
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body) //nolint
+	})))
+	defer ts.Close()
+
+	compressed := func(codec string, data string) []byte {
+		body, err := Compress(codec, []byte(data))
+		require.NoError(t, err)
+		return body
+	}
+	tests := []struct {
+		name            string
+		acceptEncoding  string
+		contentEncoding string
+		contentType     string
+		body            []byte
+		wantStatus      int
+		wantEncoding    string
+		wantBody        string
+	}{
+		{name: "1", acceptEncoding: "gzip", contentType: "application/json", wantStatus: http.StatusOK, wantEncoding: Gzip, wantBody: large},
+		{name: "2", acceptEncoding: "zstd, gzip;q=0.5", contentType: "application/json", wantStatus: http.StatusOK, wantEncoding: Zstd, wantBody: large},
+		{name: "3", acceptEncoding: "br", contentType: "text/html; charset=utf-8", wantStatus: http.StatusOK, wantEncoding: Brotli, wantBody: large},
+		{name: "4", acceptEncoding: "gzip", contentType: "image/png", wantStatus: http.StatusOK, wantBody: large},
+		{name: "5", acceptEncoding: "gzip", contentType: "application/json", body: []byte("small"), wantStatus: http.StatusOK, wantBody: "small"},
+		{name: "6", acceptEncoding: "identity", contentType: "application/json", wantStatus: http.StatusOK, wantBody: large},
+		{name: "7", contentEncoding: Zstd, contentType: "application/json", body: compressed(Zstd, large), wantStatus: http.StatusOK, wantBody: large},
+		{name: "8", contentEncoding: Brotli, contentType: "application/json", body: compressed(Brotli, "small"), wantStatus: http.StatusOK, wantBody: "small"},
+		{name: "9", contentEncoding: Gzip, body: compressed(Gzip, large+"!"), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "10", contentEncoding: "deflate", body: []byte("data"), wantStatus: http.StatusUnsupportedMediaType},
+		{name: "11", contentEncoding: Gzip, body: []byte("not gzip"), wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"?"+url.Values{"type": {tt.contentType}}.Encode(), bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			// заголовок Accept-Encoding, заданный явно, отключает распаковку ответа в http.Transport
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			if tt.acceptEncoding == "" {
+				req.Header.Set("Accept-Encoding", Identity)
+			}
+			if tt.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			res, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantEncoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			if tt.wantBody == "" {
+				return
+			}
+			var body io.Reader = res.Body
+			if tt.wantEncoding != "" {
+				cr, err := newDecompressReader(res.Body, tt.wantEncoding)
+				require.NoError(t, err)
+				defer cr.Close()
+				body = cr
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(data))
+		})
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	data := []byte(strings.Repeat(`{"id":"Alloc","type":"gauge","value":1234.5678}`, 200))
+	for _, codec := range []string{Gzip, Zstd, Brotli} {
+		compressed, err := Compress(codec, data)
+		require.NoError(b, err)
+		b.Run(codec, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Compress(codec, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(compressed)), "compressed-B")
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Package idempotency запоминает результаты применённых пакетов метрик,
+// чтобы повторная доставка пакета с тем же ключом не применялась ещё раз.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/service"
+)
+
+// DefaultTTL — время хранения результатов по умолчанию.
+const DefaultTTL = 10 * time.Minute
+
+// Lease — время, в течение которого ключ обрабатываемого пакета занят.
+// Если сервер остановился, не завершив обработку, ключ освобождается по его истечении.
+const Lease = 30 * time.Second
+
+// ExpireInterval — интервал удаления ключей с истёкшим временем хранения.
+const ExpireInterval = time.Minute
+
+// ReplayedHeader отмечает ответ, возвращённый из сохранённого результата.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// ErrInProgress возвращается, если пакет с тем же ключом ещё обрабатывается.
+var ErrInProgress = errors.New("request with the same idempotency key is in progress")
+
+// Result — результат обработки пакета.
+type Result struct {
+	Status int
+	Body   []byte
+}
+
+// Store хранит ключи применённых пакетов.
+type Store interface {
+	// Reserve занимает ключ перед обработкой пакета. Если пакет с этим ключом уже применён,
+	// возвращает его результат и true. Если пакет ещё обрабатывается, возвращает ErrInProgress.
+	Reserve(ctx context.Context, key string) (Result, bool, error)
+	// Complete сохраняет результат обработки пакета.
+	Complete(ctx context.Context, key string, res Result) error
+	// Release освобождает ключ, если пакет не был применён.
+	Release(ctx context.Context, key string) error
+	// Expire удаляет ключи с истёкшим временем хранения.
+	Expire(ctx context.Context) error
+}
+
+// Run удаляет ключи store с истёкшим временем хранения каждые interval до отмены ctx.
+func Run(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Expire(ctx); err != nil {
+				logger.Warnf("Idempotency store error: " + err.Error())
+			}
+		}
+	}
+}
+
+// Key возвращает ключ пакета с учётом источника, чтобы ключи разных агентов не пересекались.
+func Key(source string, key string) string {
+	return source + "/" + key
+}
+
+type entry struct {
+	res     Result
+	done    bool
+	expires time.Time
+}
+
+// Cache хранит ключи в памяти в течение заданного времени.
+// Методы Cache безопасны для одновременного использования из нескольких горутин.
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+// NewCache создаёт хранилище ключей в памяти со временем хранения ttl.
+func NewCache(ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Cache{ttl: ttl, entries: make(map[string]*entry), now: time.Now}
+}
+
+// Reserve занимает ключ перед обработкой пакета.
+func (c *Cache) Reserve(ctx context.Context, key string) (Result, bool, error) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok && !now.After(e.expires) {
+		if !e.done {
+			return Result{}, false, ErrInProgress
+		}
+		return e.res, true, nil
+	}
+	c.entries[key] = &entry{expires: now.Add(Lease)}
+	return Result{}, false, nil
+}
+
+// Complete сохраняет результат обработки пакета.
+func (c *Cache) Complete(ctx context.Context, key string, res Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &entry{res: res, done: true, expires: c.now().Add(c.ttl)}
+	return nil
+}
+
+// Release освобождает ключ.
+func (c *Cache) Release(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+// Expire удаляет ключи с истёкшим временем хранения.
+func (c *Cache) Expire(ctx context.Context) error {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+type resultWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *resultWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *resultWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// WithIdempotency применяет запрос с заголовком Idempotency-Key не более одного раза.
+// На повторный запрос возвращается сохранённый ответ. Ответы с кодом 5xx не сохраняются,
+// а ключ запроса, обработчик которого завершился паникой, освобождается,
+// чтобы клиент мог повторить отправку. Запросы без ключа обрабатываются как обычно.
+func WithIdempotency(store Store) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(service.IdempotencyKeyHeader)
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key = Key(r.Header.Get(service.AgentIDHeader), key)
+			res, done, err := store.Reserve(r.Context(), key)
+			if errors.Is(err, ErrInProgress) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if done {
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(res.Status)
+				if _, err := w.Write(res.Body); err != nil {
+					logger.Warnf("Write response error: " + err.Error())
+				}
+				return
+			}
+			// обработчик, завершившийся паникой, не должен оставлять ключ занятым
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				if err := store.Release(context.WithoutCancel(r.Context()), key); err != nil {
+					logger.Warnf("Idempotency store error: " + err.Error())
+				}
+			}()
+			rw := &resultWriter{ResponseWriter: w}
+			h.ServeHTTP(rw, r)
+			completed = true
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+			// запрос уже обработан, поэтому результат сохраняется независимо от отмены запроса
+			ctx := context.WithoutCancel(r.Context())
+			if rw.status >= http.StatusInternalServerError {
+				err = store.Release(ctx, key)
+			} else {
+				err = store.Complete(ctx, key, Result{Status: rw.status, Body: rw.body.Bytes()})
+			}
+			if err != nil {
+				logger.Warnf("Idempotency store error: " + err.Error())
+			}
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/internal/service"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	_, done, err := c.Reserve(ctx, "a/1")
+	require.NoError(t, err)
+	assert.False(t, done)
+	_, _, err = c.Reserve(ctx, "a/1")
+	assert.ErrorIs(t, err, ErrInProgress)
+
+	// незавершённая обработка занимает ключ только на время Lease
+	_, _, err = c.Reserve(ctx, "a/2")
+	require.NoError(t, err)
+	now = now.Add(Lease + time.Second)
+	_, done, err = c.Reserve(ctx, "a/2")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	require.NoError(t, c.Complete(ctx, "a/1", Result{Status: http.StatusOK, Body: []byte("ok")}))
+	res, done, err := c.Reserve(ctx, "a/1")
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, Result{Status: http.StatusOK, Body: []byte("ok")}, res)
+
+	// после истечения времени хранения ключ можно использовать снова
+	now = now.Add(2 * time.Minute)
+	_, done, err = c.Reserve(ctx, "a/1")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	require.NoError(t, c.Release(ctx, "a/1"))
+	_, done, err = c.Reserve(ctx, "a/1")
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	// Expire удаляет ключи с истёкшим временем хранения
+	require.NoError(t, c.Complete(ctx, "a/1", Result{Status: http.StatusOK}))
+	require.NoError(t, c.Expire(ctx))
+	assert.Contains(t, c.entries, "a/1")
+	now = now.Add(2 * time.Minute)
+	require.NoError(t, c.Expire(ctx))
+	assert.Empty(t, c.entries)
+}
+
+func TestWithIdempotency(t *testing.T) {
+	var calls atomic.Int32
+	status := http.StatusOK
+	h := WithIdempotency(NewCache(time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(status)
+		w.Write([]byte("applied")) //nolint
+	}))
+	tests := []struct {
+		name       string
+		agent      string
+		key        string
+		status     int
+		wantCalls  int32
+		wantStatus int
+		replayed   bool
+	}{
+		{name: "1", agent: "a", key: "k1", status: http.StatusOK, wantCalls: 1, wantStatus: http.StatusOK},
+		{name: "2", agent: "a", key: "k1", status: http.StatusOK, wantCalls: 1, wantStatus: http.StatusOK, replayed: true},
+		{name: "3", agent: "b", key: "k1", status: http.StatusOK, wantCalls: 2, wantStatus: http.StatusOK},
+		{name: "4", agent: "a", status: http.StatusOK, wantCalls: 3, wantStatus: http.StatusOK},
+		{name: "5", agent: "a", status: http.StatusOK, wantCalls: 4, wantStatus: http.StatusOK},
+		{name: "6", agent: "a", key: "k2", status: http.StatusInternalServerError, wantCalls: 5, wantStatus: http.StatusInternalServerError},
+		{name: "7", agent: "a", key: "k2", status: http.StatusOK, wantCalls: 6, wantStatus: http.StatusOK},
+		{name: "8", agent: "a", key: "k2", status: http.StatusOK, wantCalls: 6, wantStatus: http.StatusOK, replayed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status = tt.status
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			req.Header.Set(service.AgentIDHeader, tt.agent)
+			if tt.key != "" {
+				req.Header.Set(service.IdempotencyKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCalls, calls.Load())
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.replayed, w.Header().Get(ReplayedHeader) == "true")
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, "applied", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithIdempotencyInProgress(t *testing.T) {
+	store := NewCache(time.Minute)
+	_, _, err := store.Reserve(context.Background(), Key("a", "k1"))
+	require.NoError(t, err)
+	h := WithIdempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	req.Header.Set(service.AgentIDHeader, "a")
+	req.Header.Set(service.IdempotencyKeyHeader, "k1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestWithIdempotencyPanic(t *testing.T) {
+	store := NewCache(time.Minute)
+	h := WithIdempotency(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	req.Header.Set(service.AgentIDHeader, "a")
+	req.Header.Set(service.IdempotencyKeyHeader, "k1")
+	assert.Panics(t, func() { h.ServeHTTP(httptest.NewRecorder(), req) })
+
+	// ключ освобождён, повторная отправка обрабатывается
+	_, done, err := store.Reserve(context.Background(), Key("a", "k1"))
+	require.NoError(t, err)
+	assert.False(t, done)
+}
@@ -0,0 +1,54 @@
+package idempotency
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"musthave-metrics/internal/postgres"
+)
+
+// DBStore хранит ключи в СУБД, поэтому они сохраняются при перезапуске сервера
+// и общие для нескольких серверов с одной СУБД.
+type DBStore struct {
+	settings postgres.Settings
+	db       *pgxpool.Pool
+	ttl      time.Duration
+}
+
+// NewDBStore создаёт хранилище ключей в СУБД с пулом соединений db со временем хранения ttl.
+// Пул закрывает вызывающий.
+func NewDBStore(db *pgxpool.Pool, ttl time.Duration) *DBStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &DBStore{settings: postgres.NewPSQLStr(db.Config().ConnString()), db: db, ttl: ttl}
+}
+
+// Reserve занимает ключ перед обработкой пакета.
+func (s *DBStore) Reserve(ctx context.Context, key string) (Result, bool, error) {
+	status, body, reserved, err := s.settings.ReserveKey(ctx, s.db, key, Lease, s.ttl)
+	if err != nil || reserved {
+		return Result{}, false, err
+	}
+	if status == 0 {
+		return Result{}, false, ErrInProgress
+	}
+	return Result{Status: status, Body: body}, true, nil
+}
+
+// Complete сохраняет результат обработки пакета.
+func (s *DBStore) Complete(ctx context.Context, key string, res Result) error {
+	return s.settings.CompleteKey(ctx, s.db, key, res.Status, res.Body)
+}
+
+// Release освобождает ключ.
+func (s *DBStore) Release(ctx context.Context, key string) error {
+	return s.settings.ReleaseKey(ctx, s.db, key)
+}
+
+// Expire удаляет ключи с истёкшим временем хранения.
+func (s *DBStore) Expire(ctx context.Context) error {
+	return s.settings.ExpireKeys(ctx, s.db, s.ttl)
+}
@@ -0,0 +1,136 @@
+// Package limits ограничивает размер тел запросов и пакетов метрик
+// и считает запросы, отклонённые из-за превышения пределов.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// DefaultMaxBodySize — наибольший размер тела запроса до распаковки по умолчанию, 8 МиБ.
+const DefaultMaxBodySize = 8 << 20
+
+// DefaultMaxBatchMetrics — наибольшее число метрик в пакете по умолчанию.
+const DefaultMaxBatchMetrics = 10000
+
+// ErrBatchTooLarge возвращается, если в пакете больше метрик, чем допускается.
+var ErrBatchTooLarge = errors.New("too many metrics in batch")
+
+// Kind — вид предела.
+type Kind int
+
+// виды пределов
+const (
+	// Body — размер тела запроса в том виде, в каком оно получено.
+	Body Kind = iota
+	// Decoded — размер тела запроса после распаковки.
+	Decoded
+	// Batch — число метрик в пакете.
+	Batch
+)
+
+var (
+	counters        [Batch + 1]atomic.Int64
+	maxBatchMetrics atomic.Int64
+)
+
+func init() {
+	maxBatchMetrics.Store(DefaultMaxBatchMetrics)
+}
+
+// Stats — число запросов, отклонённых из-за превышения пределов.
+type Stats struct {
+	Body    int64 `json:"body_too_large"`
+	Decoded int64 `json:"decoded_too_large"`
+	Batch   int64 `json:"batch_too_large"`
+}
+
+// Rejected возвращает число отклонённых запросов по видам пределов.
+func Rejected() Stats {
+	return Stats{
+		Body:    counters[Body].Load(),
+		Decoded: counters[Decoded].Load(),
+		Batch:   counters[Batch].Load(),
+	}
+}
+
+// Count учитывает запрос, отклонённый из-за превышения предела kind.
+func Count(kind Kind) {
+	counters[kind].Add(1)
+}
+
+// SetMaxBatchMetrics задаёт наибольшее число метрик в пакете, 0 — без ограничения.
+func SetMaxBatchMetrics(n int) {
+	maxBatchMetrics.Store(int64(n))
+}
+
+// CheckBatch проверяет число метрик в пакете. Если метрик больше допустимого,
+// учитывает отклонённый пакет и возвращает ошибку ErrBatchTooLarge.
+func CheckBatch(n int) error {
+	if max := maxBatchMetrics.Load(); max > 0 && int64(n) > max {
+		Count(Batch)
+		return fmt.Errorf("%w: %d, at most %d", ErrBatchTooLarge, n, max)
+	}
+	return nil
+}
+
+// Status возвращает код ответа на ошибку чтения тела запроса или разбора пакета:
+// 413, если превышен предел, иначе 400.
+func Status(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, ErrBatchTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// limitReader читает тело запроса через http.MaxBytesReader
+// и учитывает превышение предела один раз за запрос.
+type limitReader struct {
+	io.ReadCloser
+	kind    Kind
+	counted bool
+}
+
+// Reader ограничивает тело запроса n байтами так же, как http.MaxBytesReader:
+// при превышении предела чтение возвращает *http.MaxBytesError. Превышение учитывается в счётчике kind.
+func Reader(w http.ResponseWriter, body io.ReadCloser, n int64, kind Kind) io.ReadCloser {
+	return &limitReader{ReadCloser: http.MaxBytesReader(w, body, n), kind: kind}
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if !r.counted && errors.As(err, &maxBytesErr) {
+		r.counted = true
+		Count(r.kind)
+	}
+	return n, err
+}
+
+// WithBodyLimit ограничивает тело запроса max байтами. Запрос, длина которого
+// в Content-Length больше max, отклоняется сразу с кодом 413. Тело без Content-Length
+// обрывается на пределе, и обработчик получает ошибку *http.MaxBytesError.
+func WithBodyLimit(max int64) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if max <= 0 || r.Body == nil || r.Body == http.NoBody {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > max {
+				Count(Body)
+				w.Header().Set("Connection", "close")
+				http.Error(w, "request body is larger than "+strconv.FormatInt(max, 10)+" bytes", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = Reader(w, r.Body, max, Body)
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
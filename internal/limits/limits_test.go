@@ -0,0 +1,65 @@
+package limits
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithBodyLimit(t *testing.T) {
+	h := WithBodyLimit(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), Status(err))
+			return
+		}
+		w.Write(data) //nolint
+	}))
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantStatus    int
+		wantRejected  int64
+	}{
+		{name: "1", body: "0123456789", contentLength: 10, wantStatus: http.StatusOK},
+		{name: "2", body: "0123456789!", contentLength: 11, wantStatus: http.StatusRequestEntityTooLarge, wantRejected: 1},
+		// без Content-Length тело обрывается на пределе
+		{name: "3", body: "0123456789!", contentLength: -1, wantStatus: http.StatusRequestEntityTooLarge, wantRejected: 1},
+		{name: "4", body: "", contentLength: 0, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := Rejected().Body
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantRejected, Rejected().Body-before)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCheckBatch(t *testing.T) {
+	defer SetMaxBatchMetrics(DefaultMaxBatchMetrics)
+	SetMaxBatchMetrics(3)
+	before := Rejected().Batch
+	assert.NoError(t, CheckBatch(3))
+	err := CheckBatch(4)
+	require.ErrorIs(t, err, ErrBatchTooLarge)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, Status(err))
+	assert.Equal(t, int64(1), Rejected().Batch-before)
+
+	SetMaxBatchMetrics(0)
+	assert.NoError(t, CheckBatch(1<<20))
+	assert.Equal(t, http.StatusBadRequest, Status(io.ErrUnexpectedEOF))
+}
@@ -5,7 +5,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
+
+	"musthave-metrics/internal/telemetry"
 )
 
 type (
@@ -35,6 +38,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap возвращает оригинальный http.ResponseWriter, например для http.ResponseController.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // WithLogging добавляет дополнительный код для регистрации сведений о запросе
 // и возвращает новый http.Handler.
 func WithLogging(h http.Handler) http.Handler {
@@ -65,6 +73,18 @@ func WithLogging(h http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
+		// учитываем запрос в метриках сервера по шаблону маршрута, а не по пути,
+		// чтобы число значений метрик не зависело от имён метрик в запросах
+		status := responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+		telemetry.ObserveHTTP(r.Method, route, status, duration)
+
 		if r.Method == http.MethodGet {
 			sugar.Infoln(
 				"uri", r.RequestURI,
@@ -6,6 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/internal/telemetry"
 )
 
 func TestWithLogging(t *testing.T) {
@@ -71,6 +77,43 @@ func TestWithLogging(t *testing.T) {
 	}
 }
 
+func TestWithLoggingTelemetry(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(WithLogging)
+	r.Get("/value/{metricType}/{metricName}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "1")
+	})
+	r.Post("/update/{metricType}/{metricName}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		route  string
+		status string
+	}{
+		{name: "1", method: http.MethodGet, path: "/value/gauge/TestLogGauge", route: "/value/{metricType}/{metricName}", status: "200"},
+		{name: "2", method: http.MethodPost, path: "/update/gauge/TestLogGauge", route: "/update/{metricType}/{metricName}", status: "400"},
+		{name: "3", method: http.MethodGet, path: "/missing/TestLogGauge", route: "unmatched", status: "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := telemetry.HTTPRequests.Value(tt.method, tt.route, tt.status)
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+			require.NoError(t, err)
+			res, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, before+1, telemetry.HTTPRequests.Value(tt.method, tt.route, tt.status))
+			assert.NotZero(t, telemetry.HTTPDuration.Count(tt.method, tt.route, tt.status))
+		})
+	}
+}
+
 func TestServerRunningInfo(t *testing.T) {
 	type args struct {
 		RunAddr string
@@ -0,0 +1,23 @@
+-- +goose Up
+ALTER TABLE Gauges ADD COLUMN source TEXT NOT NULL DEFAULT '';
+ALTER TABLE Gauges ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE Gauges DROP CONSTRAINT gauges_pkey;
+ALTER TABLE Gauges ADD PRIMARY KEY (mname, source);
+
+ALTER TABLE Counters ADD COLUMN source TEXT NOT NULL DEFAULT '';
+ALTER TABLE Counters ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE Counters DROP CONSTRAINT counters_pkey;
+ALTER TABLE Counters ADD PRIMARY KEY (mname, source);
+
+-- +goose Down
+ALTER TABLE Gauges DROP CONSTRAINT gauges_pkey;
+DELETE FROM Gauges WHERE source <> '';
+ALTER TABLE Gauges ADD PRIMARY KEY (mname);
+ALTER TABLE Gauges DROP COLUMN updated_at;
+ALTER TABLE Gauges DROP COLUMN source;
+
+ALTER TABLE Counters DROP CONSTRAINT counters_pkey;
+DELETE FROM Counters WHERE source <> '';
+ALTER TABLE Counters ADD PRIMARY KEY (mname);
+ALTER TABLE Counters DROP COLUMN updated_at;
+ALTER TABLE Counters DROP COLUMN source;
@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE Gauges ADD COLUMN ts BIGINT;
+
+-- +goose Down
+ALTER TABLE Gauges DROP COLUMN ts;
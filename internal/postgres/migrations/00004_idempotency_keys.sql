@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IdempotencyKeys (
+    key TEXT PRIMARY KEY,
+    status INTEGER NOT NULL DEFAULT 0,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- +goose Down
+DROP TABLE IdempotencyKeys;
@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX idempotencykeys_created_at ON IdempotencyKeys (created_at);
+
+-- +goose Down
+DROP INDEX idempotencykeys_created_at;
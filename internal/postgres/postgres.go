@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/storage"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
@@ -34,10 +36,13 @@ type Settings struct {
 }
 
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string   `json:"id"`               // имя метрики
+	MType  string   `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *int64   `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64 `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Source string   `json:"source,omitempty"` // идентификатор агента, приславшего метрику
+	// Timestamp — время значения gauge по часам агента, unix-время в миллисекундах
+	Timestamp *int64 `json:"timestamp,omitempty"`
 }
 
 type RetryAfterError struct {
@@ -116,97 +121,148 @@ func (s *Settings) Ping(ctx context.Context) error {
 	return err
 }
 
-func (s *Settings) Updates(ctx context.Context, db *pgxpool.Pool, metrics []Metrics) error {
+// querier выполняет запрос в пуле соединений или в транзакции.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ItemError — ошибка сохранения метрики пакета с индексом Index.
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("metric %d: %v", e.Index, e.Err)
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Updates сохраняет пакет метрик в одной транзакции
+// и возвращает сохранённые значения (для counter — накопленные источником).
+// Устаревшие значения gauge пропускаются и не возвращаются.
+// Ошибка сохранения отдельной метрики возвращается как ItemError.
+func (s *Settings) Updates(ctx context.Context, db *pgxpool.Pool, metrics []Metrics) ([]Metrics, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	stored, err := upserts(ctx, tx, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return stored, tx.Commit(ctx)
+}
+
+// Replace удаляет все значения метрик и сохраняет пакет метрик в одной транзакции,
+// поэтому при ошибке сохранённые значения не меняются.
+// Возвращает число удалённых значений и сохранённые значения, как Updates.
+func (s *Settings) Replace(ctx context.Context, db *pgxpool.Pool, metrics []Metrics) (int64, []Metrics, error) {
 	tx, err := db.Begin(ctx)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	defer tx.Rollback(ctx) //nolint
-	for _, m := range metrics {
-		err := s.UpdateNew(ctx, db, m.MType, m.ID, m.Delta, m.Value)
+	deleted, err := deleteMetrics(ctx, tx, "", "%", "")
+	if err != nil {
+		return 0, nil, err
+	}
+	stored, err := upserts(ctx, tx, metrics)
+	if err != nil {
+		return 0, nil, err
+	}
+	return deleted, stored, tx.Commit(ctx)
+}
+
+// upserts сохраняет пакет метрик в транзакции tx.
+func upserts(ctx context.Context, tx pgx.Tx, metrics []Metrics) ([]Metrics, error) {
+	stored := make([]Metrics, 0, len(metrics))
+	for i, m := range metrics {
+		val, err := upsert(ctx, tx, m.MType, m.ID, m.Source, m.Delta, m.Value, m.Timestamp)
+		if errors.Is(err, storage.ErrStaleUpdate) {
+			continue
+		}
 		if err != nil {
-			return err
+			return nil, ItemError{Index: i, Err: err}
 		}
+		stored = append(stored, storedMetric(m, val))
 	}
-	return tx.Commit(ctx)
+	return stored, nil
+}
+
+// UpdateNew сохраняет значение метрики, полученное от источника source,
+// и возвращает сохранённое значение (для counter — накопленное источником).
+// Значение gauge со временем ts раньше уже сохранённого не записывается:
+// возвращается сохранённое значение и ошибка storage.ErrStaleUpdate.
+func (s *Settings) UpdateNew(ctx context.Context, db *pgxpool.Pool, t string, n string, source string, d *int64, v *float64, ts *int64) (string, error) {
+	return upsert(ctx, db, t, n, source, d, v, ts)
 }
 
-func (s *Settings) UpdateNew(ctx context.Context, db *pgxpool.Pool, t string, n string, d *int64, v *float64) error {
+func upsert(ctx context.Context, q querier, t string, n string, source string, d *int64, v *float64, ts *int64) (string, error) {
+	var val string
 	if t == "gauge" {
-		value := strconv.FormatFloat(*v, 'g', -1, 64)
-		result := db.QueryRow(ctx, `
-			SELECT gauges.mname
-			FROM
-				public.gauges
-			WHERE
-				gauges.mname=$1
-		`, n)
-		switch err := result.Scan(&n); err {
-		case pgx.ErrNoRows:
-			_, err = db.Exec(ctx, `
-				INSERT INTO public.gauges
-				(mname, mvalue)
-				VALUES
-				($1, $2);
-			`, n, value)
-			if err != nil {
-				logger.Warnf("INSERT INTO Gauges: " + err.Error())
-				return err
-			}
-		case nil:
-			_, err = db.Exec(ctx, `
-				UPDATE public.gauges
-				SET mvalue=$2
-				WHERE mname=$1;
-			`, n, value)
-			if err != nil {
-				logger.Warnf("UPDATE Gauges: " + err.Error())
-				return err
+		result := q.QueryRow(ctx, `
+			INSERT INTO public.gauges
+			(mname, source, mvalue, updated_at, ts)
+			VALUES
+			($1, $2, $3, now(), $4)
+			ON CONFLICT (mname, source) DO UPDATE
+			SET mvalue=EXCLUDED.mvalue, updated_at=EXCLUDED.updated_at, ts=COALESCE(EXCLUDED.ts, gauges.ts)
+			WHERE EXCLUDED.ts IS NULL OR gauges.ts IS NULL OR gauges.ts <= EXCLUDED.ts
+			RETURNING mvalue::text;
+		`, n, source, *v, ts)
+		err := result.Scan(&val)
+		if errors.Is(err, pgx.ErrNoRows) {
+			// строка не обновлена: сохранённое значение новее полученного
+			storage.CountStale(source)
+			err = q.QueryRow(ctx, `SELECT mvalue::text FROM public.gauges WHERE mname=$1 AND source=$2`, n, source).Scan(&val)
+			if err == nil {
+				err = storage.ErrStaleUpdate
 			}
-		case err:
-			logger.Warnf("QueryRow Gauges: " + err.Error())
-			return err
+			return val, err
+		}
+		if err != nil {
+			logger.Warnf("UPSERT Gauges: " + err.Error())
+			return "", err
 		}
 	} else if t == "counter" {
-		delta := strconv.FormatInt(*d, 10)
-		result := db.QueryRow(ctx, `
-			SELECT counters.mname
-			FROM
-				public.counters
-			WHERE
-				counters.mname=$1
-		`, n)
-		switch err := result.Scan(&n); err {
-		case pgx.ErrNoRows:
-			_, err = db.Exec(ctx, `
-				INSERT INTO public.counters
-				(mname, mvalue)
-				VALUES
-				($1, $2);
-			`, n, delta)
-			if err != nil {
-				logger.Warnf("INSERT INTO Counters: " + err.Error())
-				return err
-			}
-		case nil:
-			_, err = db.Exec(ctx, `
-				UPDATE public.counters
-				SET mvalue=mvalue+$2
-				WHERE mname=$1;
-			`, n, delta)
-			if err != nil {
-				logger.Warnf("UPDATE Counters: " + err.Error())
-				return err
-			}
-		case err:
-			logger.Warnf("QueryRow Counters: " + err.Error())
-			return err
+		result := q.QueryRow(ctx, `
+			INSERT INTO public.counters
+			(mname, source, mvalue, updated_at)
+			VALUES
+			($1, $2, $3, now())
+			ON CONFLICT (mname, source) DO UPDATE
+			SET mvalue=counters.mvalue+EXCLUDED.mvalue, updated_at=EXCLUDED.updated_at
+			RETURNING mvalue::text;
+		`, n, source, *d)
+		if err := result.Scan(&val); err != nil {
+			logger.Warnf("UPSERT Counters: " + err.Error())
+			return "", err
+		}
+	}
+	return val, nil
+}
+
+// storedMetric подставляет в метрику значение, возвращённое СУБД.
+func storedMetric(m Metrics, val string) Metrics {
+	if m.MType == "gauge" {
+		if value, err := strconv.ParseFloat(val, 64); err == nil {
+			m.Value = &value
+		}
+	} else if m.MType == "counter" {
+		if delta, err := strconv.ParseInt(val, 10, 64); err == nil {
+			m.Delta = &delta
 		}
 	}
-	return nil
+	return m
 }
 
-func (s *Settings) GetValue(ctx context.Context, DatabaseDSN string, t string, n string) (string, error) {
+// GetValue возвращает значение метрики: для gauge — последнее полученное,
+// для counter — сумму по всем источникам. Непустой source ограничивает выборку одним источником.
+func (s *Settings) GetValue(ctx context.Context, DatabaseDSN string, t string, n string, source string) (string, error) {
 	var val string
 	db, err := pgxpool.New(ctx, DatabaseDSN)
 	if err != nil {
@@ -219,8 +275,10 @@ func (s *Settings) GetValue(ctx context.Context, DatabaseDSN string, t string, n
 			FROM
 				public.gauges
 			WHERE
-				gauges.mname=$1
-		`, n)
+				gauges.mname=$1 AND ($2='' OR gauges.source=$2)
+			ORDER BY gauges.updated_at DESC
+			LIMIT 1
+		`, n, source)
 		switch err := result.Scan(&val); err {
 		case pgx.ErrNoRows:
 			return "0", nil
@@ -232,12 +290,12 @@ func (s *Settings) GetValue(ctx context.Context, DatabaseDSN string, t string, n
 		}
 	} else if t == "counter" {
 		result := db.QueryRow(ctx, `
-			SELECT counters.mvalue
+			SELECT COALESCE(SUM(counters.mvalue), 0)::text
 			FROM
 				public.counters
 			WHERE
-			counters.mname=$1
-		`, n)
+				counters.mname=$1 AND ($2='' OR counters.source=$2)
+		`, n, source)
 		switch err := result.Scan(&val); err {
 		case pgx.ErrNoRows:
 			return "0", nil
@@ -251,6 +309,218 @@ func (s *Settings) GetValue(ctx context.Context, DatabaseDSN string, t string, n
 	return val, nil
 }
 
+// Records возвращает значения всех метрик в разрезе источников со временем обновления.
+func (s *Settings) Records(ctx context.Context, db *pgxpool.Pool) ([]storage.Record, error) {
+	rows, err := db.Query(ctx, `
+		SELECT 'gauge', mname, source, mvalue, 0::bigint, updated_at, ts FROM public.gauges
+		UNION ALL
+		SELECT 'counter', mname, source, 0::double precision, mvalue, updated_at, NULL::bigint FROM public.counters
+	`)
+	if err != nil {
+		logger.Warnf("Query metrics: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	records := make([]storage.Record, 0)
+	for rows.Next() {
+		var (
+			r  storage.Record
+			ts *int64
+		)
+		if err := rows.Scan(&r.MType, &r.Name, &r.Source, &r.Value, &r.Delta, &r.Updated, &ts); err != nil {
+			return nil, err
+		}
+		if ts != nil {
+			r.Timestamp = storage.UnixMilli(ts)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Value возвращает значение метрики: для gauge — последнее полученное,
+// для counter — сумму по всем источникам. Непустой source ограничивает выборку одним источником.
+// Если метрика не найдена, возвращает pgx.ErrNoRows.
+func (s *Settings) Value(ctx context.Context, db *pgxpool.Pool, t string, n string, source string) (string, error) {
+	var val *string
+	var err error
+	switch t {
+	case "gauge":
+		err = db.QueryRow(ctx, `
+			SELECT mvalue::text FROM public.gauges
+			WHERE mname=$1 AND ($2='' OR source=$2)
+			ORDER BY updated_at DESC
+			LIMIT 1
+		`, n, source).Scan(&val)
+	case "counter":
+		err = db.QueryRow(ctx, `
+			SELECT SUM(mvalue)::text FROM public.counters
+			WHERE mname=$1 AND ($2='' OR source=$2)
+		`, n, source).Scan(&val)
+	default:
+		return "", pgx.ErrNoRows
+	}
+	if err != nil {
+		return "", err
+	}
+	if val == nil {
+		return "", pgx.ErrNoRows
+	}
+	return *val, nil
+}
+
+// List возвращает значения всех метрик в разрезе источников.
+func (s *Settings) List(ctx context.Context, db *pgxpool.Pool) ([]Metrics, error) {
+	rows, err := db.Query(ctx, `
+		SELECT 'gauge', mname, source, mvalue, 0::bigint FROM public.gauges
+		UNION ALL
+		SELECT 'counter', mname, source, 0::double precision, mvalue FROM public.counters
+		ORDER BY 1 DESC, 2, 3
+	`)
+	if err != nil {
+		logger.Warnf("Query metrics: " + err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	metrics := make([]Metrics, 0)
+	for rows.Next() {
+		var (
+			m     Metrics
+			value float64
+			delta int64
+		)
+		if err := rows.Scan(&m.MType, &m.ID, &m.Source, &value, &delta); err != nil {
+			return nil, err
+		}
+		if m.MType == "gauge" {
+			m.Value = &value
+		} else {
+			m.Delta = &delta
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// Delete удаляет значения метрик типа t (пустой — обоих типов), имя которых подходит
+// под шаблон pattern с * и ?, полученные от источника source (пустой — от всех источников).
+// Возвращает число удалённых значений.
+func (s *Settings) Delete(ctx context.Context, db *pgxpool.Pool, t string, pattern string, source string) (int64, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx) //nolint
+	deleted, err := deleteMetrics(ctx, tx, t, likePattern(pattern), source)
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit(ctx)
+}
+
+// deleteMetrics удаляет в транзакции tx значения метрик типа t, имя которых подходит
+// под шаблон LIKE like, и возвращает их число.
+func deleteMetrics(ctx context.Context, tx pgx.Tx, t string, like string, source string) (int64, error) {
+	var deleted int64
+	if t == "" || t == "gauge" {
+		tag, err := tx.Exec(ctx, `DELETE FROM public.gauges WHERE mname LIKE $1 AND ($2='' OR source=$2)`, like, source)
+		if err != nil {
+			logger.Warnf("DELETE Gauges: " + err.Error())
+			return 0, err
+		}
+		deleted += tag.RowsAffected()
+	}
+	if t == "" || t == "counter" {
+		tag, err := tx.Exec(ctx, `DELETE FROM public.counters WHERE mname LIKE $1 AND ($2='' OR source=$2)`, like, source)
+		if err != nil {
+			logger.Warnf("DELETE Counters: " + err.Error())
+			return 0, err
+		}
+		deleted += tag.RowsAffected()
+	}
+	return deleted, nil
+}
+
+// ResetCounters обнуляет значения counter, имя которых подходит под шаблон pattern,
+// полученные от источника source (пустой — от всех источников). Возвращает число сброшенных значений.
+func (s *Settings) ResetCounters(ctx context.Context, db *pgxpool.Pool, pattern string, source string) (int64, error) {
+	tag, err := db.Exec(ctx, `
+		UPDATE public.counters SET mvalue=0, updated_at=now()
+		WHERE mname LIKE $1 AND ($2='' OR source=$2)
+	`, likePattern(pattern), source)
+	if err != nil {
+		logger.Warnf("UPDATE Counters: " + err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// likePattern переводит шаблон имени с * и ? в шаблон LIKE.
+func likePattern(pattern string) string {
+	var b strings.Builder
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteByte('%')
+		case '?':
+			b.WriteByte('_')
+		case '%', '_', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// ReserveKey занимает ключ пакета метрик. Ключ, обработка пакета которого не завершена
+// за время lease, и ключ старше ttl занимаются заново.
+// Если ключ уже занят, возвращает сохранённые код и тело ответа и false;
+// нулевой код означает, что пакет ещё обрабатывается.
+func (s *Settings) ReserveKey(ctx context.Context, db *pgxpool.Pool, key string, lease time.Duration, ttl time.Duration) (int, []byte, bool, error) {
+	tag, err := db.Exec(ctx, `
+		INSERT INTO public.idempotencykeys (key) VALUES ($1)
+		ON CONFLICT (key) DO UPDATE SET status=0, body=NULL, created_at=now()
+		WHERE (idempotencykeys.status=0 AND idempotencykeys.created_at < now() - make_interval(secs => $2))
+			OR idempotencykeys.created_at < now() - make_interval(secs => $3)
+	`, key, lease.Seconds(), ttl.Seconds())
+	if err != nil {
+		logger.Warnf("INSERT IdempotencyKeys: " + err.Error())
+		return 0, nil, false, err
+	}
+	if tag.RowsAffected() == 1 {
+		return 0, nil, true, nil
+	}
+	var (
+		status int
+		body   []byte
+	)
+	err = db.QueryRow(ctx, `SELECT status, body FROM public.idempotencykeys WHERE key=$1`, key).Scan(&status, &body)
+	return status, body, false, err
+}
+
+// CompleteKey сохраняет код и тело ответа для ключа пакета метрик.
+func (s *Settings) CompleteKey(ctx context.Context, db *pgxpool.Pool, key string, status int, body []byte) error {
+	_, err := db.Exec(ctx, `UPDATE public.idempotencykeys SET status=$2, body=$3 WHERE key=$1`, key, status, body)
+	return err
+}
+
+// ExpireKeys удаляет ключи пакетов метрик старше ttl.
+func (s *Settings) ExpireKeys(ctx context.Context, db *pgxpool.Pool, ttl time.Duration) error {
+	_, err := db.Exec(ctx, `DELETE FROM public.idempotencykeys WHERE created_at < now() - make_interval(secs => $1)`, ttl.Seconds())
+	if err != nil {
+		logger.Warnf("DELETE IdempotencyKeys: " + err.Error())
+	}
+	return err
+}
+
+// ReleaseKey освобождает ключ пакета метрик.
+func (s *Settings) ReleaseKey(ctx context.Context, db *pgxpool.Pool, key string) error {
+	_, err := db.Exec(ctx, `DELETE FROM public.idempotencykeys WHERE key=$1`, key)
+	return err
+}
+
 func SetDB(ctx context.Context, DatabaseDSN string) {
 	db, err := sql.Open("pgx", DatabaseDSN)
 	if err != nil {
@@ -80,3 +80,23 @@ func TestSetDB(t *testing.T) {
 		})
 	}
 }
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+	}{
+		{name: "1", pattern: "Alloc", want: "Alloc"},
+		{name: "2", pattern: "CPU*", want: "CPU%"},
+		{name: "3", pattern: "Heap?", want: "Heap_"},
+		{name: "4", pattern: "go_gc_*", want: `go\_gc\_%`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := likePattern(tt.pattern); got != tt.want {
+				t.Errorf("likePattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
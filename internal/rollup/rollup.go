@@ -0,0 +1,272 @@
+// Package rollup вычисляет производные метрики по правилам агрегации,
+// например сумму CPUutilization* по ядрам или максимум HeapAlloc по всем агентам.
+package rollup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"time"
+
+	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/storage"
+)
+
+// функции агрегации
+const (
+	FuncSum   = "sum"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncAvg   = "avg"
+	FuncCount = "count"
+)
+
+// Rule описывает правило агрегации. Производная метрика всегда имеет тип gauge.
+type Rule struct {
+	Name      string `json:"name"`       // имя производной метрики
+	Type      string `json:"type"`       // тип исходных метрик: gauge (по умолчанию) или counter
+	Match     string `json:"match"`      // шаблон имён исходных метрик в формате path.Match, например CPUutilization*
+	Sources   string `json:"sources"`    // шаблон идентификаторов агентов, пустой — все агенты
+	Func      string `json:"func"`       // функция агрегации: sum, min, max, avg, count
+	PerSource bool   `json:"per_source"` // вычислять отдельно для каждого агента, иначе — по всем агентам
+	MaxAge    int    `json:"max_age"`    // не учитывать значения старше заданного числа секунд, 0 — учитывать все
+}
+
+// Store — хранилище, из которого читаются исходные и в которое записываются производные метрики.
+type Store interface {
+	Records(ctx context.Context) ([]storage.Record, error)
+	Write(ctx context.Context, r storage.Record) error
+}
+
+// Engine вычисляет производные метрики по расписанию или после получения новых значений.
+type Engine struct {
+	rules    []Rule
+	store    Store
+	interval time.Duration
+	notify   chan struct{}
+	now      func() time.Time
+}
+
+// New проверяет правила и создаёт вычислитель. При нулевом interval
+// производные метрики вычисляются после каждого получения новых значений (см. Notify).
+func New(rules []Rule, store Store, interval time.Duration) (*Engine, error) {
+	for i := range rules {
+		if err := validate(&rules[i]); err != nil {
+			return nil, fmt.Errorf("rollup rule %d: %w", i, err)
+		}
+	}
+	return &Engine{
+		rules:    rules,
+		store:    store,
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		now:      time.Now,
+	}, nil
+}
+
+func validate(rule *Rule) error {
+	if rule.Name == "" {
+		return errors.New("empty name")
+	}
+	if rule.Type == "" {
+		rule.Type = "gauge"
+	}
+	if rule.Type != "gauge" && rule.Type != "counter" {
+		return fmt.Errorf("unknown metric type %q", rule.Type)
+	}
+	switch rule.Func {
+	case FuncSum, FuncMin, FuncMax, FuncAvg, FuncCount:
+	default:
+		return fmt.Errorf("unknown func %q", rule.Func)
+	}
+	if _, err := path.Match(rule.Match, ""); err != nil || rule.Match == "" {
+		return fmt.Errorf("bad match pattern %q", rule.Match)
+	}
+	if _, err := path.Match(rule.Sources, ""); err != nil {
+		return fmt.Errorf("bad sources pattern %q", rule.Sources)
+	}
+	return nil
+}
+
+// Run вычисляет производные метрики до отмены ctx.
+func (e *Engine) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if e.interval > 0 {
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-e.notify:
+		}
+		if err := e.Evaluate(ctx); err != nil {
+			logger.Warnf("Rollup error: " + err.Error())
+		}
+	}
+}
+
+// Notify сообщает о получении новых значений. Вызовы, пришедшие во время
+// вычисления, объединяются. При вычислении по расписанию ничего не делает.
+func (e *Engine) Notify() {
+	if e.interval > 0 {
+		return
+	}
+	select {
+	case e.notify <- struct{}{}:
+	default:
+	}
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WithEvaluation запускает вычисление производных метрик после успешной обработки запроса.
+// Ответы с кодом, отличным от 2xx, вычисление не запускают.
+func (e *Engine) WithEvaluation(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if sw.status >= http.StatusOK && sw.status < http.StatusMultipleChoices {
+			e.Notify()
+		}
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Evaluate однократно вычисляет все правила по порядку.
+// Производные метрики предыдущих правил доступны следующим.
+func (e *Engine) Evaluate(ctx context.Context) error {
+	records, err := e.store.Records(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, rule := range e.rules {
+		for _, derived := range e.apply(rule, records) {
+			if err := e.store.Write(ctx, derived); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			records = upsert(records, derived)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// aggregate накапливает значения для функции агрегации.
+type aggregate struct {
+	sum, min, max float64
+	count         int
+}
+
+func (a *aggregate) add(v float64) {
+	if a.count == 0 || v < a.min {
+		a.min = v
+	}
+	if a.count == 0 || v > a.max {
+		a.max = v
+	}
+	a.sum += v
+	a.count++
+}
+
+func (a aggregate) result(fn string) float64 {
+	switch fn {
+	case FuncMin:
+		return a.min
+	case FuncMax:
+		return a.max
+	case FuncAvg:
+		return a.sum / float64(a.count)
+	case FuncCount:
+		return float64(a.count)
+	}
+	return a.sum
+}
+
+func (e *Engine) apply(rule Rule, records []storage.Record) []storage.Record {
+	now := e.now()
+	groups := make(map[string]*aggregate)
+	var order []string
+	for _, r := range records {
+		if r.MType != rule.Type || r.Name == rule.Name {
+			continue
+		}
+		if ok, _ := path.Match(rule.Match, r.Name); !ok {
+			continue
+		}
+		if rule.Sources != "" {
+			if ok, _ := path.Match(rule.Sources, r.Source); !ok {
+				continue
+			}
+		}
+		if rule.MaxAge > 0 && !r.Updated.IsZero() && now.Sub(r.Updated) > time.Duration(rule.MaxAge)*time.Second {
+			continue
+		}
+		value := r.Value
+		if r.MType == "counter" {
+			value = float64(r.Delta)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+		var key string
+		if rule.PerSource {
+			key = r.Source
+		}
+		a, ok := groups[key]
+		if !ok {
+			a = &aggregate{}
+			groups[key] = a
+			order = append(order, key)
+		}
+		a.add(value)
+	}
+	derived := make([]storage.Record, 0, len(order))
+	for _, source := range order {
+		derived = append(derived, storage.Record{
+			Source:  source,
+			MType:   "gauge",
+			Name:    rule.Name,
+			Value:   groups[source].result(rule.Func),
+			Updated: now,
+		})
+	}
+	return derived
+}
+
+func upsert(records []storage.Record, r storage.Record) []storage.Record {
+	for i := range records {
+		if records[i].MType == r.MType && records[i].Name == r.Name && records[i].Source == r.Source {
+			records[i] = r
+			return records
+		}
+	}
+	return append(records, r)
+}
@@ -0,0 +1,182 @@
+package rollup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"musthave-metrics/internal/storage"
+)
+
+// testStore хранит метрики в срезе.
+type testStore struct {
+	records []storage.Record
+}
+
+func (s *testStore) Records(ctx context.Context) ([]storage.Record, error) {
+	return append([]storage.Record(nil), s.records...), nil
+}
+
+func (s *testStore) Write(ctx context.Context, r storage.Record) error {
+	s.records = upsert(s.records, r)
+	return nil
+}
+
+func (s *testStore) value(name string, source string) (float64, bool) {
+	for _, r := range s.records {
+		if r.MType == "gauge" && r.Name == name && r.Source == source {
+			return r.Value, true
+		}
+	}
+	return 0, false
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	records := []storage.Record{
+		{Source: "a", MType: "gauge", Name: "CPUutilization1", Value: 10, Updated: now},
+		{Source: "a", MType: "gauge", Name: "CPUutilization2", Value: 20, Updated: now},
+		{Source: "b", MType: "gauge", Name: "CPUutilization1", Value: 5, Updated: now},
+		{Source: "a", MType: "gauge", Name: "HeapAlloc", Value: 100, Updated: now},
+		{Source: "b", MType: "gauge", Name: "HeapAlloc", Value: 300, Updated: now},
+		{Source: "c", MType: "gauge", Name: "HeapAlloc", Value: 900, Updated: now.Add(-time.Hour)},
+		{Source: "a", MType: "counter", Name: "PollCount", Delta: 4, Updated: now},
+		{Source: "b", MType: "counter", Name: "PollCount", Delta: 6, Updated: now},
+	}
+	tests := []struct {
+		name   string
+		rule   Rule
+		source string
+		want   float64
+	}{
+		{
+			name:   "1",
+			rule:   Rule{Name: "CPUutilizationTotal", Match: "CPUutilization*", Func: FuncSum, PerSource: true},
+			source: "a",
+			want:   30,
+		},
+		{
+			name: "2",
+			rule: Rule{Name: "HeapAllocMax", Match: "HeapAlloc", Func: FuncMax},
+			want: 900,
+		},
+		{
+			name: "3",
+			rule: Rule{Name: "HeapAllocMax", Match: "HeapAlloc", Func: FuncMax, MaxAge: 60},
+			want: 300,
+		},
+		{
+			name: "4",
+			rule: Rule{Name: "HeapAllocAvg", Match: "HeapAlloc", Sources: "[ab]", Func: FuncAvg},
+			want: 200,
+		},
+		{
+			name: "5",
+			rule: Rule{Name: "PollCountTotal", Type: "counter", Match: "PollCount", Func: FuncSum},
+			want: 10,
+		},
+		{
+			name: "6",
+			rule: Rule{Name: "Agents", Match: "HeapAlloc", Func: FuncCount},
+			want: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &testStore{records: append([]storage.Record(nil), records...)}
+			e, err := New([]Rule{tt.rule}, store, 0)
+			require.NoError(t, err)
+			require.NoError(t, e.Evaluate(context.Background()))
+			got, ok := store.value(tt.rule.Name, tt.source)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvaluateChained(t *testing.T) {
+	store := &testStore{records: []storage.Record{
+		{Source: "a", MType: "gauge", Name: "CPUutilization1", Value: 10},
+		{Source: "a", MType: "gauge", Name: "CPUutilization2", Value: 20},
+		{Source: "b", MType: "gauge", Name: "CPUutilization1", Value: 50},
+	}}
+	e, err := New([]Rule{
+		{Name: "CPUutilizationTotal", Match: "CPUutilization*", Func: FuncSum, PerSource: true},
+		{Name: "CPUutilizationTotalMax", Match: "CPUutilizationTotal", Func: FuncMax},
+	}, store, 0)
+	require.NoError(t, err)
+	// повторное вычисление не учитывает собственные производные метрики
+	for i := 0; i < 2; i++ {
+		require.NoError(t, e.Evaluate(context.Background()))
+	}
+	got, _ := store.value("CPUutilizationTotal", "a")
+	assert.Equal(t, 30.0, got)
+	got, _ = store.value("CPUutilizationTotalMax", "")
+	assert.Equal(t, 50.0, got)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{name: "1", rule: Rule{Name: "Total", Match: "CPU*", Func: FuncSum}},
+		{name: "2", rule: Rule{Match: "CPU*", Func: FuncSum}, wantErr: true},
+		{name: "3", rule: Rule{Name: "Total", Match: "CPU*", Func: "median"}, wantErr: true},
+		{name: "4", rule: Rule{Name: "Total", Match: "[", Func: FuncSum}, wantErr: true},
+		{name: "5", rule: Rule{Name: "Total", Type: "histogram", Match: "CPU*", Func: FuncSum}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]Rule{tt.rule}, &testStore{}, 0)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestWithEvaluation(t *testing.T) {
+	storage.GaugeMetric{Name: "RollupTest1", Value: "1.5", Source: "rollup"}.Add() //nolint
+	storage.GaugeMetric{Name: "RollupTest2", Value: "2", Source: "rollup"}.Add()   //nolint
+	e, err := New([]Rule{{Name: "RollupTestTotal", Match: "RollupTest?", Func: FuncSum}}, MemoryStore{}, 0)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+
+	h := e.WithEvaluation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/updates/", nil))
+	assert.Eventually(t, func() bool {
+		value, err := storage.GaugeMetric{Name: "RollupTestTotal"}.GetValue()
+		return err == nil && value == "3.5"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWithEvaluationStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		notify bool
+	}{
+		{name: "1", status: http.StatusOK, notify: true},
+		{name: "2", status: http.StatusAccepted, notify: true},
+		{name: "3", status: http.StatusBadRequest},
+		{name: "4", status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := New(nil, MemoryStore{}, 0)
+			require.NoError(t, err)
+			h := e.WithEvaluation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/updates/", nil))
+			assert.Equal(t, tt.notify, len(e.notify) == 1)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package rollup
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"musthave-metrics/internal/postgres"
+	"musthave-metrics/internal/storage"
+)
+
+// MemoryStore читает и записывает метрики в памяти сервера.
+type MemoryStore struct{}
+
+// Records возвращает значения метрик в разрезе источников.
+func (MemoryStore) Records(ctx context.Context) ([]storage.Record, error) {
+	return storage.Records(), nil
+}
+
+// Write сохраняет значение производной метрики.
+func (MemoryStore) Write(ctx context.Context, r storage.Record) error {
+	return storage.GaugeMetric{Name: r.Name, Value: strconv.FormatFloat(r.Value, 'g', -1, 64), Source: r.Source}.Add()
+}
+
+// DBStore читает и записывает метрики в СУБД.
+type DBStore struct {
+	settings postgres.Settings
+	db       *pgxpool.Pool
+}
+
+// NewDBStore создаёт хранилище в СУБД с пулом соединений db.
+// Пул закрывает вызывающий.
+func NewDBStore(db *pgxpool.Pool) *DBStore {
+	return &DBStore{settings: postgres.NewPSQLStr(db.Config().ConnString()), db: db}
+}
+
+// Records возвращает значения метрик в разрезе источников.
+func (s *DBStore) Records(ctx context.Context) ([]storage.Record, error) {
+	return s.settings.Records(ctx, s.db)
+}
+
+// Write сохраняет значение производной метрики и добавляет его в историю.
+func (s *DBStore) Write(ctx context.Context, r storage.Record) error {
+	if _, err := s.settings.UpdateNew(ctx, s.db, r.MType, r.Name, r.Source, nil, &r.Value, nil); err != nil {
+		return err
+	}
+	storage.AddSample(r.MType, r.Name, r.Source, r.Value)
+	return nil
+}
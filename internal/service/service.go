@@ -2,17 +2,45 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 
 	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/limits"
 	"musthave-metrics/internal/logger"
+	"musthave-metrics/internal/telemetry"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// заголовки HTTP и ключи метаданных gRPC со сведениями об агенте
+const (
+	// AgentIDHeader содержит идентификатор агента.
+	AgentIDHeader = "X-Agent-ID"
+	// AgentVersionHeader содержит версию сборки агента.
+	AgentVersionHeader = "X-Agent-Version"
+	// AgentIntervalHeader содержит интервал отправки метрик агентом в секундах.
+	AgentIntervalHeader = "X-Agent-Report-Interval"
+	// AgentGroupHeader содержит группу агента для конфигурации с сервера.
+	AgentGroupHeader = "X-Agent-Group"
+	// AgentConfigVersionHeader содержит версию применённой агентом конфигурации с сервера.
+	AgentConfigVersionHeader = "X-Agent-Config-Version"
+	// IdempotencyKeyHeader содержит ключ пакета метрик: повторная отправка
+	// пакета с тем же ключом не применяется сервером ещё раз.
+	IdempotencyKeyHeader = "Idempotency-Key"
 )
 
 type HashData struct {
@@ -55,6 +83,11 @@ func MakeBatchUpdatesURL(runAddr string) string {
 	return "http://" + runAddr + "/updates/"
 }
 
+// MakeAgentConfigURL возвращает адрес запроса конфигурации агента с сервера.
+func MakeAgentConfigURL(runAddr string, agentID string) string {
+	return "http://" + runAddr + "/agents/" + url.PathEscape(agentID) + "/config"
+}
+
 func GetHashString(data []byte, key string) string {
 	return base64.URLEncoding.EncodeToString(getHash(data, key))
 }
@@ -72,17 +105,19 @@ func (hd HashData) WithHashVerification(h http.Handler) http.Handler {
 			data, err := io.ReadAll(r.Body)
 			if err != nil {
 				logger.Warnf("HashSHA256 error: " + err.Error())
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), limits.Status(err))
 				return
 			}
 			originalHash := getHash(data, hd.Key)
 			decodeHash, err := base64.URLEncoding.DecodeString(requestHash)
 			if err != nil {
+				telemetry.AuthFailures.Inc("http", telemetry.ReasonSignature)
 				logger.Warnf("HashSHA256 error: " + err.Error())
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			if !hmac.Equal(originalHash, decodeHash) {
+				telemetry.AuthFailures.Inc("http", telemetry.ReasonSignature)
 				logger.Warnf("HashSHA256 error: hash not equal")
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -111,7 +146,7 @@ func (kd KeyData) WithEncrypt(h http.Handler) http.Handler {
 		// decrypt request body
 		data, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), limits.Status(err))
 			return
 		}
 
@@ -130,6 +165,7 @@ func (kd KeyData) WithEncrypt(h http.Handler) http.Handler {
 			decryptBody, err := crypt.Decrypt(kd.PrivateKeyPath, string(data))
 
 			if err != nil {
+				telemetry.AuthFailures.Inc("http", telemetry.ReasonDecrypt)
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
@@ -160,15 +196,18 @@ func (ts TrustedSubnet) WithLookupIP(h http.Handler) http.Handler {
 
 		agentIP := r.Header.Get("X-Real-IP")
 		if agentIP == "" {
+			telemetry.AuthFailures.Inc("http", telemetry.ReasonSubnet)
 			http.Error(w, "header X-Real-IP not found ", http.StatusForbidden)
 			return
 		}
 		trusted, err := FindIPInTrustedSubnet(agentIP, ts.TrustedSubnet)
 		if err != nil {
+			telemetry.AuthFailures.Inc("http", telemetry.ReasonSubnet)
 			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
 		if !trusted {
+			telemetry.AuthFailures.Inc("http", telemetry.ReasonSubnet)
 			http.Error(w, "agent IP not in trusted subnet", http.StatusForbidden)
 			return
 		}
@@ -193,6 +232,7 @@ func GetIP(RunAddr string) net.IP {
 	conn, err := net.Dial("udp", RunAddr)
 	if err != nil {
 		logger.Warnf("GetIP error: " + err.Error())
+		return nil
 	}
 	defer conn.Close()
 
@@ -200,3 +240,53 @@ func GetIP(RunAddr string) net.IP {
 
 	return localAddr.IP
 }
+
+// DefaultAgentID возвращает идентификатор агента из имени хоста и начала machine-id,
+// чтобы агенты на хостах с одинаковыми именами не смешивали метрики.
+func DefaultAgentID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	machineID, err := host.HostID()
+	if err != nil || machineID == "" {
+		return hostname
+	}
+	machineID = strings.ReplaceAll(machineID, "-", "")
+	if len(machineID) > 8 {
+		machineID = machineID[:8]
+	}
+	return hostname + "-" + machineID
+}
+
+// NewIdempotencyKey возвращает случайный ключ для отправки пакета метрик.
+func NewIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Warnf("Idempotency key error: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// AuthorizationHeader содержит токен администратора в виде "Bearer <токен>".
+// В gRPC токен передаётся в метаданных authorization.
+const AuthorizationHeader = "Authorization"
+
+var (
+	// ErrAdminDisabled возвращается, если токен администратора на сервере не задан.
+	ErrAdminDisabled = errors.New("admin operations are disabled")
+	// ErrUnauthorized возвращается, если токен администратора не передан или неверен.
+	ErrUnauthorized = errors.New("invalid admin token")
+)
+
+// CheckAdminToken сверяет токен из значения заголовка authorization с токеном администратора token.
+func CheckAdminToken(token string, authorization string) error {
+	if token == "" {
+		return ErrAdminDisabled
+	}
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return ErrUnauthorized
+	}
+	return nil
+}
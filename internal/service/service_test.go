@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -154,3 +156,30 @@ func Example_getHash() {
 	getHash(data, hd.Key)
 
 }
+
+func TestDefaultAgentID(t *testing.T) {
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	id := DefaultAgentID()
+	assert.True(t, strings.HasPrefix(id, hostname))
+	assert.Equal(t, id, DefaultAgentID())
+}
+
+func TestCheckAdminToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          error
+	}{
+		{name: "1", token: "secret", authorization: "Bearer secret"},
+		{name: "2", token: "secret", authorization: "Bearer other", want: ErrUnauthorized},
+		{name: "3", token: "secret", authorization: "secret", want: ErrUnauthorized},
+		{name: "4", token: "", authorization: "Bearer ", want: ErrAdminDisabled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CheckAdminToken(tt.token, tt.authorization))
+		})
+	}
+}
@@ -1,15 +1,27 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// HistorySize — число последних значений, хранимых в истории метрики каждого источника.
+const HistorySize = 120
+
+// ErrStaleUpdate возвращается при получении значения gauge,
+// которое старше уже сохранённого значения того же источника.
+var ErrStaleUpdate = errors.New("stale gauge update")
+
 type Repository interface {
 	Add() error
 	GetValue() (string, error)
 	GetValues() MemStorage
-	AllValuesHTML() string
 }
 
 type MemStorage struct {
@@ -17,7 +29,45 @@ type MemStorage struct {
 	Counters map[string]int64
 }
 
-var storage = newMemStorage()
+// Record хранит значение метрики, полученное от одного источника (агента).
+type Record struct {
+	Source  string    // идентификатор агента, пустой для метрик без источника
+	MType   string    // gauge или counter
+	Name    string    // имя метрики
+	Value   float64   // значение gauge
+	Delta   int64     // накопленное значение counter
+	Updated time.Time // время последнего обновления
+	// Timestamp — время значения gauge по часам источника, нулевое, если источник его не передал
+	Timestamp time.Time
+}
+
+// Sample хранит значение метрики источника в момент времени.
+// Для counter хранится накопленное значение.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+	Value  float64   `json:"value"`
+}
+
+// StaleStats хранит число отклонённых устаревших обновлений gauge.
+type StaleStats struct {
+	Total   int64            `json:"total"`
+	Sources map[string]int64 `json:"sources"`
+}
+
+type recordKey struct {
+	mtype  string
+	name   string
+	source string
+}
+
+var (
+	mu      sync.RWMutex
+	storage = newMemStorage()
+	records = make(map[recordKey]*Record)
+	history = make(map[recordKey][]Sample)
+	stale   = StaleStats{Sources: make(map[string]int64)}
+)
 
 func newMemStorage() MemStorage {
 	return MemStorage{
@@ -26,21 +76,153 @@ func newMemStorage() MemStorage {
 	}
 }
 
+// Records возвращает значения метрик в разрезе источников,
+// упорядоченные по типу, имени и источнику.
+func Records() []Record {
+	mu.RLock()
+	result := make([]Record, 0, len(records))
+	for _, r := range records {
+		result = append(result, *r)
+	}
+	mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.MType != b.MType {
+			return a.MType > b.MType
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Source < b.Source
+	})
+	return result
+}
+
+// History возвращает историю значений метрики в порядке времени.
+// Пустой source возвращает историю всех источников.
+func History(mtype string, name string, source string) []Sample {
+	mu.RLock()
+	var result []Sample
+	for key, samples := range history {
+		if key.mtype != mtype || key.name != name {
+			continue
+		}
+		if source != "" && key.source != source {
+			continue
+		}
+		result = append(result, samples...)
+	}
+	mu.RUnlock()
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.Before(result[j].Time)
+	})
+	return result
+}
+
+// AddSample добавляет значение в историю метрики источника.
+// Используется, когда значение хранится вне памяти (например, в СУБД).
+func AddSample(mtype string, name string, source string, value float64) {
+	mu.Lock()
+	addSample(recordKey{mtype: mtype, name: name, source: source}, time.Now(), value)
+	mu.Unlock()
+}
+
+func addSample(key recordKey, t time.Time, value float64) {
+	samples := history[key]
+	if len(samples) == HistorySize {
+		copy(samples, samples[1:])
+		samples = samples[:HistorySize-1]
+	}
+	history[key] = append(samples, Sample{Time: t, Source: key.source, Value: value})
+}
+
+// Stale возвращает число отклонённых устаревших обновлений gauge, всего и по источникам.
+func Stale() StaleStats {
+	mu.RLock()
+	defer mu.RUnlock()
+	sources := make(map[string]int64, len(stale.Sources))
+	for source, n := range stale.Sources {
+		sources[source] = n
+	}
+	return StaleStats{Total: stale.Total, Sources: sources}
+}
+
+// CountStale учитывает устаревшее обновление, отклонённое вне памяти (например, в СУБД).
+func CountStale(source string) {
+	mu.Lock()
+	countStale(source)
+	mu.Unlock()
+}
+
+func countStale(source string) {
+	stale.Total++
+	stale.Sources[source]++
+}
+
+// UnixMilli переводит время в миллисекундах, переданное клиентом, в time.Time.
+// Для nil возвращает нулевое время.
+func UnixMilli(ms *int64) time.Time {
+	if ms == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(*ms)
+}
+
+func record(key recordKey) *Record {
+	r, ok := records[key]
+	if !ok {
+		r = &Record{Source: key.source, MType: key.mtype, Name: key.name}
+		records[key] = r
+	}
+	return r
+}
+
 type GaugeMetric struct {
-	Name  string
-	Value string
+	Name   string
+	Value  string
+	Source string
+	// Timestamp — время значения по часам источника; нулевое время отключает проверку порядка
+	Timestamp time.Time
 }
 
+// Add сохраняет значение метрики. Значение с временем Timestamp раньше уже сохранённого
+// для того же источника отклоняется с ошибкой ErrStaleUpdate.
 func (metric GaugeMetric) Add() error {
 	val, err := strconv.ParseFloat(metric.Value, 64)
 	if err == nil {
+		now := time.Now()
+		key := recordKey{mtype: "gauge", name: metric.Name, source: metric.Source}
+		mu.Lock()
+		defer mu.Unlock()
+		if r, ok := records[key]; ok && !metric.Timestamp.IsZero() && r.Timestamp.After(metric.Timestamp) {
+			countStale(metric.Source)
+			return ErrStaleUpdate
+		}
 		storage.Gauges[metric.Name] = val
+		r := record(key)
+		r.Value = val
+		r.Updated = now
+		if !metric.Timestamp.IsZero() {
+			r.Timestamp = metric.Timestamp
+		}
+		addSample(key, now, val)
 	}
 	return err
 }
 
+// GetValue возвращает последнее полученное значение метрики,
+// а при заданном Source — значение, полученное от этого источника.
 func (metric GaugeMetric) GetValue() (value string, err error) {
+	mu.RLock()
+	defer mu.RUnlock()
 	val, ok := storage.Gauges[metric.Name]
+	if metric.Source != "" {
+		var r *Record
+		r, ok = records[recordKey{mtype: "gauge", name: metric.Name, source: metric.Source}]
+		if ok {
+			val = r.Value
+		}
+	}
 	if ok {
 		value = strconv.FormatFloat(val, 'g', -1, 64)
 	}
@@ -48,6 +230,8 @@ func (metric GaugeMetric) GetValue() (value string, err error) {
 }
 
 func (metric GaugeMetric) GetValues() MemStorage {
+	mu.RLock()
+	defer mu.RUnlock()
 	g := make(map[string]float64, len(storage.Gauges))
 	for name, val := range storage.Gauges {
 		g[name] = val
@@ -55,28 +239,41 @@ func (metric GaugeMetric) GetValues() MemStorage {
 	return MemStorage{Gauges: g}
 }
 
-func (metric GaugeMetric) AllValuesHTML() (rows string) {
-	for name, val := range storage.Gauges {
-		rows += fmt.Sprintf("<tr><th>%v</th><th>%v</th></tr>", name, val)
-	}
-	return rows
-}
-
 type CounterMetric struct {
-	Name  string
-	Value string
+	Name   string
+	Value  string
+	Source string
 }
 
 func (metric CounterMetric) Add() error {
 	val, err := strconv.ParseInt(metric.Value, 10, 64)
 	if err == nil {
+		now := time.Now()
+		key := recordKey{mtype: "counter", name: metric.Name, source: metric.Source}
+		mu.Lock()
 		storage.Counters[metric.Name] += val
+		r := record(key)
+		r.Delta += val
+		r.Updated = now
+		addSample(key, now, float64(r.Delta))
+		mu.Unlock()
 	}
 	return err
 }
 
+// GetValue возвращает сумму значений метрики по всем источникам,
+// а при заданном Source — значение, накопленное этим источником.
 func (metric CounterMetric) GetValue() (value string, err error) {
+	mu.RLock()
+	defer mu.RUnlock()
 	val, ok := storage.Counters[metric.Name]
+	if metric.Source != "" {
+		var r *Record
+		r, ok = records[recordKey{mtype: "counter", name: metric.Name, source: metric.Source}]
+		if ok {
+			val = r.Delta
+		}
+	}
 	if ok {
 		value = strconv.FormatInt(val, 10)
 	}
@@ -84,6 +281,8 @@ func (metric CounterMetric) GetValue() (value string, err error) {
 }
 
 func (metric CounterMetric) GetValues() MemStorage {
+	mu.RLock()
+	defer mu.RUnlock()
 	c := make(map[string]int64, len(storage.Counters))
 	for name, val := range storage.Counters {
 		c[name] = val
@@ -91,9 +290,119 @@ func (metric CounterMetric) GetValues() MemStorage {
 	return MemStorage{Counters: c}
 }
 
-func (metric CounterMetric) AllValuesHTML() (rows string) {
-	for name, val := range storage.Counters {
-		rows += fmt.Sprintf("<tr><th>%v</th><th>%v</th></tr>", name, val)
+// ErrBadFilter возвращается для фильтра с неизвестным типом или некорректным шаблоном имени.
+var ErrBadFilter = errors.New("invalid filter")
+
+// Filter отбирает метрики для удаления и сброса.
+type Filter struct {
+	MType   string // gauge или counter, пустой — метрики обоих типов
+	Pattern string // имя метрики или шаблон имени: * — любая строка, ? — любой символ
+	Source  string // идентификатор агента, пустой — все источники
+}
+
+// Validate проверяет тип и шаблон имени.
+func (f Filter) Validate() error {
+	if f.MType != "" && f.MType != "gauge" && f.MType != "counter" {
+		return fmt.Errorf("%w: unknown metric type %q", ErrBadFilter, f.MType)
+	}
+	if f.Pattern == "" {
+		return fmt.Errorf("%w: metric name or pattern is required", ErrBadFilter)
+	}
+	for _, c := range f.Pattern {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_.:-*?", c)
+		if !ok {
+			return fmt.Errorf("%w: pattern may contain only letters, digits, _.:- and wildcards *?", ErrBadFilter)
+		}
+	}
+	return nil
+}
+
+// Match сообщает, подходит ли метрика под фильтр.
+func (f Filter) Match(mtype string, name string, source string) bool {
+	if f.MType != "" && f.MType != mtype {
+		return false
+	}
+	if f.Source != "" && f.Source != source {
+		return false
+	}
+	ok, err := path.Match(f.Pattern, name)
+	return ok && err == nil
+}
+
+func (f Filter) match(key recordKey) bool {
+	return f.Match(key.mtype, key.name, key.source)
+}
+
+// Delete удаляет подходящие под фильтр значения метрик вместе с историей
+// и возвращает удалённые значения.
+func Delete(f Filter) []Record {
+	mu.Lock()
+	defer mu.Unlock()
+	var removed []Record
+	for key, r := range records {
+		if f.match(key) {
+			removed = append(removed, *r)
+			delete(records, key)
+		}
+	}
+	for key := range history {
+		if f.match(key) {
+			delete(history, key)
+		}
+	}
+	for _, r := range removed {
+		aggregate(r.MType, r.Name)
+	}
+	return removed
+}
+
+// Reset обнуляет подходящие под фильтр значения counter и возвращает их значения до сброса.
+func Reset(f Filter) []Record {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	var reset []Record
+	for key, r := range records {
+		if key.mtype != "counter" || !f.match(key) {
+			continue
+		}
+		reset = append(reset, *r)
+		r.Delta = 0
+		r.Updated = now
+		addSample(key, now, 0)
+	}
+	for _, r := range reset {
+		aggregate(r.MType, r.Name)
+	}
+	return reset
+}
+
+// aggregate пересчитывает значение метрики без учёта источника:
+// для gauge — последнее полученное, для counter — сумму по источникам.
+func aggregate(mtype string, name string) {
+	var (
+		found  bool
+		latest *Record
+		sum    int64
+	)
+	for key, r := range records {
+		if key.mtype != mtype || key.name != name {
+			continue
+		}
+		found = true
+		sum += r.Delta
+		if latest == nil || r.Updated.After(latest.Updated) {
+			latest = r
+		}
+	}
+	switch {
+	case !found && mtype == "gauge":
+		delete(storage.Gauges, name)
+	case !found:
+		delete(storage.Counters, name)
+	case mtype == "gauge":
+		storage.Gauges[name] = latest.Value
+	default:
+		storage.Counters[name] = sum
 	}
-	return rows
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -70,26 +71,138 @@ func TestAddCounterMetric(t *testing.T) {
 	}
 }
 
-func TestCounterMetric_AllValuesHTML(t *testing.T) {
+func TestRecords(t *testing.T) {
+	assert.NoError(t, GaugeMetric{Name: "TestRecordsMemory", Value: "1", Source: "host-a"}.Add())
+	assert.NoError(t, GaugeMetric{Name: "TestRecordsMemory", Value: "2", Source: "host-b"}.Add())
+	assert.NoError(t, CounterMetric{Name: "TestRecordsCount", Value: "3", Source: "host-a"}.Add())
+	assert.NoError(t, CounterMetric{Name: "TestRecordsCount", Value: "4", Source: "host-b"}.Add())
+
 	tests := []struct {
-		name     string
-		metric   CounterMetric
-		wantRows string
+		name   string
+		metric Repository
+		want   string
 	}{
-		{
-			name: "1",
-			metric: CounterMetric{
-				Name:  "TestCounterMetric",
-				Value: "111",
-			},
-			wantRows: "",
-		},
+		{name: "1", metric: GaugeMetric{Name: "TestRecordsMemory", Source: "host-a"}, want: "1"},
+		{name: "2", metric: GaugeMetric{Name: "TestRecordsMemory", Source: "host-b"}, want: "2"},
+		{name: "3", metric: GaugeMetric{Name: "TestRecordsMemory"}, want: "2"},
+		{name: "4", metric: CounterMetric{Name: "TestRecordsCount", Source: "host-a"}, want: "3"},
+		{name: "5", metric: CounterMetric{Name: "TestRecordsCount"}, want: "7"},
+		{name: "6", metric: GaugeMetric{Name: "TestRecordsMemory", Source: "host-c"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.metric.GetValue()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	var sources []string
+	for _, r := range Records() {
+		if r.Name == "TestRecordsMemory" {
+			sources = append(sources, r.Source)
+		}
+	}
+	assert.Equal(t, []string{"host-a", "host-b"}, sources)
+}
+
+func TestHistory(t *testing.T) {
+	for i := 0; i < HistorySize+5; i++ {
+		assert.NoError(t, CounterMetric{Name: "TestHistoryCount", Value: "1", Source: "host-a"}.Add())
+	}
+	AddSample("counter", "TestHistoryCount", "host-b", 10)
+
+	samples := History("counter", "TestHistoryCount", "host-a")
+	assert.Len(t, samples, HistorySize)
+	assert.Equal(t, float64(6), samples[0].Value)
+	assert.Equal(t, float64(HistorySize+5), samples[len(samples)-1].Value)
+
+	assert.Len(t, History("counter", "TestHistoryCount", ""), HistorySize+1)
+	assert.Empty(t, History("gauge", "TestHistoryCount", ""))
+}
+
+func TestGaugeMetricStale(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		value     string
+		timestamp time.Time
+		want      error
+		wantValue string
+	}{
+		{name: "1", value: "1", timestamp: now, want: nil, wantValue: "1"},
+		{name: "2", value: "2", timestamp: now.Add(-time.Second), want: ErrStaleUpdate, wantValue: "1"},
+		{name: "3", value: "3", timestamp: now, want: nil, wantValue: "3"},
+		{name: "4", value: "4", want: nil, wantValue: "4"},
+		{name: "5", value: "5", timestamp: now.Add(-time.Minute), want: ErrStaleUpdate, wantValue: "4"},
+		{name: "6", value: "6", timestamp: now.Add(time.Second), want: nil, wantValue: "6"},
+	}
+	before := Stale()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := GaugeMetric{Name: "TestStaleGauge", Value: tt.value, Source: "host-stale", Timestamp: tt.timestamp}.Add()
+			assert.Equal(t, tt.want, err)
+			value, _ := GaugeMetric{Name: "TestStaleGauge", Source: "host-stale"}.GetValue()
+			assert.Equal(t, tt.wantValue, value)
+		})
+	}
+	after := Stale()
+	assert.Equal(t, before.Total+2, after.Total)
+	assert.Equal(t, int64(2), after.Sources["host-stale"])
+	// значения других источников не отклоняются
+	assert.NoError(t, GaugeMetric{Name: "TestStaleGauge", Value: "7", Source: "host-other", Timestamp: now.Add(-time.Hour)}.Add())
+}
+
+func TestDeleteReset(t *testing.T) {
+	assert.NoError(t, GaugeMetric{Name: "TestDeleteMemory", Value: "1", Source: "host-del-a"}.Add())
+	assert.NoError(t, GaugeMetric{Name: "TestDeleteMemory", Value: "2", Source: "host-del-b"}.Add())
+	assert.NoError(t, GaugeMetric{Name: "TestDeleteOther", Value: "3", Source: "host-del-a"}.Add())
+	assert.NoError(t, CounterMetric{Name: "TestDeleteCount", Value: "3", Source: "host-del-a"}.Add())
+	assert.NoError(t, CounterMetric{Name: "TestDeleteCount", Value: "4", Source: "host-del-b"}.Add())
+
+	removed := Delete(Filter{MType: "gauge", Pattern: "TestDeleteMemory", Source: "host-del-b"})
+	assert.Len(t, removed, 1)
+	value, err := GaugeMetric{Name: "TestDeleteMemory"}.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", value)
+	assert.Empty(t, History("gauge", "TestDeleteMemory", "host-del-b"))
+
+	reset := Reset(Filter{Pattern: "TestDelete*", Source: "host-del-a"})
+	assert.Len(t, reset, 1)
+	value, err = CounterMetric{Name: "TestDeleteCount"}.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", value)
+
+	removed = Delete(Filter{Pattern: "TestDelete*"})
+	assert.Len(t, removed, 4)
+	for _, r := range Records() {
+		assert.NotContains(t, r.Name, "TestDelete")
+	}
+	value, err = GaugeMetric{Name: "TestDeleteMemory"}.GetValue()
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+}
+
+func TestFilterValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  Filter
+		wantErr bool
+	}{
+		{name: "1", filter: Filter{Pattern: "Alloc"}},
+		{name: "2", filter: Filter{MType: "counter", Pattern: "Poll*", Source: "host-a"}},
+		{name: "3", filter: Filter{Pattern: ""}, wantErr: true},
+		{name: "4", filter: Filter{MType: "histogram", Pattern: "Alloc"}, wantErr: true},
+		{name: "5", filter: Filter{Pattern: "Alloc[0-9]"}, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if gotRows := tt.metric.AllValuesHTML(); gotRows == tt.wantRows {
-				t.Errorf("CounterMetric.AllValuesHTML() = %v, want %v", gotRows, tt.wantRows)
+			err := tt.filter.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrBadFilter)
+				return
 			}
+			assert.NoError(t, err)
 		})
 	}
 }
@@ -0,0 +1,60 @@
+package telemetry
+
+import (
+	"strconv"
+	"time"
+)
+
+// Prefix — префикс имён метрик сервера в хранилище метрик. Метрики с этим префиксом не принимаются от агентов.
+const Prefix = "_server."
+
+// метрики сервера в реестре Default
+var (
+	// HTTPRequests — число запросов HTTP по методу, маршруту и коду ответа.
+	HTTPRequests = Default.NewCounter("http_requests_total", "HTTP requests by method, route and status.", "method", "route", "status")
+	// HTTPDuration — длительность обработки запросов HTTP.
+	HTTPDuration = Default.NewHistogram("http_request_duration_seconds", "HTTP request duration by method, route and status.", DefaultBuckets, "method", "route", "status")
+	// GRPCRequests — число вызовов gRPC по методу и коду ответа.
+	GRPCRequests = Default.NewCounter("grpc_requests_total", "gRPC calls by method and code.", "method", "code")
+	// GRPCDuration — длительность вызовов gRPC.
+	GRPCDuration = Default.NewHistogram("grpc_request_duration_seconds", "gRPC call duration by method and code.", DefaultBuckets, "method", "code")
+	// StorageDuration — длительность операций хранилища метрик по хранилищу, операции и результату.
+	StorageDuration = Default.NewHistogram("storage_operation_duration_seconds", "Metric store operation duration by store, operation and result.", DefaultBuckets, "store", "operation", "result")
+	// SnapshotDuration — длительность сохранения метрик в файл.
+	SnapshotDuration = Default.NewHistogram("snapshot_duration_seconds", "Metric snapshot write duration by result.", DefaultBuckets, "result")
+	// AuthFailures — число запросов, отклонённых при проверке подписи, подсети, шифрования или токена.
+	AuthFailures = Default.NewCounter("auth_failures_total", "Rejected requests by transport and reason.", "transport", "reason")
+)
+
+// причины отказа в доступе для AuthFailures
+const (
+	ReasonSignature  = "signature"
+	ReasonSubnet     = "subnet"
+	ReasonDecrypt    = "decrypt"
+	ReasonToken      = "token"
+	ReasonAdminToken = "admin_token"
+)
+
+// ObserveHTTP учитывает запрос HTTP. Пустой маршрут — запрос, не подошедший ни к одному маршруту.
+func ObserveHTTP(method string, route string, status int, d time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	code := strconv.Itoa(status)
+	HTTPRequests.Inc(method, route, code)
+	HTTPDuration.Observe(d.Seconds(), method, route, code)
+}
+
+// ObserveGRPC учитывает вызов gRPC.
+func ObserveGRPC(method string, code string, d time.Duration) {
+	GRPCRequests.Inc(method, code)
+	GRPCDuration.Observe(d.Seconds(), method, code)
+}
+
+// Result возвращает результат операции для меток: ok или error.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,395 @@
+// Package telemetry собирает метрики работы самого сервера: счётчики, гистограммы
+// и значения, вычисляемые при выводе, и выводит их в текстовом формате Prometheus.
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType — тип ответа с метриками в текстовом формате Prometheus.
+const ContentType = "text/plain; version=0.0.4"
+
+// DefaultBuckets — границы гистограмм длительности в секундах.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// типы метрик
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// Label — метка значения метрики.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample — значение метрики с метками. Гистограмма представлена значениями с суффиксами _count и _sum.
+type Sample struct {
+	Name   string
+	Type   string
+	Labels []Label
+	Value  float64
+}
+
+// LabelString возвращает метки через запятую в виде name=value.
+func (s Sample) LabelString() string {
+	parts := make([]string, 0, len(s.Labels))
+	for _, l := range s.Labels {
+		parts = append(parts, l.Name+"="+l.Value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// collector — метрика реестра.
+type collector interface {
+	describe() *desc
+	// write выводит значения в формате Prometheus
+	write(w *bufio.Writer)
+	// samples возвращает значения для записи в хранилище
+	samples() []Sample
+}
+
+// desc описывает метрику.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+// key объединяет значения меток в ключ.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("telemetry: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d *desc) pairs(values []string) []Label {
+	labels := make([]Label, len(values))
+	for i, v := range values {
+		labels[i] = Label{Name: d.labels[i], Value: v}
+	}
+	return labels
+}
+
+// Registry хранит метрики и выводит их значения.
+// Методы Registry и метрик безопасны для одновременного использования из нескольких горутин.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// Default — реестр метрик сервера.
+var Default = NewRegistry()
+
+// NewRegistry создаёт пустой реестр.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) sorted() []collector {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+	sort.SliceStable(collectors, func(i, j int) bool {
+		return collectors[i].describe().name < collectors[j].describe().name
+	})
+	return collectors
+}
+
+// WritePrometheus выводит значения метрик в текстовом формате Prometheus.
+// Метрики с одним именем, например вычисляемые с разными метками, выводятся одним блоком.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	last := ""
+	for _, c := range r.sorted() {
+		d := c.describe()
+		if d.name != last {
+			fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+			last = d.name
+		}
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Samples возвращает текущие значения всех метрик.
+func (r *Registry) Samples() []Sample {
+	var samples []Sample
+	for _, c := range r.sorted() {
+		samples = append(samples, c.samples()...)
+	}
+	return samples
+}
+
+// Handler выводит метрики реестра в текстовом формате Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		if err := r.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Counter — счётчик с метками.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter регистрирует счётчик с метками labels.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name: name, help: help, typ: TypeCounter, labels: labels}, values: make(map[string]*counterValue)}
+	r.register(c)
+	return c
+}
+
+// Inc увеличивает на 1 значение счётчика с метками values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add увеличивает на v значение счётчика с метками values.
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: append([]string(nil), values...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Value возвращает значение счётчика с метками values.
+func (c *Counter) Value(values ...string) float64 {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, ok := c.values[key]; ok {
+		return cv.value
+	}
+	return 0
+}
+
+func (c *Counter) sortedValues() []counterValue {
+	c.mu.Lock()
+	values := make([]counterValue, 0, len(c.values))
+	for _, cv := range c.values {
+		values = append(values, *cv)
+	}
+	c.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return strings.Join(values[i].labels, "\xff") < strings.Join(values[j].labels, "\xff")
+	})
+	return values
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	for _, cv := range c.sortedValues() {
+		writeSample(w, c.name, c.pairs(cv.labels), cv.value)
+	}
+}
+
+func (c *Counter) samples() []Sample {
+	var samples []Sample
+	for _, cv := range c.sortedValues() {
+		samples = append(samples, Sample{Name: c.name, Type: TypeCounter, Labels: c.pairs(cv.labels), Value: cv.value})
+	}
+	return samples
+}
+
+// Histogram — гистограмма с метками.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // число наблюдений по границам buckets, не накопленное
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram регистрирует гистограмму с границами buckets по возрастанию и метками labels.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: TypeHistogram, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogramValue),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe учитывает наблюдение v для значения с метками values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	if i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Count возвращает число наблюдений для значения с метками values.
+func (h *Histogram) Count(values ...string) uint64 {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[key]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *Histogram) sortedValues() []histogramValue {
+	h.mu.Lock()
+	values := make([]histogramValue, 0, len(h.values))
+	for _, hv := range h.values {
+		v := *hv
+		v.counts = append([]uint64(nil), hv.counts...)
+		values = append(values, v)
+	}
+	h.mu.Unlock()
+	sort.Slice(values, func(i, j int) bool {
+		return strings.Join(values[i].labels, "\xff") < strings.Join(values[j].labels, "\xff")
+	})
+	return values
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	for _, hv := range h.sortedValues() {
+		labels := h.pairs(hv.labels)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			writeSample(w, h.name+"_bucket", append(labels, Label{"le", formatFloat(le)}), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", append(labels, Label{"le", "+Inf"}), float64(hv.count))
+		writeSample(w, h.name+"_sum", labels, hv.sum)
+		writeSample(w, h.name+"_count", labels, float64(hv.count))
+	}
+}
+
+func (h *Histogram) samples() []Sample {
+	var samples []Sample
+	for _, hv := range h.sortedValues() {
+		labels := h.pairs(hv.labels)
+		samples = append(samples,
+			Sample{Name: h.name + "_count", Type: TypeCounter, Labels: labels, Value: float64(hv.count)},
+			Sample{Name: h.name + "_sum", Type: TypeCounter, Labels: labels, Value: hv.sum},
+		)
+	}
+	return samples
+}
+
+// funcMetric — значение без меток или с постоянными метками, вычисляемое при выводе.
+type funcMetric struct {
+	desc
+	values []Label
+	fn     func() float64
+}
+
+// NewGaugeFunc регистрирует значение с постоянными метками labels, которое при выводе возвращает fn.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64, labels ...Label) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, typ: TypeGauge}, values: labels, fn: fn})
+}
+
+// NewCounterFunc регистрирует счётчик с постоянными метками labels, значение которого при выводе возвращает fn.
+func (r *Registry) NewCounterFunc(name string, help string, fn func() float64, labels ...Label) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, typ: TypeCounter}, values: labels, fn: fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeSample(w, f.name, f.values, f.fn())
+}
+
+func (f *funcMetric) samples() []Sample {
+	return []Sample{{Name: f.name, Type: f.typ, Labels: f.values, Value: f.fn()}}
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeSample(w *bufio.Writer, name string, labels []Label, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l.Name, labelReplacer.Replace(l.Value))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Flush каждые interval передаёт текущие значения метрик реестра в write, пока не отменён ctx.
+func (r *Registry) Flush(ctx context.Context, interval time.Duration, write func(context.Context, []Sample)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			write(ctx, r.Samples())
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("requests_total", "Requests.", "route", "status")
+	duration := r.NewHistogram("request_duration_seconds", "Request duration.", []float64{.1, 1}, "route")
+	r.NewGaugeFunc("pool_conns", "Connections.", func() float64 { return 3 }, Label{Name: "pool", Value: "metrics"})
+	r.NewCounterFunc("rejected_total", "Rejected.", func() float64 { return 1 }, Label{Name: "limit", Value: "body"})
+	r.NewCounterFunc("rejected_total", "Rejected.", func() float64 { return 2 }, Label{Name: "limit", Value: "batch"})
+
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc("/a", `5"0"0`)
+	duration.Observe(0.05, "/a")
+	duration.Observe(0.5, "/a")
+	duration.Observe(2, "/a")
+	assert.Equal(t, float64(3), requests.Value("/a", "200"))
+	assert.Equal(t, uint64(3), duration.Count("/a"))
+	assert.Panics(t, func() { requests.Inc("/a") })
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WritePrometheus(&buf))
+	assert.Equal(t, `# HELP pool_conns Connections.
+# TYPE pool_conns gauge
+pool_conns{pool="metrics"} 3
+# HELP rejected_total Rejected.
+# TYPE rejected_total counter
+rejected_total{limit="body"} 1
+rejected_total{limit="batch"} 2
+# HELP request_duration_seconds Request duration.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/a",le="0.1"} 1
+request_duration_seconds_bucket{route="/a",le="1"} 2
+request_duration_seconds_bucket{route="/a",le="+Inf"} 3
+request_duration_seconds_sum{route="/a"} 2.55
+request_duration_seconds_count{route="/a"} 3
+# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a",status="200"} 3
+requests_total{route="/a",status="5\"0\"0"} 1
+requests_total{route="/b",status="200"} 1
+`, buf.String())
+
+	samples := r.Samples()
+	require.Len(t, samples, 8)
+	assert.Equal(t, Sample{Name: "request_duration_seconds_count", Type: TypeCounter, Labels: []Label{{"route", "/a"}}, Value: 3}, samples[3])
+	assert.Equal(t, "route=/a,status=200", samples[5].LabelString())
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").Inc()
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "requests_total 1\n")
+}
+
+func TestFlush(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("requests_total", "Requests.").Inc()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	written := make(chan []Sample, 1)
+	go r.Flush(ctx, 10*time.Millisecond, func(ctx context.Context, samples []Sample) {
+		select {
+		case written <- samples:
+		default:
+		}
+	})
+	select {
+	case samples := <-written:
+		assert.Equal(t, []Sample{{Name: "requests_total", Type: TypeCounter, Labels: []Label{}, Value: 1}}, samples)
+	case <-time.After(time.Second):
+		t.Fatal("samples were not written")
+	}
+}
@@ -0,0 +1,446 @@
+// Package metricsclient предназначен для отправки метрик на сервер из Go-приложений
+// без запуска отдельного агента.
+//
+// Клиент накапливает значения в памяти и отправляет их пакетом в фоне
+// с заданным интервалом, при заполнении буфера или по вызову Flush:
+//
+//	c, err := metricsclient.New("localhost:8080", metricsclient.WithHashKey("key"))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	c.Counter("Requests", 1)
+//	c.Gauge("QueueLength", 12)
+package metricsclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+
+	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/proto"
+)
+
+// Transport определяет способ отправки метрик на сервер.
+type Transport int
+
+const (
+	// TransportHTTP отправляет метрики пакетом на /updates/.
+	TransportHTTP Transport = iota
+	// TransportGRPC отправляет метрики вызовом PushProtoMetrics.
+	TransportGRPC
+)
+
+const (
+	// DefaultFlushInterval — интервал фоновой отправки по умолчанию.
+	DefaultFlushInterval = 10 * time.Second
+	// DefaultMaxBatchSize — число накопленных метрик, при котором отправка выполняется досрочно.
+	DefaultMaxBatchSize = 1000
+	// DefaultGRPCAddr — адрес gRPC-сервера по умолчанию.
+	DefaultGRPCAddr = ":3200"
+	// secretToken — токен, который сервер ожидает в метаданных gRPC при шифровании.
+	secretToken = "SecretToken"
+	// closeTimeout ограничивает время финальной отправки при закрытии клиента.
+	closeTimeout = 10 * time.Second
+)
+
+// ErrClosed возвращается при отправке через закрытый клиент.
+var ErrClosed = errors.New("metricsclient: client is closed")
+
+// Metric хранит метрику в формате пакетной отправки сервера.
+type Metric struct {
+	ID    string   `json:"id"`              // имя метрики
+	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
+	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	// Timestamp — время установки значения gauge, unix-время в миллисекундах
+	Timestamp *int64 `json:"timestamp,omitempty"`
+}
+
+// batch — пакет метрик с ключом, по которому сервер отличает повторную отправку.
+type batch struct {
+	key     string
+	metrics []Metric
+}
+
+// gauge хранит значение gauge и время его установки.
+type gauge struct {
+	value float64
+	ts    int64
+}
+
+// Option задаёт параметр клиента.
+type Option func(*Client)
+
+// WithTransport выбирает транспорт отправки.
+func WithTransport(t Transport) Option {
+	return func(c *Client) {
+		c.transport = t
+	}
+}
+
+// WithGRPCAddr задаёт адрес gRPC-сервера.
+func WithGRPCAddr(addr string) Option {
+	return func(c *Client) {
+		c.grpcAddr = addr
+	}
+}
+
+// WithHashKey включает подпись тела запроса HMAC-SHA256 в заголовке HashSHA256.
+func WithHashKey(key string) Option {
+	return func(c *Client) {
+		c.hashKey = key
+	}
+}
+
+// WithPublicKey включает шифрование публичным ключом сервера:
+// тела запроса для HTTP и секретного токена для gRPC.
+func WithPublicKey(path string) Option {
+	return func(c *Client) {
+		c.publicKeyPath = path
+	}
+}
+
+// WithAgentID задаёт идентификатор источника метрик.
+// По умолчанию он формируется из имени хоста и machine-id.
+func WithAgentID(id string) Option {
+	return func(c *Client) {
+		c.agentID = id
+	}
+}
+
+// WithVersion задаёт версию приложения, которую сервер показывает в списке агентов.
+func WithVersion(version string) Option {
+	return func(c *Client) {
+		c.version = version
+	}
+}
+
+// WithFlushInterval задаёт интервал фоновой отправки. Нулевое значение отключает фоновую отправку.
+func WithFlushInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.flushInterval = d
+	}
+}
+
+// WithMaxBatchSize задаёт число накопленных метрик, при котором отправка выполняется досрочно.
+func WithMaxBatchSize(n int) Option {
+	return func(c *Client) {
+		c.maxBatchSize = n
+	}
+}
+
+// WithHTTPClient задаёт HTTP-клиент.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithErrorHandler задаёт обработчик ошибок фоновой отправки.
+func WithErrorHandler(f func(error)) Option {
+	return func(c *Client) {
+		c.onError = f
+	}
+}
+
+// Client накапливает метрики и отправляет их на сервер.
+// Методы Client безопасны для одновременного использования из нескольких горутин.
+type Client struct {
+	addr          string
+	transport     Transport
+	grpcAddr      string
+	hashKey       string
+	publicKeyPath string
+	agentID       string
+	version       string
+	flushInterval time.Duration
+	maxBatchSize  int
+	httpClient    *http.Client
+	onError       func(error)
+
+	mu       sync.Mutex
+	flushMu  sync.Mutex
+	gauges   map[string]gauge
+	counters map[string]int64
+	closed   bool
+	// pending — неотправленный пакет, защищён flushMu
+	pending *batch
+
+	conn   *grpc.ClientConn
+	grpc   proto.MetricServerClient
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	realIP string
+}
+
+// New создаёт клиент для сервера по адресу addr (host:port HTTP-сервера)
+// и запускает фоновую отправку.
+func New(addr string, opts ...Option) (*Client, error) {
+	c := &Client{
+		addr:          addr,
+		grpcAddr:      DefaultGRPCAddr,
+		flushInterval: DefaultFlushInterval,
+		maxBatchSize:  DefaultMaxBatchSize,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		onError:       func(error) {},
+		gauges:        make(map[string]gauge),
+		counters:      make(map[string]int64),
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.agentID == "" {
+		c.agentID = service.DefaultAgentID()
+	}
+	if ip := service.GetIP(addr); ip != nil {
+		c.realIP = ip.String()
+	}
+	if c.transport == TransportGRPC {
+		conn, err := grpc.Dial(c.grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.grpc = proto.NewMetricServerClient(conn)
+	}
+	go c.loop()
+	return c, nil
+}
+
+// Gauge устанавливает значение метрики типа gauge.
+func (c *Client) Gauge(name string, value float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.gauges[name] = gauge{value: value, ts: time.Now().UnixMilli()}
+	c.checkBatchSize()
+}
+
+// Counter прибавляет delta к метрике типа counter.
+func (c *Client) Counter(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.counters[name] += delta
+	c.checkBatchSize()
+}
+
+// checkBatchSize запускает досрочную отправку при заполнении буфера.
+// Вызывается под c.mu.
+func (c *Client) checkBatchSize() {
+	if c.maxBatchSize > 0 && len(c.gauges)+len(c.counters) >= c.maxBatchSize {
+		select {
+		case c.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush отправляет накопленные метрики. При ошибке метрики возвращаются в буфер
+// и будут отправлены при следующей попытке.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.mu.Unlock()
+	return c.flush(ctx)
+}
+
+// Close останавливает фоновую отправку, отправляет накопленные метрики и закрывает соединения.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+	close(c.stop)
+	<-c.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	err := c.flush(ctx)
+	if c.conn != nil {
+		err = errors.Join(err, c.conn.Close())
+	}
+	return err
+}
+
+func (c *Client) loop() {
+	defer close(c.done)
+	var tick <-chan time.Time
+	if c.flushInterval > 0 {
+		ticker := time.NewTicker(c.flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-tick:
+		case <-c.kick:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		if err := c.flush(ctx); err != nil {
+			c.onError(err)
+		}
+		cancel()
+	}
+}
+
+func (c *Client) flush(ctx context.Context) error {
+	// отправки выполняются последовательно, чтобы не нарушить порядок значений gauge
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	// неотправленный пакет повторяется с тем же ключом, поэтому сервер, уже применивший его,
+	// не прибавит значения counter повторно; новые значения ждут его успешной отправки
+	if c.pending != nil {
+		if err := c.push(ctx, *c.pending); err != nil {
+			return err
+		}
+		c.pending = nil
+	}
+
+	c.mu.Lock()
+	gauges, counters := c.gauges, c.counters
+	c.gauges, c.counters = make(map[string]gauge), make(map[string]int64)
+	c.mu.Unlock()
+	if len(gauges)+len(counters) == 0 {
+		return nil
+	}
+
+	b := batch{key: service.NewIdempotencyKey(), metrics: make([]Metric, 0, len(gauges)+len(counters))}
+	for name, val := range counters {
+		delta := val
+		b.metrics = append(b.metrics, Metric{ID: name, MType: "counter", Delta: &delta})
+	}
+	for name, g := range gauges {
+		value, ts := g.value, g.ts
+		b.metrics = append(b.metrics, Metric{ID: name, MType: "gauge", Value: &value, Timestamp: &ts})
+	}
+	if err := c.push(ctx, b); err != nil {
+		c.pending = &b
+		return err
+	}
+	return nil
+}
+
+func (c *Client) push(ctx context.Context, b batch) error {
+	if c.transport == TransportGRPC {
+		return c.pushGRPC(ctx, b)
+	}
+	return c.pushHTTP(ctx, b)
+}
+
+func (c *Client) pushHTTP(ctx context.Context, b batch) error {
+	data := new(bytes.Buffer)
+	gzb := gzip.NewWriter(data)
+	if err := json.NewEncoder(gzb).Encode(b.metrics); err != nil {
+		return err
+	}
+	if err := gzb.Close(); err != nil {
+		return err
+	}
+	body := data.Bytes()
+	if c.publicKeyPath != "" {
+		encrypted, err := crypt.Encrypt(c.publicKeyPath, data.String())
+		if err != nil {
+			return err
+		}
+		body = []byte(encrypted)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, service.MakeBatchUpdatesURL(c.addr), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	request.Header.Set("X-Real-IP", c.realIP)
+	request.Header.Set(service.IdempotencyKeyHeader, b.key)
+	for key, value := range c.agentHeaders() {
+		request.Header.Set(key, value)
+	}
+	if c.hashKey != "" {
+		request.Header.Set("HashSHA256", service.GetHashString(body, c.hashKey))
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("metricsclient: unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+func (c *Client) pushGRPC(ctx context.Context, b batch) error {
+	req := proto.PushProtoMetricsRequest{
+		Metrics:        make([]*proto.Metric, 0, len(b.metrics)),
+		IdempotencyKey: b.key,
+	}
+	for _, m := range b.metrics {
+		req.Metrics = append(req.Metrics, &proto.Metric{ID: m.ID, MType: m.MType, Delta: m.Delta, Value: m.Value, Timestamp: m.Timestamp})
+	}
+	token := secretToken
+	if c.publicKeyPath != "" {
+		encrypted, err := crypt.Encrypt(c.publicKeyPath, secretToken)
+		if err != nil {
+			return err
+		}
+		token = encrypted
+	}
+	// зашифрованный токен в формате PEM содержит переводы строк,
+	// поэтому передаётся в бинарных метаданных
+	md := metadata.New(map[string]string{
+		"X-Real-IP":          c.realIP,
+		"X-Crypto-Key":       c.publicKeyPath,
+		"X-Secret-Token-Bin": token,
+	})
+	for key, value := range c.agentHeaders() {
+		md.Set(key, value)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+	_, err := c.grpc.PushProtoMetrics(ctx, &req, grpc.UseCompressor(grpcgzip.Name))
+	return err
+}
+
+// agentHeaders возвращает сведения о клиенте для реестра агентов сервера.
+func (c *Client) agentHeaders() map[string]string {
+	headers := map[string]string{service.AgentIDHeader: c.agentID}
+	if c.version != "" {
+		headers[service.AgentVersionHeader] = c.version
+	}
+	if seconds := int(c.flushInterval / time.Second); seconds > 0 {
+		headers[service.AgentIntervalHeader] = strconv.Itoa(seconds)
+	}
+	return headers
+}
@@ -0,0 +1,245 @@
+package metricsclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+
+	"musthave-metrics/internal/compress"
+	"musthave-metrics/internal/crypt"
+	"musthave-metrics/internal/service"
+	"musthave-metrics/proto"
+)
+
+// recorder запоминает метрики, полученные тестовым сервером.
+type recorder struct {
+	mu      sync.Mutex
+	metrics []Metric
+	keys    []string
+	status  int
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.keys = append(rec.keys, r.Header.Get(service.IdempotencyKeyHeader))
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+		return
+	}
+	var metrics []Metric
+	if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec.metrics = append(rec.metrics, metrics...)
+}
+
+func (rec *recorder) get() map[string]Metric {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	res := make(map[string]Metric)
+	for _, m := range rec.metrics {
+		if prev, ok := res[m.ID]; ok && m.Delta != nil {
+			delta := *prev.Delta + *m.Delta
+			m.Delta = &delta
+		}
+		res[m.ID] = m
+	}
+	return res
+}
+
+func newHTTPServer(t *testing.T, rec *recorder, hashKey string, privateKey string) *httptest.Server {
+	var h http.Handler = compress.WithGzipEncoding(rec)
+	if privateKey != "" {
+		h = service.NewKeyData(privateKey).WithEncrypt(h)
+	}
+	h = service.NewHashData(hashKey).WithHashVerification(h)
+	mux := http.NewServeMux()
+	mux.Handle("/updates/", h)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClientHTTP(t *testing.T) {
+	rec := &recorder{}
+	ts := newHTTPServer(t, rec, "secret", "")
+	c, err := New(ts.Listener.Addr().String(), WithHashKey("secret"), WithFlushInterval(0))
+	require.NoError(t, err)
+
+	c.Counter("Requests", 1)
+	c.Counter("Requests", 2)
+	c.Gauge("Queue", 1)
+	c.Gauge("Queue", 5)
+	require.NoError(t, c.Flush(context.Background()))
+
+	got := rec.get()
+	assert.Equal(t, int64(3), *got["Requests"].Delta)
+	assert.Equal(t, 5.0, *got["Queue"].Value)
+
+	// пустой буфер не отправляется
+	require.NoError(t, c.Flush(context.Background()))
+	assert.Len(t, rec.metrics, 2)
+
+	require.NoError(t, c.Close())
+	assert.ErrorIs(t, c.Flush(context.Background()), ErrClosed)
+	assert.ErrorIs(t, c.Close(), ErrClosed)
+}
+
+func TestClientRetry(t *testing.T) {
+	rec := &recorder{status: http.StatusInternalServerError}
+	ts := newHTTPServer(t, rec, "", "")
+	c, err := New(ts.Listener.Addr().String(), WithFlushInterval(0))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("Requests", 1)
+	c.Gauge("Queue", 1)
+	assert.Error(t, c.Flush(context.Background()))
+
+	// неотправленный пакет повторяется с тем же ключом перед отправкой новых значений
+	c.Counter("Requests", 1)
+	c.Gauge("Queue", 2)
+	rec.mu.Lock()
+	rec.status = 0
+	rec.mu.Unlock()
+	require.NoError(t, c.Flush(context.Background()))
+	got := rec.get()
+	assert.Equal(t, int64(2), *got["Requests"].Delta)
+	assert.Equal(t, 2.0, *got["Queue"].Value)
+	require.Len(t, rec.keys, 3)
+	assert.NotEmpty(t, rec.keys[0])
+	assert.Equal(t, rec.keys[0], rec.keys[1])
+	assert.NotEqual(t, rec.keys[1], rec.keys[2])
+}
+
+func TestClientBackground(t *testing.T) {
+	rec := &recorder{}
+	ts := newHTTPServer(t, rec, "", "")
+	c, err := New(ts.Listener.Addr().String(), WithFlushInterval(time.Hour), WithMaxBatchSize(2))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("Requests", 1)
+	c.Gauge("Queue", 1)
+	assert.Eventually(t, func() bool {
+		return len(rec.get()) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClientCloseFlushes(t *testing.T) {
+	rec := &recorder{}
+	ts := newHTTPServer(t, rec, "", "")
+	c, err := New(ts.Listener.Addr().String(), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+	c.Gauge("Queue", 7)
+	require.NoError(t, c.Close())
+	assert.Equal(t, 7.0, *rec.get()["Queue"].Value)
+	// после закрытия значения не принимаются
+	c.Gauge("Queue", 8)
+	assert.Equal(t, 7.0, *rec.get()["Queue"].Value)
+}
+
+func TestClientHTTPEncrypted(t *testing.T) {
+	publicKey, privateKey := writeTestKeys(t)
+	rec := &recorder{}
+	ts := newHTTPServer(t, rec, "secret", privateKey)
+	c, err := New(ts.Listener.Addr().String(), WithHashKey("secret"), WithPublicKey(publicKey), WithFlushInterval(0))
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.Counter("Requests", 1)
+	require.NoError(t, c.Flush(context.Background()))
+	assert.Equal(t, int64(1), *rec.get()["Requests"].Delta)
+}
+
+// grpcServer запоминает метрики и метаданные, полученные по gRPC.
+type grpcServer struct {
+	proto.UnimplementedMetricServerServer
+	mu      sync.Mutex
+	metrics []*proto.Metric
+	md      metadata.MD
+}
+
+func (s *grpcServer) PushProtoMetrics(ctx context.Context, in *proto.PushProtoMetricsRequest) (*proto.PushProtoMetricsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	s.metrics = append(s.metrics, in.Metrics...)
+	return &proto.PushProtoMetricsResponse{}, nil
+}
+
+func TestClientGRPC(t *testing.T) {
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	gs := grpc.NewServer()
+	srv := &grpcServer{}
+	proto.RegisterMetricServerServer(gs, srv)
+	go gs.Serve(listen) //nolint
+	defer gs.Stop()
+
+	publicKey, privateKey := writeTestKeys(t)
+	c, err := New(listen.Addr().String(), WithTransport(TransportGRPC), WithGRPCAddr(listen.Addr().String()),
+		WithPublicKey(publicKey), WithFlushInterval(0), WithAgentID("web-01"))
+	require.NoError(t, err)
+	c.Counter("Requests", 3)
+	c.Gauge("Queue", 1.5)
+	require.NoError(t, c.Close())
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.Len(t, srv.metrics, 2)
+	assert.NotEmpty(t, srv.md.Get("X-Real-IP"))
+	assert.Equal(t, []string{"web-01"}, srv.md.Get(service.AgentIDHeader))
+	token, err := crypt.Decrypt(privateKey, srv.md.Get("X-Secret-Token-Bin")[0])
+	require.NoError(t, err)
+	assert.Equal(t, secretToken, token)
+}
+
+// writeTestKeys создаёт сертификат и приватный ключ RSA в формате, который ожидает пакет crypt.
+func writeTestKeys(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	publicKey := filepath.Join(dir, "key.pub")
+	privateKey := filepath.Join(dir, "key")
+	require.NoError(t, os.WriteFile(publicKey, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600))
+	require.NoError(t, os.WriteFile(privateKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	return publicKey, privateKey
+}
+
+func ExampleClient() {
+	c, err := New("localhost:8080", WithHashKey("key"), WithFlushInterval(time.Second))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer c.Close()
+
+	c.Counter("Requests", 1)
+	c.Gauge("QueueLength", 12)
+}
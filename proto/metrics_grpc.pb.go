@@ -20,6 +20,11 @@ const _ = grpc.SupportPackageIsVersion7
 
 const (
 	MetricServer_PushProtoMetrics_FullMethodName = "/metrics.MetricServer/PushProtoMetrics"
+	MetricServer_ListAgents_FullMethodName       = "/metrics.MetricServer/ListAgents"
+	MetricServer_DeleteMetrics_FullMethodName    = "/metrics.MetricServer/DeleteMetrics"
+	MetricServer_ResetCounters_FullMethodName    = "/metrics.MetricServer/ResetCounters"
+	MetricServer_GetMetric_FullMethodName        = "/metrics.MetricServer/GetMetric"
+	MetricServer_ListMetrics_FullMethodName      = "/metrics.MetricServer/ListMetrics"
 )
 
 // MetricServerClient is the client API for MetricServer service.
@@ -27,6 +32,11 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricServerClient interface {
 	PushProtoMetrics(ctx context.Context, in *PushProtoMetricsRequest, opts ...grpc.CallOption) (*PushProtoMetricsResponse, error)
+	ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error)
+	DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error)
+	ResetCounters(ctx context.Context, in *ResetCountersRequest, opts ...grpc.CallOption) (*ResetCountersResponse, error)
+	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error)
 }
 
 type metricServerClient struct {
@@ -47,11 +57,66 @@ func (c *metricServerClient) PushProtoMetrics(ctx context.Context, in *PushProto
 	return out, nil
 }
 
+func (c *metricServerClient) ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error) {
+	cOpts := append([]grpc.CallOption{}, opts...)
+	out := new(ListAgentsResponse)
+	err := c.cc.Invoke(ctx, MetricServer_ListAgents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) DeleteMetrics(ctx context.Context, in *DeleteMetricsRequest, opts ...grpc.CallOption) (*DeleteMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{}, opts...)
+	out := new(DeleteMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricServer_DeleteMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) ResetCounters(ctx context.Context, in *ResetCountersRequest, opts ...grpc.CallOption) (*ResetCountersResponse, error) {
+	cOpts := append([]grpc.CallOption{}, opts...)
+	out := new(ResetCountersResponse)
+	err := c.cc.Invoke(ctx, MetricServer_ResetCounters_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error) {
+	cOpts := append([]grpc.CallOption{}, opts...)
+	out := new(GetMetricResponse)
+	err := c.cc.Invoke(ctx, MetricServer_GetMetric_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServerClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{}, opts...)
+	out := new(ListMetricsResponse)
+	err := c.cc.Invoke(ctx, MetricServer_ListMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricServerServer is the server API for MetricServer service.
 // All implementations must embed UnimplementedMetricServerServer
 // for forward compatibility.
 type MetricServerServer interface {
 	PushProtoMetrics(context.Context, *PushProtoMetricsRequest) (*PushProtoMetricsResponse, error)
+	ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error)
+	DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error)
+	ResetCounters(context.Context, *ResetCountersRequest) (*ResetCountersResponse, error)
+	GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error)
 	mustEmbedUnimplementedMetricServerServer()
 }
 
@@ -65,6 +130,21 @@ type UnimplementedMetricServerServer struct{}
 func (UnimplementedMetricServerServer) PushProtoMetrics(context.Context, *PushProtoMetricsRequest) (*PushProtoMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushProtoMetrics not implemented")
 }
+func (UnimplementedMetricServerServer) ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAgents not implemented")
+}
+func (UnimplementedMetricServerServer) DeleteMetrics(context.Context, *DeleteMetricsRequest) (*DeleteMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMetrics not implemented")
+}
+func (UnimplementedMetricServerServer) ResetCounters(context.Context, *ResetCountersRequest) (*ResetCountersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetCounters not implemented")
+}
+func (UnimplementedMetricServerServer) GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
+}
+func (UnimplementedMetricServerServer) ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
 func (UnimplementedMetricServerServer) mustEmbedUnimplementedMetricServerServer() {}
 func (UnimplementedMetricServerServer) testEmbeddedByValue()                      {}
 
@@ -104,6 +184,96 @@ func _MetricServer_PushProtoMetrics_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricServer_ListAgents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAgentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).ListAgents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_ListAgents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).ListAgents(ctx, req.(*ListAgentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_DeleteMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).DeleteMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_DeleteMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).DeleteMetrics(ctx, req.(*DeleteMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_ResetCounters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetCountersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).ResetCounters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_ResetCounters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).ResetCounters(ctx, req.(*ResetCountersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_GetMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).GetMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_GetMetric_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).GetMetric(ctx, req.(*GetMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricServer_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServerServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricServer_ListMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServerServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricServer_ServiceDesc is the grpc.ServiceDesc for MetricServer service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -115,6 +285,26 @@ var MetricServer_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PushProtoMetrics",
 			Handler:    _MetricServer_PushProtoMetrics_Handler,
 		},
+		{
+			MethodName: "ListAgents",
+			Handler:    _MetricServer_ListAgents_Handler,
+		},
+		{
+			MethodName: "DeleteMetrics",
+			Handler:    _MetricServer_DeleteMetrics_Handler,
+		},
+		{
+			MethodName: "ResetCounters",
+			Handler:    _MetricServer_ResetCounters_Handler,
+		},
+		{
+			MethodName: "GetMetric",
+			Handler:    _MetricServer_GetMetric_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _MetricServer_ListMetrics_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/metrics.proto",